@@ -158,6 +158,16 @@ func (o *OperationPermissions) FilterSchema(schema *ast.Schema) *ast.Schema {
 		newSchema.Types["Subscription"] = newSchema.Subscription
 	}
 
+	// directives are not filtered, the types of their arguments must be kept
+	for _, d := range schema.Directives {
+		for _, a := range d.Arguments {
+			typeName := a.Type.Name()
+			if _, ok := newSchema.Types[typeName]; !ok && schema.Types[typeName] != nil {
+				newSchema.Types[typeName] = schema.Types[typeName]
+			}
+		}
+	}
+
 	return &newSchema
 }
 
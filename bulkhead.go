@@ -0,0 +1,111 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBulkheadQueueTimeout = time.Second
+
+	serviceOverloadedCode = "SERVICE_OVERLOADED"
+)
+
+var (
+	// errServiceOverloaded is returned for requests rejected by the bulkhead
+	// of a service
+	errServiceOverloaded = errors.New("downstream service overloaded")
+	errQueueFull         = fmt.Errorf("%w: request queue full", errServiceOverloaded)
+	errQueueTimeout      = fmt.Errorf("%w: timed out waiting for a request slot", errServiceOverloaded)
+)
+
+// BulkheadConfig limits the number of concurrent requests sent to a service.
+// Requests over the limit wait in a bounded queue.
+type BulkheadConfig struct {
+	// MaxInFlight is the maximum number of concurrent requests to the
+	// service, 0 disables the bulkhead
+	MaxInFlight int `json:"max-in-flight"`
+	// MaxQueue is the number of requests waiting for a slot, requests are
+	// rejected when the queue is full
+	MaxQueue int `json:"max-queue"`
+	// QueueTimeout is how long a request waits for a slot before being
+	// rejected, defaults to 1s
+	QueueTimeout         string        `json:"queue-timeout"`
+	QueueTimeoutDuration time.Duration `json:"-"`
+}
+
+func (c *BulkheadConfig) load() error {
+	if c.MaxInFlight < 0 || c.MaxQueue < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if c.QueueTimeout != "" {
+		var err error
+		c.QueueTimeoutDuration, err = time.ParseDuration(c.QueueTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid queue timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c BulkheadConfig) queueTimeout() time.Duration {
+	if c.QueueTimeoutDuration > 0 {
+		return c.QueueTimeoutDuration
+	}
+	return defaultBulkheadQueueTimeout
+}
+
+// bulkhead limits the concurrent requests to a service
+type bulkhead struct {
+	serviceURL string
+	config     BulkheadConfig
+	slots      chan struct{}
+	queued     int32
+}
+
+func newBulkhead(serviceURL string, config BulkheadConfig) *bulkhead {
+	return &bulkhead{
+		serviceURL: serviceURL,
+		config:     config,
+		slots:      make(chan struct{}, config.MaxInFlight),
+	}
+}
+
+// acquire waits for a request slot, the returned function releases it
+func (b *bulkhead) acquire(ctx context.Context) (func(), error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	default:
+	}
+
+	if int(atomic.AddInt32(&b.queued, 1)) > b.config.MaxQueue {
+		atomic.AddInt32(&b.queued, -1)
+		promServiceRejectionCounter.WithLabelValues(b.serviceURL, "queue-full").Inc()
+		return nil, errQueueFull
+	}
+	promServiceQueueGauge.WithLabelValues(b.serviceURL).Inc()
+	defer func() {
+		atomic.AddInt32(&b.queued, -1)
+		promServiceQueueGauge.WithLabelValues(b.serviceURL).Dec()
+	}()
+
+	timer := time.NewTimer(b.config.queueTimeout())
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		promServiceRejectionCounter.WithLabelValues(b.serviceURL, "queue-timeout").Inc()
+		return nil, errQueueTimeout
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
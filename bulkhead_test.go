@@ -0,0 +1,110 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkhead(t *testing.T) {
+	b := newBulkhead("http://"+t.Name(), BulkheadConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeoutDuration: 50 * time.Millisecond})
+
+	release, err := b.acquire(context.Background())
+	require.NoError(t, err)
+
+	acquired := make(chan error)
+	go func() {
+		release, err := b.acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(promServiceQueueGauge.WithLabelValues(b.serviceURL)) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = b.acquire(context.Background())
+	assert.True(t, errors.Is(err, errQueueFull))
+	assert.Equal(t, 1.0, testutil.ToFloat64(promServiceRejectionCounter.WithLabelValues(b.serviceURL, "queue-full")))
+
+	release()
+	require.NoError(t, <-acquired, "the queued request gets the released slot")
+	assert.Equal(t, 0.0, testutil.ToFloat64(promServiceQueueGauge.WithLabelValues(b.serviceURL)))
+
+	release, err = b.acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+	_, err = b.acquire(context.Background())
+	assert.True(t, errors.Is(err, errQueueTimeout))
+}
+
+func TestBulkheadConfigLoad(t *testing.T) {
+	config := BulkheadConfig{MaxInFlight: 10, QueueTimeout: "100ms"}
+	require.NoError(t, config.load())
+	assert.Equal(t, 100*time.Millisecond, config.queueTimeout())
+	assert.Equal(t, defaultBulkheadQueueTimeout, BulkheadConfig{}.queueTimeout())
+
+	assert.Error(t, (&BulkheadConfig{MaxInFlight: -1}).load())
+	assert.Error(t, (&BulkheadConfig{QueueTimeout: "soon"}).load())
+}
+
+func TestQueryExecutionWithBulkhead(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { movies: [String!] }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movies": ["Alien"]}}`))
+				}),
+			},
+		},
+		query: `{ movies }`,
+	}
+	es := f.setup(t)
+	for _, svc := range es.Services {
+		svc.bulkhead = newBulkhead(svc.ServiceURL, BulkheadConfig{MaxInFlight: 1})
+		// hold the only slot
+		_, err := svc.bulkhead.acquire(context.Background())
+		require.NoError(t, err)
+	}
+
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "downstream service overloaded: request queue full", resp.Errors[0].Message)
+		assert.Equal(t, serviceOverloadedCode, resp.Errors[0].Extensions["code"])
+	})
+}
+
+func TestInFlightLimitMiddleware(t *testing.T) {
+	started, done := make(chan struct{}), make(chan struct{})
+	h := inFlightLimitMiddleware(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-done
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("{}")))
+	<-started
+
+	rejected := testutil.ToFloat64(promHTTPRejectedCounter)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("{}")))
+	close(done)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var resp graphql.Response
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "too many operations in flight", resp.Errors[0].Message)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(promHTTPRejectedCounter))
+}
@@ -0,0 +1,282 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerConsecutiveFailures = 5
+	defaultCircuitBreakerMinimumRequests     = 20
+	defaultCircuitBreakerWindow              = 10 * time.Second
+	defaultCircuitBreakerOpenTimeout         = 30 * time.Second
+
+	circuitOpenCode = "CIRCUIT_OPEN"
+)
+
+// CircuitBreakerState is the state of the circuit breaker of a service
+type CircuitBreakerState string
+
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitBreakerState = "closed"
+	// CircuitOpen fails all requests without sending them
+	CircuitOpen CircuitBreakerState = "open"
+	// CircuitHalfOpen lets a single trial request through, its result closes
+	// or opens the circuit again
+	CircuitHalfOpen CircuitBreakerState = "half-open"
+)
+
+// errCircuitOpen is returned for requests to a service whose circuit breaker
+// is open
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreakerConfig configures the circuit breakers of the services.
+type CircuitBreakerConfig struct {
+	// Enabled enables a circuit breaker per service
+	Enabled bool `json:"enabled"`
+	// ConsecutiveFailures opens the circuit after that many failed requests
+	// in a row, defaults to 5
+	ConsecutiveFailures int `json:"consecutive-failures"`
+	// ErrorRate opens the circuit when the ratio of failed requests over the
+	// window exceeds it, 0 disables it
+	ErrorRate float64 `json:"error-rate"`
+	// MinimumRequests is the number of requests in the window before the
+	// error rate is considered, defaults to 20
+	MinimumRequests int `json:"minimum-requests"`
+	// Window is the period over which the error rate is computed, defaults
+	// to 10s
+	Window         string        `json:"window"`
+	WindowDuration time.Duration `json:"-"`
+	// OpenTimeout is how long the circuit stays open before a trial
+	// request is let through, defaults to 30s
+	OpenTimeout         string        `json:"open-timeout"`
+	OpenTimeoutDuration time.Duration `json:"-"`
+}
+
+func (c *CircuitBreakerConfig) load() error {
+	if c.ConsecutiveFailures < 0 || c.MinimumRequests < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("invalid error rate %v, must be between 0 and 1", c.ErrorRate)
+	}
+	var err error
+	if c.Window != "" {
+		c.WindowDuration, err = time.ParseDuration(c.Window)
+		if err != nil {
+			return fmt.Errorf("invalid window: %w", err)
+		}
+	}
+	if c.OpenTimeout != "" {
+		c.OpenTimeoutDuration, err = time.ParseDuration(c.OpenTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid open timeout: %w", err)
+		}
+	}
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = defaultCircuitBreakerConsecutiveFailures
+	}
+	if c.MinimumRequests == 0 {
+		c.MinimumRequests = defaultCircuitBreakerMinimumRequests
+	}
+	if c.WindowDuration <= 0 {
+		c.WindowDuration = defaultCircuitBreakerWindow
+	}
+	if c.OpenTimeoutDuration <= 0 {
+		c.OpenTimeoutDuration = defaultCircuitBreakerOpenTimeout
+	}
+	return nil
+}
+
+// circuitBreakers holds the circuit breakers of the services, by URL
+type circuitBreakers struct {
+	config   CircuitBreakerConfig
+	mutex    sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(config CircuitBreakerConfig) *circuitBreakers {
+	return &circuitBreakers{
+		config:   config,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+// get returns the circuit breaker of the service, creating it if needed
+func (c *circuitBreakers) get(serviceURL string) *circuitBreaker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	breaker, ok := c.breakers[serviceURL]
+	if !ok {
+		breaker = newCircuitBreaker(serviceURL, c.config)
+		c.breakers[serviceURL] = breaker
+	}
+	return breaker
+}
+
+// state returns the state of the circuit breaker of the service, services
+// without requests yet are closed
+func (c *circuitBreakers) state(serviceURL string) CircuitBreakerState {
+	c.mutex.Lock()
+	breaker, ok := c.breakers[serviceURL]
+	c.mutex.Unlock()
+	if !ok {
+		return CircuitClosed
+	}
+	return breaker.currentState()
+}
+
+type circuitBreaker struct {
+	serviceURL string
+	config     CircuitBreakerConfig
+	now        func() time.Time
+
+	mutex               sync.Mutex
+	state               CircuitBreakerState
+	openedAt            time.Time
+	trialInFlight       bool
+	consecutiveFailures int
+	windowStart         time.Time
+	windowRequests      int
+	windowFailures      int
+}
+
+func newCircuitBreaker(serviceURL string, config CircuitBreakerConfig) *circuitBreaker {
+	b := &circuitBreaker{
+		serviceURL: serviceURL,
+		config:     config,
+		now:        time.Now,
+	}
+	b.setState(CircuitClosed)
+	return b
+}
+
+// allow returns whether a request can be sent. In the half open state only
+// one trial request is allowed at a time.
+func (b *circuitBreaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.updateState()
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+	}
+	return true
+}
+
+// done records the result of an allowed request. Requests cancelled by the
+// caller are not recorded.
+func (b *circuitBreaker) done(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		b.mutex.Lock()
+		b.trialInFlight = false
+		b.mutex.Unlock()
+		return
+	}
+	b.record(isServiceFailure(err))
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.trialInFlight = false
+		if failed {
+			b.open()
+		} else {
+			b.close()
+		}
+		return
+	}
+	if b.state != CircuitClosed {
+		return
+	}
+
+	now := b.now()
+	if now.Sub(b.windowStart) > b.config.WindowDuration {
+		b.windowStart = now
+		b.windowRequests, b.windowFailures = 0, 0
+	}
+	b.windowRequests++
+	if !failed {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.windowFailures++
+	b.consecutiveFailures++
+
+	if b.consecutiveFailures >= b.config.ConsecutiveFailures {
+		b.open()
+		return
+	}
+	if b.config.ErrorRate > 0 && b.windowRequests >= b.config.MinimumRequests &&
+		float64(b.windowFailures)/float64(b.windowRequests) >= b.config.ErrorRate {
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) currentState() CircuitBreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.updateState()
+	return b.state
+}
+
+// updateState moves an open circuit to half open once the open timeout
+// elapsed
+func (b *circuitBreaker) updateState() {
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.config.OpenTimeoutDuration {
+		b.trialInFlight = false
+		b.setState(CircuitHalfOpen)
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(CircuitOpen)
+}
+
+func (b *circuitBreaker) close() {
+	b.consecutiveFailures = 0
+	b.windowStart = b.now()
+	b.windowRequests, b.windowFailures = 0, 0
+	b.setState(CircuitClosed)
+}
+
+func (b *circuitBreaker) setState(state CircuitBreakerState) {
+	b.state = state
+	var value float64
+	switch state {
+	case CircuitHalfOpen:
+		value = 1
+	case CircuitOpen:
+		value = 2
+	}
+	promCircuitBreakerStateGauge.WithLabelValues(b.serviceURL).Set(value)
+}
+
+// isServiceFailure returns whether the error of a request means the service
+// is unavailable: network errors and server errors. GraphQL errors are not
+// failures of the service.
+func isServiceFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *unexpectedStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,138 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCircuitBreaker(t *testing.T, config CircuitBreakerConfig) (*circuitBreaker, *time.Time) {
+	require.NoError(t, config.load())
+	now := time.Now()
+	b := newCircuitBreaker("http://"+t.Name(), config)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	b, now := newTestCircuitBreaker(t, CircuitBreakerConfig{ConsecutiveFailures: 3, OpenTimeout: "30s"})
+
+	for i := 0; i < 2; i++ {
+		require.True(t, b.allow())
+		b.record(true)
+	}
+	require.True(t, b.allow())
+	b.record(false)
+	assert.Equal(t, CircuitClosed, b.currentState(), "a success resets the consecutive failures")
+
+	for i := 0; i < 3; i++ {
+		require.True(t, b.allow())
+		b.record(true)
+	}
+	assert.Equal(t, CircuitOpen, b.currentState())
+	assert.False(t, b.allow())
+	assert.Equal(t, 2.0, testutil.ToFloat64(promCircuitBreakerStateGauge.WithLabelValues(b.serviceURL)))
+
+	*now = now.Add(30 * time.Second)
+	assert.Equal(t, CircuitHalfOpen, b.currentState())
+	assert.True(t, b.allow())
+	assert.False(t, b.allow(), "only one trial request is allowed")
+	b.record(true)
+	assert.Equal(t, CircuitOpen, b.currentState(), "a failed trial opens the circuit again")
+
+	*now = now.Add(30 * time.Second)
+	assert.True(t, b.allow())
+	b.record(false)
+	assert.Equal(t, CircuitClosed, b.currentState())
+	assert.Equal(t, 0.0, testutil.ToFloat64(promCircuitBreakerStateGauge.WithLabelValues(b.serviceURL)))
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b, now := newTestCircuitBreaker(t, CircuitBreakerConfig{ErrorRate: 0.5, MinimumRequests: 4, Window: "10s"})
+
+	b.record(true)
+	b.record(false)
+	b.record(true)
+	assert.Equal(t, CircuitClosed, b.currentState(), "below the minimum number of requests")
+
+	*now = now.Add(11 * time.Second)
+	b.record(true)
+	b.record(false)
+	b.record(false)
+	b.record(false)
+	assert.Equal(t, CircuitClosed, b.currentState(), "the window was reset")
+	b.record(true)
+	b.record(true)
+	assert.Equal(t, CircuitOpen, b.currentState())
+}
+
+func TestCircuitBreakerCancelledTrial(t *testing.T) {
+	b, now := newTestCircuitBreaker(t, CircuitBreakerConfig{ConsecutiveFailures: 1})
+	b.record(true)
+	*now = now.Add(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.True(t, b.allow())
+	b.done(ctx, context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, b.currentState())
+	assert.True(t, b.allow(), "the cancelled trial is not recorded")
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	srv, calls := newFlakyService(t, 2, http.StatusServiceUnavailable)
+	c := NewClient(WithCircuitBreakers(CircuitBreakerConfig{ConsecutiveFailures: 2, OpenTimeoutDuration: time.Minute}))
+	assert.Equal(t, CircuitClosed, c.CircuitBreakerState(srv.URL))
+
+	for i := 0; i < 2; i++ {
+		err := c.Request(context.Background(), srv.URL, NewRequest("{ test }"), nil)
+		assert.Error(t, err)
+	}
+	err := c.Request(context.Background(), srv.URL, NewRequest("{ test }"), nil)
+	assert.True(t, errors.Is(err, errCircuitOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls), "requests are not sent while the circuit is open")
+	assert.Equal(t, CircuitOpen, c.CircuitBreakerState(srv.URL))
+
+	assert.Equal(t, CircuitBreakerState(""), NewClient().CircuitBreakerState(srv.URL))
+}
+
+func TestQueryExecutionWithOpenCircuit(t *testing.T) {
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `type Query { movies: [String!] }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movies": ["Alien"]}}`))
+				}),
+			},
+			{
+				schema: `type Query { cinemas: [String!] }`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}),
+			},
+		},
+		query: `{ movies cinemas }`,
+	}
+	es := f.setup(t)
+	es.GraphqlClient = NewClient(WithCircuitBreakers(CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeoutDuration: time.Minute}))
+
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		require.Len(t, resp.Errors, 1)
+		assert.Nil(t, resp.Errors[0].Extensions["code"])
+	})
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		assert.JSONEq(t, `{"movies": ["Alien"], "cinemas": null}`, string(resp.Data))
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "downstream service unavailable: circuit breaker open", resp.Errors[0].Message)
+		assert.Equal(t, circuitOpenCode, resp.Errors[0].Extensions["code"])
+	})
+}
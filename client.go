@@ -21,9 +21,11 @@ import (
 	"github.com/vektah/gqlparser/v2/ast"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 // GraphQLClient is a GraphQL client.
@@ -31,8 +33,15 @@ type GraphQLClient struct {
 	HTTPClient      *http.Client
 	MaxResponseSize int64
 	UserAgent       string
-
-	tracer trace.Tracer
+	// AutomaticPersistedQueries sends the hash of the document instead of
+	// its text, the text is only sent when the service doesn't know the hash
+	AutomaticPersistedQueries bool
+	// RetryPolicy retries failed requests, nil disables retries
+	RetryPolicy *RetryPolicy
+
+	circuitBreakers *circuitBreakers
+	deduplication   *singleflight.Group
+	tracer          trace.Tracer
 }
 
 // ClientOpt is a function used to set a GraphQL client option
@@ -118,8 +127,47 @@ func WithUserAgent(userAgent string) ClientOpt {
 	}
 }
 
+// WithAutomaticPersistedQueries enables automatic persisted queries for
+// downstream requests.
+func WithAutomaticPersistedQueries() ClientOpt {
+	return func(s *GraphQLClient) {
+		s.AutomaticPersistedQueries = true
+	}
+}
+
+// WithRetryPolicy sets the policy used to retry failed downstream requests.
+func WithRetryPolicy(policy RetryPolicy) ClientOpt {
+	return func(s *GraphQLClient) {
+		s.RetryPolicy = &policy
+	}
+}
+
+// WithCircuitBreakers enables a circuit breaker per service. Requests to a
+// service whose circuit is open fail immediately.
+func WithCircuitBreakers(config CircuitBreakerConfig) ClientOpt {
+	return func(s *GraphQLClient) {
+		s.circuitBreakers = newCircuitBreakers(config)
+	}
+}
+
+// CircuitBreakerState returns the state of the circuit breaker of the
+// service, or an empty string if circuit breakers are disabled.
+func (c *GraphQLClient) CircuitBreakerState(serviceURL string) CircuitBreakerState {
+	if c == nil || c.circuitBreakers == nil {
+		return ""
+	}
+	return c.circuitBreakers.state(serviceURL)
+}
+
 // Request executes a GraphQL request.
 func (c *GraphQLClient) Request(ctx context.Context, url string, request *Request, out interface{}) error {
+	if c.deduplicated(request) {
+		return c.deduplicatedRequest(ctx, url, request, out)
+	}
+	return c.request(ctx, url, request, out)
+}
+
+func (c *GraphQLClient) request(ctx context.Context, url string, request *Request, out interface{}) error {
 	ctx, span := c.tracer.Start(ctx, "GraphQL Request",
 		trace.WithSpanKind(trace.SpanKindClient),
 		trace.WithAttributes(
@@ -141,13 +189,54 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 		return err
 	}
 
+	if c.circuitBreakers != nil {
+		breaker := c.circuitBreakers.get(url)
+		if !breaker.allow() {
+			span.AddEvent("circuit breaker open")
+			return traceErr(errCircuitOpen)
+		}
+		err := c.sendWithRetries(ctx, url, request, out)
+		breaker.done(ctx, err)
+		return traceErr(err)
+	}
+
+	return traceErr(c.sendWithRetries(ctx, url, request, out))
+}
+
+// sendWithRetries sends the request, with retries if the retry policy
+// allows them for this request
+func (c *GraphQLClient) sendWithRetries(ctx context.Context, url string, request *Request, out interface{}) error {
+	if c.RetryPolicy.enabled(request) {
+		attempts, err := c.requestWithRetries(ctx, url, request, out)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int(retryAttemptAttribute, attempts))
+		return err
+	}
+	return c.send(ctx, url, request, out)
+}
+
+// send makes a single attempt of the request
+func (c *GraphQLClient) send(ctx context.Context, url string, request *Request, out interface{}) error {
+	if c.AutomaticPersistedQueries && !request.isMultipart() {
+		err := c.do(ctx, url, request.withPersistedQuery(false), out)
+		if !isPersistedQueryNotFound(err) {
+			return err
+		}
+		trace.SpanFromContext(ctx).AddEvent("persisted query not found")
+		return c.do(ctx, url, request.withPersistedQuery(true), out)
+	}
+
+	return c.do(ctx, url, request, out)
+}
+
+// do sends the request and decodes the response
+func (c *GraphQLClient) do(ctx context.Context, url string, request *Request, out interface{}) error {
 	buf, contentType, err := request.requestBody()
 	if err != nil {
-		return traceErr(fmt.Errorf("unable to encode request body: %w", err))
+		return fmt.Errorf("unable to encode request body: %w", err)
 	}
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
 	if err != nil {
-		return traceErr(fmt.Errorf("unable to create request: %w", err))
+		return fmt.Errorf("unable to create request: %w", err)
 	}
 
 	if request.Headers != nil {
@@ -161,22 +250,32 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 		httpReq.Header.Set("User-Agent", c.UserAgent)
 	}
 
-	res, err := c.HTTPClient.Do(httpReq)
+	httpClient := c.HTTPClient
+	if request.Timeout > 0 {
+		withTimeout := *httpClient
+		withTimeout.Timeout = request.Timeout
+		httpClient = &withTimeout
+	}
+
+	res, err := httpClient.Do(httpReq)
 	if err != nil {
 		if os.IsTimeout(err) {
 			promServiceTimeoutErrorCounter.With(prometheus.Labels{
 				"service": url,
 			}).Inc()
 		}
-		return traceErr(err)
+		return err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return traceErr(fmt.Errorf("unexpected response code: %s", res.Status))
+		return &unexpectedStatusError{code: res.StatusCode, status: res.Status}
 	}
 
 	maxResponseSize := c.MaxResponseSize
+	if request.MaxResponseSize > 0 {
+		maxResponseSize = request.MaxResponseSize
+	}
 	if maxResponseSize == 0 {
 		maxResponseSize = math.MaxInt64
 	}
@@ -193,14 +292,14 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 	if err = json.NewDecoder(&limitReader).Decode(&graphqlResponse); err != nil {
 		if errors.Is(err, io.ErrUnexpectedEOF) {
 			if limitReader.N == 0 {
-				return traceErr(fmt.Errorf("response exceeded maximum size of %d bytes", maxResponseSize))
+				return fmt.Errorf("response exceeded maximum size of %d bytes", maxResponseSize)
 			}
 		}
-		return traceErr(fmt.Errorf("error decoding response: %w", err))
+		return fmt.Errorf("error decoding response: %w", err)
 	}
 
 	if len(graphqlResponse.Errors) > 0 {
-		return traceErr(graphqlResponse.Errors)
+		return graphqlResponse.Errors
 	}
 
 	return nil
@@ -209,10 +308,16 @@ func (c *GraphQLClient) Request(ctx context.Context, url string, request *Reques
 // Request is a GraphQL request.
 type Request struct {
 	OperationType string                 `json:"operationType,omitempty"`
-	Query         string                 `json:"query"`
+	Query         string                 `json:"query,omitempty"`
 	OperationName string                 `json:"operationName,omitempty"`
 	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
 	Headers       http.Header            `json:"-"`
+	// Timeout overrides the timeout of the client for this request
+	Timeout time.Duration `json:"-"`
+	// MaxResponseSize overrides the max response size of the client for
+	// this request
+	MaxResponseSize int64 `json:"-"`
 }
 
 // NewRequest creates a new GraphQL requests from the provided body.
@@ -244,11 +349,58 @@ func (r *Request) WithOperationName(operationName string) *Request {
 	return r
 }
 
+func (r *Request) WithTimeout(timeout time.Duration) *Request {
+	r.Timeout = timeout
+	return r
+}
+
+func (r *Request) WithMaxResponseSize(maxResponseSize int64) *Request {
+	r.MaxResponseSize = maxResponseSize
+	return r
+}
+
 func (r *Request) WithVariables(variables map[string]interface{}) *Request {
 	r.Variables = variables
 	return r
 }
 
+// withPersistedQuery returns a copy of the request with the persisted query
+// extension, the query text is only included if withQuery is true
+func (r *Request) withPersistedQuery(withQuery bool) *Request {
+	result := *r
+	result.Extensions = map[string]interface{}{
+		"persistedQuery": map[string]interface{}{
+			"version":    1,
+			"sha256Hash": queryHash(r.Query),
+		},
+	}
+	for k, v := range r.Extensions {
+		result.Extensions[k] = v
+	}
+	if !withQuery {
+		result.Query = ""
+	}
+	return &result
+}
+
+// isPersistedQueryNotFound returns whether the service doesn't know the
+// persisted query, or doesn't support persisted queries
+func isPersistedQueryNotFound(err error) bool {
+	var gqlErrs GraphqlErrors
+	if !errors.As(err, &gqlErrs) {
+		return false
+	}
+	for _, e := range gqlErrs {
+		code, _ := e.Extensions["code"].(string)
+		switch {
+		case code == "PERSISTED_QUERY_NOT_FOUND", code == "PERSISTED_QUERY_NOT_SUPPORTED",
+			e.Message == "PersistedQueryNotFound", e.Message == "PersistedQueryNotSupported":
+			return true
+		}
+	}
+	return false
+}
+
 // isMultipart returns true if the request contains a graphql.Upload object
 // implying that the downstream request needs to be a multipart/form-data request
 func (r *Request) isMultipart() bool {
@@ -2,6 +2,7 @@ package bramble
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
@@ -102,6 +103,41 @@ func TestGraphqlClient(t *testing.T) {
 		require.Error(t, err)
 		assert.Equal(t, "response exceeded maximum size of 1 bytes", err.Error())
 	})
+
+	t.Run("with automatic persisted queries", func(t *testing.T) {
+		query := "query ($_bramble_ids: [ID!]!) { _result: movies(ids: $_bramble_ids) { title } }"
+		persisted := map[string]string{}
+		var received []Request
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req Request
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			received = append(received, req)
+			hash := persistedQueryHash(req.Extensions)
+			if req.Query == "" {
+				if _, ok := persisted[hash]; !ok {
+					w.Write([]byte(`{"errors": [{"message": "PersistedQueryNotFound", "extensions": {"code": "PERSISTED_QUERY_NOT_FOUND"}}]}`))
+					return
+				}
+			} else {
+				persisted[hash] = req.Query
+			}
+			w.Write([]byte(`{"data": {"_result": [{"title": "Alien"}]}}`))
+		}))
+
+		c := NewClient(WithAutomaticPersistedQueries())
+		for i := 0; i < 2; i++ {
+			var res map[string]interface{}
+			err := c.Request(context.Background(), srv.URL, NewRequest(query), &res)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"_result": []interface{}{map[string]interface{}{"title": "Alien"}}}, res)
+		}
+
+		require.Len(t, received, 3)
+		assert.Empty(t, received[0].Query, "the hash is sent first")
+		assert.Equal(t, query, received[1].Query, "the query is sent when the hash is unknown")
+		assert.Empty(t, received[2].Query)
+		assert.Equal(t, queryHash(query), persistedQueryHash(received[2].Extensions))
+	})
 }
 func TestMultipartClient(t *testing.T) {
 	nestedMap := map[string]any{
@@ -35,39 +35,74 @@ type TimeoutConfig struct {
 
 // Config contains the gateway configuration
 type Config struct {
-	IdFieldName               string        `json:"id-field-name"`
-	GatewayListenAddress      string        `json:"gateway-address"`
-	DisableIntrospection      bool          `json:"disable-introspection"`
-	MetricsListenAddress      string        `json:"metrics-address"`
-	PrivateListenAddress      string        `json:"private-address"`
-	GatewayPort               int           `json:"gateway-port"`
-	MetricsPort               int           `json:"metrics-port"`
-	PrivatePort               int           `json:"private-port"`
-	DefaultTimeouts           TimeoutConfig `json:"default-timeouts"`
-	GatewayTimeouts           TimeoutConfig `json:"gateway-timeouts"`
-	PrivateTimeouts           TimeoutConfig `json:"private-timeouts"`
-	Services                  []string      `json:"services"`
-	LogLevel                  log.Level     `json:"loglevel"`
-	PollInterval              string        `json:"poll-interval"`
+	IdFieldName               string          `json:"id-field-name"`
+	GatewayListenAddress      string          `json:"gateway-address"`
+	DisableIntrospection      bool            `json:"disable-introspection"`
+	MetricsListenAddress      string          `json:"metrics-address"`
+	PrivateListenAddress      string          `json:"private-address"`
+	GatewayPort               int             `json:"gateway-port"`
+	MetricsPort               int             `json:"metrics-port"`
+	PrivatePort               int             `json:"private-port"`
+	DefaultTimeouts           TimeoutConfig   `json:"default-timeouts"`
+	GatewayTimeouts           TimeoutConfig   `json:"gateway-timeouts"`
+	PrivateTimeouts           TimeoutConfig   `json:"private-timeouts"`
+	Services                  []string        `json:"-"`
+	ServiceConfigs            []ServiceConfig `json:"services"`
+	LogLevel                  log.Level       `json:"loglevel"`
+	PollInterval              string          `json:"poll-interval"`
 	PollIntervalDuration      time.Duration
-	MaxRequestsPerQuery       int64           `json:"max-requests-per-query"`
-	MaxServiceResponseSize    int64           `json:"max-service-response-size"`
-	HTTPClientTimeout         string          `json:"http-client-timeout"`
-	HTTPClientTimeoutDuration time.Duration   `json:"-"`
-	MaxFileUploadSize         int64           `json:"max-file-upload-size"`
-	Telemetry                 TelemetryConfig `json:"telemetry"`
+	MaxPollBackoff            string                 `json:"max-poll-backoff"`
+	MaxPollBackoffDuration    time.Duration          `json:"-"`
+	PollVersionCheck          bool                   `json:"poll-version-check"`
+	MaxRequestsPerQuery       int64                  `json:"max-requests-per-query"`
+	MaxServiceResponseSize    int64                  `json:"max-service-response-size"`
+	HTTPClientTimeout         string                 `json:"http-client-timeout"`
+	HTTPClientTimeoutDuration time.Duration          `json:"-"`
+	MaxFileUploadSize         int64                  `json:"max-file-upload-size"`
+	MutationFailurePolicy     MutationFailurePolicy  `json:"mutation-failure-policy"`
+	MaxQueryCost              int                    `json:"max-query-cost"`
+	DefaultFieldCost          int                    `json:"default-field-cost"`
+	DefaultListSize           int                    `json:"default-list-size"`
+	BoundaryBatchSize         int                    `json:"boundary-batch-size"`
+	BoundaryArrayBatchSize    int                    `json:"boundary-array-batch-size"`
+	BoundaryParallelism       int                    `json:"boundary-parallelism"`
+	QueryLimits               QueryLimitsConfig      `json:"query-limits"`
+	PlanCacheSize             int                    `json:"plan-cache-size"`
+	PersistedQueries          PersistedQueriesConfig `json:"persisted-queries"`
+	ResponseCache             ResponseCacheConfig    `json:"response-cache"`
+	EntityCache               EntityCacheConfig      `json:"entity-cache"`
+	Retry                     RetryPolicy            `json:"retry"`
+	CircuitBreaker            CircuitBreakerConfig   `json:"circuit-breaker"`
+	Bulkhead                  BulkheadConfig         `json:"bulkhead"`
+	MaxInFlightOperations     int                    `json:"max-in-flight-operations"`
+	DeduplicateRequests       bool                   `json:"deduplicate-requests"`
+	SchemaChanges             SchemaChangesConfig    `json:"schema-changes"`
+	SchemaSnapshotFile        string                 `json:"schema-snapshot-file"`
+	Health                    HealthConfig           `json:"health"`
+	Drain                     DrainConfig            `json:"drain"`
+	Discovery                 DiscoveryConfig        `json:"discovery"`
+	Telemetry                 TelemetryConfig        `json:"telemetry"`
 	Plugins                   []PluginConfig
 	// Config extensions that can be shared among plugins
 	Extensions map[string]json.RawMessage
 	// HTTP client to customize for downstream services query
 	QueryHTTPClient *http.Client
+	// Store for automatic persisted queries, defaults to an in memory LRU
+	PersistedQueryStore PersistedQueryStore
+	// Store for cached responses, defaults to an in memory LRU
+	ResponseCacheStore ResponseCacheStore
+	// Custom service discoveries, consulted after the configured ones
+	ServiceDiscoveries []ServiceDiscovery `json:"-"`
 
 	plugins          []Plugin
 	executableSchema *ExecutableSchema
+	trustedDocuments *TrustedDocuments
 	watcher          *fsnotify.Watcher
 	tracer           trace.Tracer
 	configFiles      []string
 	linkedFiles      []string
+	// discovered are the services found by each discovery source
+	discovered map[string][]ServiceConfig
 }
 
 func (c *Config) addrOrPort(addr string, port int) string {
@@ -133,6 +168,13 @@ func (c *Config) Load() error {
 		return fmt.Errorf("invalid poll interval: %w", err)
 	}
 
+	if c.MaxPollBackoff != "" {
+		c.MaxPollBackoffDuration, err = time.ParseDuration(c.MaxPollBackoff)
+		if err != nil {
+			return fmt.Errorf("invalid max poll backoff: %w", err)
+		}
+	}
+
 	c.HTTPClientTimeoutDuration, err = time.ParseDuration(c.HTTPClientTimeout)
 	if err != nil {
 		return fmt.Errorf("invalid http client timeout: %w", err)
@@ -157,11 +199,79 @@ func (c *Config) Load() error {
 		return err
 	}
 
+	switch c.MutationFailurePolicy {
+	case MutationFailureContinue, MutationFailureAbort:
+	default:
+		return fmt.Errorf("invalid mutation failure policy %q", c.MutationFailurePolicy)
+	}
+
+	if c.MaxQueryCost < 0 || c.DefaultFieldCost < 0 || c.DefaultListSize < 0 {
+		return fmt.Errorf("query cost settings must not be negative")
+	}
+
+	if c.BoundaryBatchSize < 0 || c.BoundaryArrayBatchSize < 0 || c.BoundaryParallelism < 0 {
+		return fmt.Errorf("boundary batching settings must not be negative")
+	}
+
+	if err := c.QueryLimits.validate(); err != nil {
+		return fmt.Errorf("invalid query limits: %w", err)
+	}
+
+	if c.PersistedQueries.CacheSize < 0 {
+		return fmt.Errorf("invalid persisted queries cache size %d", c.PersistedQueries.CacheSize)
+	}
+
+	if c.ResponseCache.Size < 0 {
+		return fmt.Errorf("invalid response cache size %d", c.ResponseCache.Size)
+	}
+
+	if err := c.EntityCache.load(); err != nil {
+		return fmt.Errorf("invalid entity cache: %w", err)
+	}
+
+	if err := c.Retry.load(); err != nil {
+		return fmt.Errorf("invalid retry policy: %w", err)
+	}
+
+	if err := c.CircuitBreaker.load(); err != nil {
+		return fmt.Errorf("invalid circuit breaker: %w", err)
+	}
+
+	if err := c.Bulkhead.load(); err != nil {
+		return fmt.Errorf("invalid bulkhead: %w", err)
+	}
+
+	if c.MaxInFlightOperations < 0 {
+		return fmt.Errorf("invalid max in flight operations %d", c.MaxInFlightOperations)
+	}
+
+	if err := c.SchemaChanges.load(); err != nil {
+		return fmt.Errorf("invalid schema changes: %w", err)
+	}
+
+	if err := c.Drain.load(); err != nil {
+		return fmt.Errorf("invalid drain: %w", err)
+	}
+
+	if err := c.Discovery.load(); err != nil {
+		return fmt.Errorf("invalid discovery: %w", err)
+	}
+	for _, dir := range c.Discovery.Directories {
+		if c.watcher != nil {
+			if err := c.watcher.Add(dir); err != nil {
+				return fmt.Errorf("error adding discovery directory to watcher: %w", err)
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Discovery.interval())
+	c.discoverServices(ctx)
+	cancel()
+
 	services, err := c.buildServiceList()
 	if err != nil {
 		return err
 	}
-	c.Services = services
+	c.ServiceConfigs = services
 
 	c.plugins = c.ConfigurePlugins()
 
@@ -200,35 +310,112 @@ func (c *Config) loadTimeouts(config *TimeoutConfig, name string, defaults Timeo
 	return nil
 }
 
-func (c *Config) buildServiceList() ([]string, error) {
+// serviceDefaults fills the settings of the service that are not set with the
+// global settings
+func (c *Config) serviceDefaults(service ServiceConfig) ServiceConfig {
+	if service.PollIntervalDuration == 0 {
+		service.PollIntervalDuration = c.PollIntervalDuration
+	}
+	if service.MaxPollBackoffDuration == 0 {
+		service.MaxPollBackoffDuration = c.MaxPollBackoffDuration
+	}
+	if service.VersionCheck == nil && c.PollVersionCheck {
+		versionCheck := true
+		service.VersionCheck = &versionCheck
+	}
+	if service.Bulkhead == nil && c.Bulkhead.MaxInFlight > 0 {
+		bulkhead := c.Bulkhead
+		service.Bulkhead = &bulkhead
+	}
+	return service
+}
+
+func (c *Config) buildServiceList() ([]ServiceConfig, error) {
+	var services []ServiceConfig
 	serviceSet := map[string]bool{}
+	add := func(service ServiceConfig) error {
+		if serviceSet[service.URL] {
+			return nil
+		}
+		if err := service.load(); err != nil {
+			return err
+		}
+		serviceSet[service.URL] = true
+		services = append(services, c.serviceDefaults(service))
+		return nil
+	}
+
+	for _, service := range c.ServiceConfigs {
+		// discovered by a previous build of the list
+		if service.Source != "" {
+			continue
+		}
+		if err := add(service); err != nil {
+			return nil, err
+		}
+	}
 	for _, service := range c.Services {
-		serviceSet[service] = true
+		if err := add(ServiceConfig{URL: service}); err != nil {
+			return nil, err
+		}
 	}
 	for _, service := range strings.Fields(os.Getenv("BRAMBLE_SERVICE_LIST")) {
-		serviceSet[service] = true
+		if err := add(ServiceConfig{URL: service}); err != nil {
+			return nil, err
+		}
 	}
 	for _, plugin := range c.plugins {
 		ok, path := plugin.GraphqlQueryPath()
 		if ok {
-			service := c.PrivateHttpAddress(path)
-			serviceSet[service] = true
+			if err := add(ServiceConfig{URL: c.PrivateHttpAddress(path)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, discovery := range c.serviceDiscoveries() {
+		for _, service := range c.discovered[discovery.Source()] {
+			if err := add(service); err != nil {
+				return nil, err
+			}
 		}
 	}
-	services := []string{}
-	for service := range serviceSet {
-		services = append(services, service)
+
+	enabled := services[:0]
+	for _, service := range services {
+		if service.enabled() {
+			enabled = append(enabled, service)
+		}
 	}
-	if len(services) == 0 {
+	if len(enabled) == 0 {
 		return nil, fmt.Errorf("no services found in BRAMBLE_SERVICE_LIST or %s", c.configFiles)
 	}
-	return services, nil
+	return enabled, nil
 }
 
-// Watch starts watching the config files for change.
+// schemaPollInterval returns the interval at which the services are polled,
+// the shortest of the poll intervals of the services
+func (c *Config) schemaPollInterval() time.Duration {
+	interval := c.PollIntervalDuration
+	for _, service := range c.ServiceConfigs {
+		if service.PollIntervalDuration > 0 && service.PollIntervalDuration < interval {
+			interval = service.PollIntervalDuration
+		}
+	}
+	return interval
+}
+
+// Watch starts watching the config files and the discovery directories for
+// change, the services are also discovered again at the discovery interval.
 func (c *Config) Watch() {
+	discover := time.NewTimer(c.Discovery.interval())
+	defer discover.Stop()
 	for {
 		select {
+		case <-discover.C:
+			if err := c.rediscover(); err != nil {
+				log.With("error", err).Error("failed discovering services")
+			}
+			discover.Reset(c.Discovery.interval())
 		case err := <-c.watcher.Errors:
 			log.With("error", err).Error("config watch error")
 		case e := <-c.watcher.Events:
@@ -254,6 +441,13 @@ func (c *Config) Watch() {
 				}
 			}
 
+			if !shouldUpdate && c.isDiscoveryEvent(e.Name) {
+				if err := c.rediscover(); err != nil {
+					log.With("error", err).Error("failed discovering services")
+				}
+				continue
+			}
+
 			if !shouldUpdate {
 				log.Debug("nothing to update")
 				continue
@@ -281,15 +475,27 @@ func (c *Config) reload() error {
 		return fmt.Errorf("failed loading config")
 	}
 
-	log.With("services", c.Services).Info("config file updated")
+	log.With("services", serviceURLs(c.ServiceConfigs)).Info("config file updated")
+
+	// an invalid manifest keeps the previous trusted documents, it doesn't
+	// prevent the services from being updated
+	var manifestErr error
+	if c.trustedDocuments != nil {
+		if err := c.trustedDocuments.Reload(c.PersistedQueries); err != nil {
+			manifestErr = fmt.Errorf("failed reloading trusted documents: %w", err)
+			log.With("error", err).Error("failed reloading trusted documents")
+		} else {
+			log.With("manifest", c.PersistedQueries.TrustedDocuments).Info("trusted documents updated")
+		}
+	}
 
-	if err := c.executableSchema.UpdateServiceList(ctx, c.Services); err != nil {
+	if err := c.executableSchema.UpdateServices(ctx, c.ServiceConfigs); err != nil {
 		return fmt.Errorf("failed updating services")
 	}
 
-	log.With("services", c.Services).Info("updated services")
+	log.With("services", serviceURLs(c.ServiceConfigs)).Info("updated services")
 
-	return nil
+	return manifestErr
 }
 
 // GetConfig returns operational config for the gateway
@@ -320,9 +526,19 @@ func GetConfig(configFiles []string) (*Config, error) {
 		MetricsPort:            9009,
 		LogLevel:               log.LevelDebug,
 		PollInterval:           "10s",
+		MaxPollBackoff:         "5m",
 		MaxRequestsPerQuery:    50,
 		MaxServiceResponseSize: 1024 * 1024,
 		HTTPClientTimeout:      "5s",
+		MutationFailurePolicy:  MutationFailureContinue,
+		DefaultFieldCost:       DefaultFieldCost,
+		DefaultListSize:        DefaultListSize,
+		BoundaryBatchSize:      defaultBoundaryBatchSize,
+		BoundaryParallelism:    defaultBoundaryParallelism,
+		SchemaChanges: SchemaChangesConfig{
+			Policy:      SchemaChangesLog,
+			UsageWindow: "168h",
+		},
 
 		watcher:     watcher,
 		tracer:      otel.GetTracerProvider().Tracer(instrumentationName),
@@ -358,7 +574,7 @@ func (c *Config) ConfigurePlugins() []Plugin {
 // Init initializes the config and does an initial fetch of the services.
 func (c *Config) Init() error {
 	var err error
-	c.Services, err = c.buildServiceList()
+	c.ServiceConfigs, err = c.buildServiceList()
 	if err != nil {
 		return fmt.Errorf("error building service list: %w", err)
 	}
@@ -371,8 +587,8 @@ func (c *Config) Init() error {
 	}
 
 	var services []*Service
-	for _, s := range c.Services {
-		services = append(services, NewService(s, serviceClientOptions...))
+	for _, s := range c.ServiceConfigs {
+		services = append(services, NewServiceFromConfig(s, serviceClientOptions...))
 	}
 
 	queryClientOptions := []ClientOpt{
@@ -384,8 +600,54 @@ func (c *Config) Init() error {
 	if c.QueryHTTPClient != nil {
 		queryClientOptions = append(queryClientOptions, WithHTTPClient(c.QueryHTTPClient))
 	}
+	if c.PersistedQueries.Downstream {
+		queryClientOptions = append(queryClientOptions, WithAutomaticPersistedQueries())
+	}
+	if c.Retry.MaxAttempts > 1 {
+		queryClientOptions = append(queryClientOptions, WithRetryPolicy(c.Retry))
+	}
+	if c.CircuitBreaker.Enabled {
+		queryClientOptions = append(queryClientOptions, WithCircuitBreakers(c.CircuitBreaker))
+	}
+	if c.DeduplicateRequests {
+		queryClientOptions = append(queryClientOptions, WithRequestDeduplication())
+	}
 	queryClient := NewClientWithPlugins(c.plugins, queryClientOptions...)
 	es := NewExecutableSchema(c.plugins, c.MaxRequestsPerQuery, queryClient, services...)
+	es.MutationFailurePolicy = c.MutationFailurePolicy
+	es.MaxQueryCost = c.MaxQueryCost
+	es.DefaultFieldCost = c.DefaultFieldCost
+	es.DefaultListSize = c.DefaultListSize
+	if c.BoundaryBatchSize > 0 {
+		es.BoundaryBatchSize = c.BoundaryBatchSize
+	}
+	es.BoundaryArrayBatchSize = c.BoundaryArrayBatchSize
+	if c.BoundaryParallelism > 0 {
+		es.BoundaryParallelism = c.BoundaryParallelism
+	}
+	es.QueryLimits = c.QueryLimits
+	es.SetPlanCacheSize(c.PlanCacheSize)
+	es.SetSchemaChanges(c.SchemaChanges)
+	es.SchemaSnapshotFile = c.SchemaSnapshotFile
+	es.RequiredServices = c.Health.RequiredServices
+	es.serviceDefaults = c.serviceDefaults
+	if c.ResponseCache.Enabled {
+		if c.ResponseCacheStore == nil {
+			size := c.ResponseCache.Size
+			if size == 0 {
+				size = defaultResponseCacheSize
+			}
+			c.ResponseCacheStore = NewMemoryResponseCacheStore(size)
+		}
+		es.ResponseCache = c.ResponseCacheStore
+	}
+	if len(c.EntityCache.TTLDurations) > 0 {
+		size := c.EntityCache.Size
+		if size == 0 {
+			size = defaultEntityCacheSize
+		}
+		es.SetEntityCache(size, c.EntityCache.TTLDurations)
+	}
 	err = es.UpdateSchema(context.Background(), true)
 	if err != nil {
 		return err
@@ -393,6 +655,18 @@ func (c *Config) Init() error {
 
 	c.executableSchema = es
 
+	c.trustedDocuments, err = NewTrustedDocuments(c.PersistedQueries)
+	if err != nil {
+		return err
+	}
+	if c.PersistedQueries.Automatic && c.PersistedQueryStore == nil {
+		size := c.PersistedQueries.CacheSize
+		if size == 0 {
+			size = defaultPersistedQueryCacheSize
+		}
+		c.PersistedQueryStore = NewLRUPersistedQueryStore(size)
+	}
+
 	var pluginsNames []string
 	for _, plugin := range c.plugins {
 		plugin.Init(c.executableSchema)
@@ -10,6 +10,9 @@ type brambleContextKey int
 
 const permissionsContextKey brambleContextKey = 1
 const requestHeaderContextKey brambleContextKey = 2
+const roleContextKey brambleContextKey = 3
+const subjectContextKey brambleContextKey = 4
+const cachePolicyContextKey brambleContextKey = 5
 
 // AddPermissionsToContext adds permissions to the request context. If
 // permissions are set the execution will check them against the query.
@@ -31,6 +34,31 @@ func GetPermissionsFromContext(ctx context.Context) (OperationPermissions, bool)
 	return OperationPermissions{}, false
 }
 
+// AddRoleToContext adds the role of the client to the request context. The
+// role selects the query limits applying to the request.
+func AddRoleToContext(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey, role)
+}
+
+// GetRoleFromContext returns the role stored in the context
+func GetRoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleContextKey).(string)
+	return role, ok
+}
+
+// AddSubjectToContext adds the authenticated subject to the request context.
+// Responses with a private cache policy are only cached for requests with a
+// subject.
+func AddSubjectToContext(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectContextKey, subject)
+}
+
+// GetSubjectFromContext returns the subject stored in the context
+func GetSubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectContextKey).(string)
+	return subject, ok
+}
+
 // AddOutgoingRequestsHeaderToContext adds a header to all outgoings requests for the current query
 func AddOutgoingRequestsHeaderToContext(ctx context.Context, key, value string) context.Context {
 	h, ok := ctx.Value(requestHeaderContextKey).(http.Header)
@@ -19,3 +19,21 @@ func TestContextOutgoingRequestHeaders(t *testing.T) {
 		"My-Header-2": []string{"value3"},
 	}, header)
 }
+
+func TestContextRole(t *testing.T) {
+	_, ok := GetRoleFromContext(context.Background())
+	assert.False(t, ok)
+
+	role, ok := GetRoleFromContext(AddRoleToContext(context.Background(), "admin"))
+	assert.True(t, ok)
+	assert.Equal(t, "admin", role)
+}
+
+func TestContextSubject(t *testing.T) {
+	_, ok := GetSubjectFromContext(context.Background())
+	assert.False(t, ok)
+
+	subject, ok := GetSubjectFromContext(AddSubjectToContext(context.Background(), "user-1"))
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", subject)
+}
@@ -0,0 +1,177 @@
+package bramble
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	costDirectiveName     = "cost"
+	listSizeDirectiveName = "listSize"
+
+	// DefaultFieldCost is the default cost of a field returning a composite type
+	DefaultFieldCost = 1
+	// DefaultListSize is the default number of items assumed for a list
+	DefaultListSize = 10
+
+	queryCostExceededCode = "QUERY_COST_EXCEEDED"
+)
+
+// costAnalysis computes the static cost of an operation.
+//
+// The cost of a field is its weight plus the cost of its selection set,
+// multiplied by the assumed size of the list if the field returns a list.
+// The weight is taken from the @cost directive on the field or its type,
+// fields returning composite types default to defaultFieldCost and fields
+// returning leaf types are free. The list size is taken from the slicing
+// arguments or the assumed size of the @listSize directive, and defaults to
+// defaultListSize. Fragments are counted in full, regardless of their type
+// condition.
+type costAnalysis struct {
+	schema           *ast.Schema
+	variables        map[string]interface{}
+	defaultFieldCost int
+	defaultListSize  int
+}
+
+func (c *costAnalysis) selectionSetCost(selectionSet ast.SelectionSet) int {
+	var cost int
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost = addCost(cost, c.fieldCost(selection))
+		case *ast.InlineFragment:
+			cost = addCost(cost, c.selectionSetCost(selection.SelectionSet))
+		case *ast.FragmentSpread:
+			cost = addCost(cost, c.selectionSetCost(selection.Definition.SelectionSet))
+		}
+	}
+	return cost
+}
+
+func (c *costAnalysis) fieldCost(field *ast.Field) int {
+	if isGraphQLBuiltinName(field.Name) || field.Definition == nil {
+		return 0
+	}
+	weight := fieldWeight(c.schema, field.Definition, c.defaultFieldCost)
+	childCost := c.selectionSetCost(field.SelectionSet)
+	size := fieldListSize(field.Definition, field.ArgumentMap(c.variables), c.defaultListSize)
+	return multiplyCost(size, addCost(weight, childCost))
+}
+
+// fieldWeight returns the weight of the field, without its children
+func fieldWeight(schema *ast.Schema, field *ast.FieldDefinition, defaultFieldCost int) int {
+	if weight, ok := costWeight(field.Directives); ok {
+		return weight
+	}
+	typ := schema.Types[field.Type.Name()]
+	if typ == nil {
+		return 0
+	}
+	if weight, ok := costWeight(typ.Directives); ok {
+		return weight
+	}
+	if typ.IsCompositeType() {
+		return defaultFieldCost
+	}
+	return 0
+}
+
+// hasCostDirectives returns whether the services declare the cost directives
+func hasCostDirectives(schema *ast.Schema) bool {
+	return schema.Directives[costDirectiveName] != nil || schema.Directives[listSizeDirectiveName] != nil
+}
+
+func costWeight(directives ast.DirectiveList) (int, bool) {
+	d := directives.ForName(costDirectiveName)
+	if d == nil {
+		return 0, false
+	}
+	return intArgument(directiveArgument(d, "weight"))
+}
+
+func directiveArgument(d *ast.Directive, name string) interface{} {
+	arg := d.Arguments.ForName(name)
+	if arg == nil || arg.Value == nil {
+		return nil
+	}
+	value, err := arg.Value.Value(nil)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// fieldListSize returns the number of items assumed to be returned by the
+// field, or 1 if the field does not return a list
+func fieldListSize(field *ast.FieldDefinition, args map[string]interface{}, defaultListSize int) int {
+	if field.Type.Elem == nil {
+		return 1
+	}
+	d := field.Directives.ForName(listSizeDirectiveName)
+	if d == nil {
+		return defaultListSize
+	}
+	size, sliced := 0, false
+	if slicingArguments, ok := directiveArgument(d, "slicingArguments").([]interface{}); ok {
+		for _, name := range slicingArguments {
+			name, _ := name.(string)
+			if value, ok := intArgument(args[name]); ok {
+				size, sliced = max(size, value), true
+			}
+		}
+	}
+	if sliced {
+		return size
+	}
+	if assumedSize, ok := intArgument(directiveArgument(d, "assumedSize")); ok {
+		return assumedSize
+	}
+	return defaultListSize
+}
+
+func intArgument(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int64:
+		return max(int(value), 0), true
+	case int:
+		return max(value, 0), true
+	case float64:
+		return max(int(value), 0), true
+	case json.Number:
+		i, err := value.Int64()
+		return max(int(i), 0), err == nil
+	default:
+		return 0, false
+	}
+}
+
+// addCost returns a + b, saturating instead of overflowing
+func addCost(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// multiplyCost returns a * b, saturating instead of overflowing
+func multiplyCost(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+func queryCostExceededError(cost, maxCost int) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message: "query cost exceeds the maximum allowed cost",
+		Extensions: map[string]interface{}{
+			"code":    queryCostExceededCode,
+			"cost":    cost,
+			"maxCost": maxCost,
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package bramble
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const costSchema = `
+directive @cost(weight: Int!) on FIELD_DEFINITION | OBJECT
+directive @listSize(assumedSize: Int, slicingArguments: [String!]) on FIELD_DEFINITION
+
+type Cinema @cost(weight: 3) {
+	id: ID!
+	name: String!
+}
+
+type Movie {
+	id: ID!
+	title: String!
+	rating: Float! @cost(weight: 5)
+	cinemas: [Cinema!]! @listSize(assumedSize: 2)
+}
+
+type Query {
+	movie(id: ID!): Movie
+	movies(first: Int, last: Int): [Movie!]! @listSize(slicingArguments: ["first", "last"], assumedSize: 50)
+	genres: [String!]!
+}`
+
+func newCostExecutableSchema(t *testing.T) *ExecutableSchema {
+	mergedSchema, err := MergeSchemas(gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: costSchema}))
+	require.NoError(t, err)
+
+	es := NewExecutableSchema(nil, 50, nil)
+	es.tracer = noop.NewTracerProvider().Tracer("test")
+	es.MergedSchema = mergedSchema
+	return es
+}
+
+func TestOperationCost(t *testing.T) {
+	es := newCostExecutableSchema(t)
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		cost      int
+	}{
+		{
+			name:  "leaf fields are free",
+			query: `{ genres }`,
+			cost:  0,
+		},
+		{
+			name:  "default field cost",
+			query: `{ movie(id: "1") { title } }`,
+			cost:  1,
+		},
+		{
+			name:  "field and type weights",
+			query: `{ movie(id: "1") { rating cinemas { name } } }`,
+			cost:  1 + 5 + 2*3,
+		},
+		{
+			name:  "assumed size",
+			query: `{ movies { title } }`,
+			cost:  50,
+		},
+		{
+			name:  "slicing argument",
+			query: `{ movies(first: 3) { title } }`,
+			cost:  3,
+		},
+		{
+			name:      "slicing argument from variable",
+			query:     `query($n: Int) { movies(first: 2, last: $n) { title } }`,
+			variables: map[string]interface{}{"n": int64(4)},
+			cost:      4,
+		},
+		{
+			name:  "fragments",
+			query: `{ movie(id: "1") { ...MovieFragment ... on Movie { rating } } } fragment MovieFragment on Movie { cinemas { id } }`,
+			cost:  1 + 2*3 + 5,
+		},
+		{
+			name:  "introspection is free",
+			query: `{ __schema { types { name } } }`,
+			cost:  0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			query := gqlparser.MustLoadQuery(es.MergedSchema, tc.query)
+			assert.Equal(t, tc.cost, es.operationCost(es.MergedSchema, query.Operations[0], tc.variables))
+		})
+	}
+}
+
+func TestOperationCostSaturates(t *testing.T) {
+	es := newCostExecutableSchema(t)
+
+	query := gqlparser.MustLoadQuery(es.MergedSchema, `query($n: Int) { movies(first: $n) { cinemas { id } } }`)
+	cost := es.operationCost(es.MergedSchema, query.Operations[0], map[string]interface{}{"n": int64(math.MaxInt64 / 4)})
+	assert.Equal(t, math.MaxInt, cost)
+}
+
+func TestQueryCostExceeded(t *testing.T) {
+	es := newCostExecutableSchema(t)
+	es.MaxQueryCost = 10
+
+	query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movies { title } }`)
+	ctx := testContextWithVariables(nil, query.Operations[0])
+	resp := es.ExecuteQuery(ctx)
+
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, map[string]interface{}{
+		"code":    queryCostExceededCode,
+		"cost":    50,
+		"maxCost": 10,
+	}, resp.Errors[0].Extensions)
+	assert.Nil(t, resp.Data)
+	assert.Equal(t, 50, graphql.GetExtension(ctx, "cost"))
+}
+
+func TestQueryCostExtensionWithoutBudget(t *testing.T) {
+	es := newCostExecutableSchema(t)
+
+	query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movies { title } }`)
+	ctx := testContextWithVariables(nil, query.Operations[0])
+	es.ExecuteQuery(ctx)
+
+	assert.Equal(t, 50, graphql.GetExtension(ctx, "cost"))
+}
+
+func TestQueryCostExtensionNotConfigured(t *testing.T) {
+	mergedSchema, err := MergeSchemas(gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: `type Query { genres: [String!]! }`}))
+	require.NoError(t, err)
+	es := NewExecutableSchema(nil, 50, nil)
+	es.tracer = noop.NewTracerProvider().Tracer("test")
+	es.MergedSchema = mergedSchema
+
+	query := gqlparser.MustLoadQuery(es.MergedSchema, `{ genres }`)
+	ctx := testContextWithVariables(nil, query.Operations[0])
+	es.ExecuteQuery(ctx)
+
+	assert.Nil(t, graphql.GetExtension(ctx, "cost"), "the cost is only reported when cost analysis is configured")
+}
+
+func TestComplexity(t *testing.T) {
+	es := newCostExecutableSchema(t)
+
+	cost, ok := es.Complexity(context.Background(), "Query", "movies", 2, map[string]interface{}{"first": int64(5)})
+	require.True(t, ok)
+	assert.Equal(t, 5*(1+2), cost)
+
+	cost, ok = es.Complexity(context.Background(), "Movie", "rating", 0, nil)
+	require.True(t, ok)
+	assert.Equal(t, 5, cost)
+
+	_, ok = es.Complexity(context.Background(), "Movie", "unknown", 0, nil)
+	assert.False(t, ok)
+}
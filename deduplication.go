@@ -0,0 +1,106 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// WithRequestDeduplication coalesces concurrent identical query requests to
+// the same service into a single HTTP call. Requests are identical when their
+// document, variables and headers, including the outgoing request headers,
+// are the same, so results are never shared between users with different
+// authorization headers. Mutations are never deduplicated.
+func WithRequestDeduplication() ClientOpt {
+	return func(s *GraphQLClient) {
+		s.deduplication = &singleflight.Group{}
+	}
+}
+
+// deduplicated returns whether the request can share the response of an
+// identical request in flight
+func (c *GraphQLClient) deduplicated(request *Request) bool {
+	return c.deduplication != nil && request.OperationType == "query" && !request.isMultipart()
+}
+
+// deduplicatedRequest sends the request or waits for the response of the
+// identical request in flight. The shared call is not cancelled with the
+// context of the request that started it, each caller stops waiting when its
+// own context is done.
+func (c *GraphQLClient) deduplicatedRequest(ctx context.Context, url string, request *Request, out interface{}) error {
+	key, err := deduplicationKey(ctx, url, request)
+	if err != nil {
+		return c.request(ctx, url, request, out)
+	}
+
+	result := c.deduplication.DoChan(key, func() (interface{}, error) {
+		var data json.RawMessage
+		err := c.request(context.WithoutCancel(ctx), url, request, &data)
+		return data, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-result:
+		if res.Shared {
+			trace.SpanFromContext(ctx).AddEvent("deduplicated request")
+		}
+		// every caller decodes its own copy as the results are modified
+		// when merged
+		if data, _ := res.Val.(json.RawMessage); len(data) > 0 && out != nil {
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("error decoding response: %w", err)
+			}
+		}
+		return cloneGraphqlErrors(res.Err)
+	}
+}
+
+// deduplicationKey identifies the identical requests
+func deduplicationKey(ctx context.Context, url string, request *Request) (string, error) {
+	headers := http.Header{}
+	for name, values := range GetOutgoingRequestHeadersFromContext(ctx) {
+		headers[name] = values
+	}
+	for name, values := range request.Headers {
+		headers[name] = values
+	}
+
+	h := sha256.New()
+	err := json.NewEncoder(h).Encode([]interface{}{
+		url,
+		request.Query,
+		request.OperationName,
+		request.Variables,
+		request.Extensions,
+		headers,
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cloneGraphqlErrors copies shared GraphQL errors, their extensions are
+// modified by the query execution
+func cloneGraphqlErrors(err error) error {
+	var gqlErrs GraphqlErrors
+	if !errors.As(err, &gqlErrs) {
+		return err
+	}
+	clone := make(GraphqlErrors, len(gqlErrs))
+	for i, e := range gqlErrs {
+		e.Extensions = maps.Clone(e.Extensions)
+		clone[i] = e
+	}
+	return clone
+}
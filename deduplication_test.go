@@ -0,0 +1,117 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSlowService returns a service answering once the release channel is
+// closed
+func newSlowService(t *testing.T, release chan struct{}) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(`{"data": {"test": "value"}, "errors": [{"message": "partial", "extensions": {"code": "PARTIAL"}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// sendConcurrently sends the requests concurrently, the service is released
+// once they are all waiting
+func sendConcurrently(c *GraphQLClient, url string, release chan struct{}, requests ...func() (context.Context, *Request)) []error {
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, req := request()
+			var res map[string]interface{}
+			errs[i] = c.Request(ctx, url, req, &res)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return errs
+}
+
+func TestClientRequestDeduplication(t *testing.T) {
+	query := func(headers http.Header) func() (context.Context, *Request) {
+		return func() (context.Context, *Request) {
+			ctx := AddOutgoingRequestsHeaderToContext(context.Background(), "Authorization", headers.Get("Authorization"))
+			return ctx, NewRequest("{ test }").WithOperationType("query").WithHeaders(GetOutgoingRequestHeadersFromContext(ctx))
+		}
+	}
+
+	t.Run("identical queries", func(t *testing.T) {
+		release := make(chan struct{})
+		srv, calls := newSlowService(t, release)
+		c := NewClient(WithRequestDeduplication())
+		alice := query(http.Header{"Authorization": []string{"alice"}})
+
+		errs := sendConcurrently(c, srv.URL, release, alice, alice, alice)
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+		require.Len(t, errs, 3)
+		for _, err := range errs {
+			require.IsType(t, GraphqlErrors{}, err)
+		}
+		errs[0].(GraphqlErrors)[0].Extensions["serviceUrl"] = srv.URL
+		assert.Nil(t, errs[1].(GraphqlErrors)[0].Extensions["serviceUrl"], "the errors are not shared")
+	})
+
+	t.Run("different headers", func(t *testing.T) {
+		release := make(chan struct{})
+		srv, calls := newSlowService(t, release)
+		c := NewClient(WithRequestDeduplication())
+		alice := query(http.Header{"Authorization": []string{"alice"}})
+		bob := query(http.Header{"Authorization": []string{"bob"}})
+
+		sendConcurrently(c, srv.URL, release, alice, bob)
+		assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		release := make(chan struct{})
+		srv, calls := newSlowService(t, release)
+		c := NewClient(WithRequestDeduplication())
+		mutation := func() (context.Context, *Request) {
+			return context.Background(), NewRequest("mutation { test }").WithOperationType("mutation")
+		}
+
+		sendConcurrently(c, srv.URL, release, mutation, mutation)
+		assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+	})
+
+	t.Run("cancelled caller", func(t *testing.T) {
+		release := make(chan struct{})
+		srv, calls := newSlowService(t, release)
+		c := NewClient(WithRequestDeduplication())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled := func() (context.Context, *Request) {
+			return ctx, NewRequest("{ test }").WithOperationType("query")
+		}
+		other := func() (context.Context, *Request) {
+			return context.Background(), NewRequest("{ test }").WithOperationType("query")
+		}
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		errs := sendConcurrently(c, srv.URL, release, cancelled, other)
+		assert.ErrorIs(t, errs[0], context.Canceled)
+		assert.IsType(t, GraphqlErrors{}, errs[1], "the shared call is not cancelled")
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+	})
+}
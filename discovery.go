@@ -0,0 +1,240 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDiscoveryInterval = 30 * time.Second
+
+// ServiceDiscovery finds services at runtime, the discovered services are
+// added to the configured ones
+type ServiceDiscovery interface {
+	// Source labels the services found by the discovery
+	Source() string
+	// Discover returns the services currently available
+	Discover(ctx context.Context) ([]ServiceConfig, error)
+}
+
+// DiscoveryConfig configures the service discoveries
+type DiscoveryConfig struct {
+	// Interval at which the services are discovered again
+	Interval         string        `json:"interval"`
+	IntervalDuration time.Duration `json:"-"`
+	// Directories contain a service descriptor file per service, they are
+	// also watched for changes
+	Directories []string `json:"directories"`
+	// DNSSRV are DNS SRV records whose targets are services
+	DNSSRV []DNSSRVConfig `json:"dns-srv"`
+}
+
+func (c *DiscoveryConfig) load() error {
+	if c.Interval != "" {
+		var err error
+		c.IntervalDuration, err = time.ParseDuration(c.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+		if c.IntervalDuration <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+	}
+	for _, record := range c.DNSSRV {
+		if record.Name == "" {
+			return fmt.Errorf("missing dns srv record name")
+		}
+	}
+	return nil
+}
+
+func (c DiscoveryConfig) interval() time.Duration {
+	if c.IntervalDuration > 0 {
+		return c.IntervalDuration
+	}
+	return defaultDiscoveryInterval
+}
+
+// discoveries returns the discoveries of the configuration
+func (c DiscoveryConfig) discoveries() []ServiceDiscovery {
+	var discoveries []ServiceDiscovery
+	for _, dir := range c.Directories {
+		discoveries = append(discoveries, &DirectoryDiscovery{Path: dir})
+	}
+	for _, record := range c.DNSSRV {
+		discoveries = append(discoveries, NewDNSSRVDiscovery(record))
+	}
+	return discoveries
+}
+
+// DirectoryDiscovery discovers the services described by the JSON files of a
+// directory. Each file is a service, either its URL or an object as in the
+// services setting.
+type DirectoryDiscovery struct {
+	Path string
+}
+
+func (d *DirectoryDiscovery) Source() string {
+	return "directory:" + d.Path
+}
+
+// Discover reads the service descriptor files, an invalid file fails the
+// whole discovery so that services are not removed while being written
+func (d *DirectoryDiscovery) Discover(ctx context.Context) ([]ServiceConfig, error) {
+	files, err := filepath.Glob(filepath.Join(d.Path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var services []ServiceConfig
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var service ServiceConfig
+		if err := json.Unmarshal(data, &service); err != nil {
+			return nil, fmt.Errorf("invalid service descriptor %s: %w", file, err)
+		}
+		service.Source = d.Source()
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// DNSSRVConfig is a DNS SRV record whose targets are services
+type DNSSRVConfig struct {
+	// Name of the record, e.g. _graphql._tcp.services.example.com
+	Name string `json:"name"`
+	// Scheme of the service URLs, defaults to http
+	Scheme string `json:"scheme"`
+	// Path of the service URLs, e.g. /query
+	Path string `json:"path"`
+	// Resolver is the address of the DNS server, defaults to the system
+	// resolver
+	Resolver string `json:"resolver"`
+}
+
+// DNSSRVDiscovery discovers the services from the targets of a DNS SRV record
+type DNSSRVDiscovery struct {
+	config   DNSSRVConfig
+	resolver *net.Resolver
+}
+
+// NewDNSSRVDiscovery returns a discovery of the targets of the record
+func NewDNSSRVDiscovery(config DNSSRVConfig) *DNSSRVDiscovery {
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	resolver := net.DefaultResolver
+	if config.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, config.Resolver)
+			},
+		}
+	}
+	return &DNSSRVDiscovery{config: config, resolver: resolver}
+}
+
+func (d *DNSSRVDiscovery) Source() string {
+	return "dns-srv:" + d.config.Name
+}
+
+func (d *DNSSRVDiscovery) Discover(ctx context.Context) ([]ServiceConfig, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.config.Name)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]ServiceConfig, 0, len(records))
+	for _, record := range records {
+		serviceURL := url.URL{
+			Scheme: d.config.Scheme,
+			Host:   net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			Path:   d.config.Path,
+		}
+		services = append(services, ServiceConfig{URL: serviceURL.String(), Source: d.Source()})
+	}
+	slices.SortFunc(services, func(a, b ServiceConfig) int { return strings.Compare(a.URL, b.URL) })
+	return services, nil
+}
+
+// serviceDiscoveries returns the discoveries of the configuration followed by
+// the custom ones
+func (c *Config) serviceDiscoveries() []ServiceDiscovery {
+	return append(c.Discovery.discoveries(), c.ServiceDiscoveries...)
+}
+
+// discoverServices runs the service discoveries, a failing discovery keeps
+// the services it found last
+func (c *Config) discoverServices(ctx context.Context) {
+	discovered := make(map[string][]ServiceConfig)
+	for _, discovery := range c.serviceDiscoveries() {
+		source := discovery.Source()
+		services, err := discovery.Discover(ctx)
+		if err != nil {
+			log.With("source", source, "error", err).Error("service discovery failed")
+			promServiceDiscoveryErrorCounter.WithLabelValues(source).Inc()
+			services = c.discovered[source]
+		}
+		discovered[source] = services
+	}
+	c.discovered = discovered
+
+	promServiceDiscoveredGauge.Reset()
+	for source, services := range discovered {
+		for _, service := range services {
+			promServiceDiscoveredGauge.WithLabelValues(service.URL, source).Set(1)
+		}
+	}
+}
+
+// rediscover runs the service discoveries and updates the services when they
+// changed
+func (c *Config) rediscover() error {
+	if len(c.serviceDiscoveries()) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Discovery.interval())
+	defer cancel()
+
+	c.discoverServices(ctx)
+	services, err := c.buildServiceList()
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(services, c.ServiceConfigs) {
+		return nil
+	}
+	c.ServiceConfigs = services
+	if c.executableSchema == nil {
+		return nil
+	}
+	if err := c.executableSchema.UpdateServices(ctx, services); err != nil {
+		return fmt.Errorf("failed updating services: %w", err)
+	}
+	log.With("services", serviceURLs(services)).Info("discovered services updated")
+	return nil
+}
+
+// isDiscoveryEvent returns whether the file event is in a discovery directory
+func (c *Config) isDiscoveryEvent(name string) bool {
+	dir := filepath.Dir(filepath.Clean(name))
+	for _, discoveryDir := range c.Discovery.Directories {
+		if filepath.Clean(discoveryDir) == dir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package bramble
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startSRVResolver serves the given SRV records for any SRV question
+func startSRVResolver(t *testing.T, records ...dnsmessage.SRVResource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) == 0 {
+				continue
+			}
+			question := query.Questions[0]
+			response := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: []dnsmessage.Question{question},
+			}
+			if question.Type == dnsmessage.TypeSRV {
+				for _, record := range records {
+					record := record
+					response.Answers = append(response.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &record,
+					})
+				}
+			}
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSRVDiscovery(t *testing.T) {
+	resolver := startSRVResolver(t,
+		dnsmessage.SRVResource{Target: dnsmessage.MustNewName("shows.services.test."), Port: 8081},
+		dnsmessage.SRVResource{Target: dnsmessage.MustNewName("movies.services.test."), Port: 8080},
+	)
+	discovery := NewDNSSRVDiscovery(DNSSRVConfig{
+		Name:     "_graphql._tcp.services.test",
+		Path:     "/query",
+		Resolver: resolver,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	services, err := discovery.Discover(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceConfig{
+		{URL: "http://movies.services.test:8080/query", Source: "dns-srv:_graphql._tcp.services.test"},
+		{URL: "http://shows.services.test:8081/query", Source: "dns-srv:_graphql._tcp.services.test"},
+	}, services)
+}
+
+func TestDirectoryDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "movies.json"), []byte(`"http://movies/query"`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shows.json"), []byte(`{"url": "http://shows/query", "timeout": "2s"}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte(`not a service`), 0o644))
+
+	discovery := &DirectoryDiscovery{Path: dir}
+	services, err := discovery.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []ServiceConfig{
+		{URL: "http://movies/query", Source: "directory:" + dir},
+		{URL: "http://shows/query", Timeout: "2s", Source: "directory:" + dir},
+	}, services)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "books.json"), []byte(`{"url": `), 0o644))
+	_, err = discovery.Discover(context.Background())
+	assert.ErrorContains(t, err, "invalid service descriptor")
+}
+
+func TestConfigDiscoveredServices(t *testing.T) {
+	movies := newPolledService(t, "movies", "movies: [String!]", nil)
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+	dir := t.TempDir()
+
+	cfg := &Config{
+		ServiceConfigs: []ServiceConfig{{URL: movies.URL}},
+		Discovery:      DiscoveryConfig{Directories: []string{dir}},
+	}
+	require.NoError(t, cfg.Discovery.load())
+	cfg.discoverServices(context.Background())
+	services, err := cfg.buildServiceList()
+	require.NoError(t, err)
+	cfg.ServiceConfigs = services
+	cfg.executableSchema = NewExecutableSchema(nil, 50, nil)
+	require.NoError(t, cfg.executableSchema.UpdateServices(context.Background(), services))
+	es := cfg.executableSchema
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shows.json"), []byte(`"`+shows.URL+`"`), 0o644))
+	assert.True(t, cfg.isDiscoveryEvent(filepath.Join(dir, "shows.json")))
+	require.NoError(t, cfg.rediscover())
+	require.Contains(t, es.Services, shows.URL)
+	assert.Equal(t, "directory:"+dir, es.Services[shows.URL].Config.Source)
+	assert.Empty(t, es.Services[movies.URL].Config.Source)
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "books.json"), []byte(`{"url": `), 0o644))
+	require.NoError(t, cfg.rediscover())
+	assert.Contains(t, es.Services, shows.URL, "a failing discovery keeps the services it found last")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "books.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "shows.json")))
+	require.NoError(t, cfg.rediscover())
+	assert.NotContains(t, es.Services, shows.URL)
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+}
@@ -0,0 +1,165 @@
+package bramble
+
+import (
+	"context"
+	"fmt"
+	log "log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultDrainTimeout = 5 * time.Second
+
+// DrainConfig configures the graceful shutdown of the gateway
+type DrainConfig struct {
+	// PreStopDelay is how long the gateway keeps serving with a failing
+	// readiness check before it stops accepting connections, so the load
+	// balancers stop routing to it
+	PreStopDelay         string        `json:"pre-stop-delay"`
+	PreStopDelayDuration time.Duration `json:"-"`
+	// Timeout is how long the in-flight operations have to complete once the
+	// gateway stops accepting connections, defaults to 5s
+	Timeout         string        `json:"timeout"`
+	TimeoutDuration time.Duration `json:"-"`
+}
+
+func (c *DrainConfig) load() error {
+	var err error
+	if c.PreStopDelay != "" {
+		c.PreStopDelayDuration, err = time.ParseDuration(c.PreStopDelay)
+		if err != nil {
+			return fmt.Errorf("invalid pre-stop delay: %w", err)
+		}
+	}
+	if c.Timeout != "" {
+		c.TimeoutDuration, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c DrainConfig) timeout() time.Duration {
+	if c.TimeoutDuration > 0 {
+		return c.TimeoutDuration
+	}
+	return defaultDrainTimeout
+}
+
+// Drain gracefully stops the gateway. The readiness check fails first, the
+// servers stop accepting connections after the pre-stop delay and the
+// in-flight operations have until the drain timeout to complete. The
+// subscriptions never complete on their own, they are completed when the
+// servers stop accepting connections. The plugins are shut down last.
+func (g *Gateway) Drain(cfg DrainConfig, servers ...*http.Server) {
+	g.draining.Store(true)
+	log.With("delay", cfg.PreStopDelayDuration).Info("draining gateway")
+	time.Sleep(cfg.PreStopDelayDuration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+
+	g.ExecutableSchema.operations.close()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.With("addr", srv.Addr, "error", err).Error("failed shutting down server")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// operations over hijacked connections are not waited for by the servers
+	if err := g.ExecutableSchema.operations.wait(ctx); err != nil {
+		log.With("error", err).Warn("operations still in flight after the drain timeout")
+	}
+
+	pluginsCtx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+	for _, plugin := range g.plugins {
+		if err := plugin.Shutdown(pluginsCtx); err != nil {
+			log.With("plugin", plugin.ID(), "error", err).Error("failed shutting down plugin")
+		}
+	}
+	log.Info("gateway drained")
+}
+
+// inFlightOperations tracks the operations being executed
+type inFlightOperations struct {
+	mutex   sync.Mutex
+	count   int
+	idle    []chan struct{}
+	closing chan struct{}
+}
+
+// start records a new operation, the returned function must be called when
+// it completes
+func (o *inFlightOperations) start() func() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.count++
+	return o.done
+}
+
+func (o *inFlightOperations) done() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.count--
+	if o.count > 0 {
+		return
+	}
+	for _, idle := range o.idle {
+		close(idle)
+	}
+	o.idle = nil
+}
+
+// wait waits for the operations in flight to complete
+func (o *inFlightOperations) wait(ctx context.Context) error {
+	o.mutex.Lock()
+	if o.count == 0 {
+		o.mutex.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	o.idle = append(o.idle, idle)
+	o.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closed returns a channel closed when the long-lived operations, i.e. the
+// subscriptions, must complete
+func (o *inFlightOperations) closed() <-chan struct{} {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closing == nil {
+		o.closing = make(chan struct{})
+	}
+	return o.closing
+}
+
+// close asks the long-lived operations to complete
+func (o *inFlightOperations) close() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.closing == nil {
+		o.closing = make(chan struct{})
+	}
+	select {
+	case <-o.closing:
+	default:
+		close(o.closing)
+	}
+}
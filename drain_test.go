@@ -0,0 +1,185 @@
+package bramble
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type shutdownPlugin struct {
+	BasePlugin
+	shutdown chan struct{}
+}
+
+func (p *shutdownPlugin) ID() string {
+	return "shutdown"
+}
+
+func (p *shutdownPlugin) Shutdown(ctx context.Context) error {
+	close(p.shutdown)
+	return nil
+}
+
+// startServer serves the handler on a random port
+func startServer(t *testing.T, handler http.Handler) (*http.Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	return srv, "http://" + ln.Addr().String()
+}
+
+func TestGatewayDrain(t *testing.T) {
+	received, release := make(chan struct{}), make(chan struct{})
+	service := newPolledService(t, "movies", "movies: [String!]", func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.Write([]byte(`{"data": {"movies": ["Alien"]}}`))
+	})
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(service.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	plugin := &shutdownPlugin{shutdown: make(chan struct{})}
+	gtw := NewGateway(es, []Plugin{plugin})
+	public, publicURL := startServer(t, gtw.Router(&Config{}))
+	private, privateURL := startServer(t, gtw.PrivateRouter())
+
+	responses := make(chan string)
+	go func() {
+		resp, err := http.Post(publicURL+"/query", "application/json", strings.NewReader(`{"query": "{ movies }"}`))
+		if !assert.NoError(t, err) {
+			close(responses)
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-received
+
+	drained := make(chan struct{})
+	go func() {
+		gtw.Drain(DrainConfig{PreStopDelayDuration: 100 * time.Millisecond, TimeoutDuration: 5 * time.Second}, public, private)
+		close(drained)
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(privateURL + "/readyz")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode == http.StatusServiceUnavailable && string(body) == "draining\n"
+	}, 50*time.Millisecond, time.Millisecond, "the readiness check fails during the pre-stop delay")
+
+	require.Eventually(t, func() bool {
+		_, err := http.Get(publicURL + "/query")
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "new connections are refused")
+
+	select {
+	case <-drained:
+		t.Fatal("the drain didn't wait for the operation in flight")
+	case <-plugin.shutdown:
+		t.Fatal("the plugins were shut down before the operation completed")
+	default:
+	}
+
+	close(release)
+	assert.JSONEq(t, `{"data": {"movies": ["Alien"]}}`, <-responses)
+	<-drained
+	<-plugin.shutdown
+}
+
+func TestGatewayDrainDeferredFragment(t *testing.T) {
+	unblock := make(chan struct{})
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	releaseService := newIncrementalReleaseService(t, unblock)
+	defer releaseService.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL), NewService(releaseService.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	gtw := NewGateway(es, nil)
+	public, publicURL := startServer(t, gtw.Router(&Config{}))
+
+	resp := postIncrementalQuery(t, publicURL, `{ movies { title ... @defer(label: "release") { release } } }`)
+	defer resp.Body.Close()
+	reader := newMultipartReader(t, resp)
+	readMultipartPayload(t, reader)
+
+	drained := make(chan struct{})
+	go func() {
+		gtw.Drain(DrainConfig{TimeoutDuration: 5 * time.Second}, public)
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		t.Fatal("the drain didn't wait for the deferred fragment")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(unblock)
+	assert.Len(t, readIncrementalResults(t, reader), 2)
+	<-drained
+}
+
+func TestGatewayDrainSubscription(t *testing.T) {
+	received := make(chan wsMessage, 10)
+	movieService := newSubscriptionMovieService(t, []string{
+		`{"data": {"movieUpdated": {"title": "Jurassic Park", "_bramble_id": "1", "_bramble__typename": "Movie"}}}`,
+	}, false, received)
+	defer movieService.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	gtw := NewGateway(es, nil)
+	public, publicURL := startServer(t, gtw.Router(&Config{}))
+
+	conn := dialGatewaySubscription(t, publicURL, "subscription { movieUpdated { title } }")
+	defer conn.Close()
+	require.Equal(t, wsNextMsg, readGatewayMessage(t, conn).Type)
+
+	drained := make(chan struct{})
+	go func() {
+		gtw.Drain(DrainConfig{TimeoutDuration: 5 * time.Second}, public)
+		close(drained)
+	}()
+
+	msg := readGatewayMessage(t, conn)
+	assert.Equal(t, wsCompleteMsg, msg.Type, "the subscription is completed")
+	require.Equal(t, wsSubscribeMsg, (<-received).Type)
+	assert.Equal(t, wsCompleteMsg, (<-received).Type, "the downstream subscription is completed")
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("the drain waited for the subscription timeout")
+	}
+}
+
+func TestInFlightOperationsWait(t *testing.T) {
+	var operations inFlightOperations
+	require.NoError(t, operations.wait(context.Background()))
+
+	done := operations.start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, operations.wait(ctx), context.DeadlineExceeded)
+
+	waited := make(chan error)
+	go func() {
+		waited <- operations.wait(context.Background())
+	}()
+	done()
+	assert.NoError(t, <-waited)
+}
@@ -0,0 +1,158 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const defaultEntityCacheSize = 10000
+
+// EntityCacheConfig configures the cache of the entities fetched by boundary
+// queries.
+type EntityCacheConfig struct {
+	// Size is the number of entities kept in memory, defaults to 10000
+	Size int `json:"size"`
+	// TTL is how long the entities of each boundary type are cached, e.g.
+	// {"Movie": "30s"}. Entities of types not listed are not cached.
+	TTL          map[string]string        `json:"ttl"`
+	TTLDurations map[string]time.Duration `json:"-"`
+}
+
+func (c *EntityCacheConfig) load() error {
+	if c.Size < 0 {
+		return fmt.Errorf("invalid size %d", c.Size)
+	}
+	c.TTLDurations = make(map[string]time.Duration, len(c.TTL))
+	for typeName, ttl := range c.TTL {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return fmt.Errorf("invalid ttl for type %q: %w", typeName, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid ttl for type %q: must be positive", typeName)
+		}
+		c.TTLDurations[typeName] = d
+	}
+	return nil
+}
+
+// entityCache is a bounded cache of the entities returned by boundary
+// queries. Entities are stored per service, boundary type, selection set and
+// permissions, so callers only get the fields they could have fetched
+// themselves. It is purged every time the merged schema is updated.
+type entityCache struct {
+	cache *lru.Cache[string, cachedEntity]
+	ttl   map[string]time.Duration
+}
+
+// cachedEntity is the JSON encoded entity, decoded on every lookup as the
+// entities are modified in place when the execution results are merged
+type cachedEntity struct {
+	data    []byte
+	expires time.Time
+}
+
+func newEntityCache(size int, ttl map[string]time.Duration) *entityCache {
+	cache, err := lru.New[string, cachedEntity](size)
+	if err != nil {
+		// only returned for a non positive size
+		panic(err)
+	}
+	return &entityCache{cache: cache, ttl: ttl}
+}
+
+// enabled returns whether the entities of the boundary type are cached
+func (c *entityCache) enabled(typeName string) bool {
+	return c.ttl[typeName] > 0
+}
+
+// get returns the cached entities and the ids that were not found
+func (c *entityCache) get(prefix string, ids []string) ([]interface{}, []string) {
+	var entities []interface{}
+	var missing []string
+	now := time.Now()
+	for _, id := range ids {
+		key := prefix + id
+		cached, ok := c.cache.Get(key)
+		if ok && now.After(cached.expires) {
+			c.cache.Remove(key)
+			ok = false
+		}
+		var entity map[string]interface{}
+		if ok && json.Unmarshal(cached.data, &entity) == nil {
+			promEntityCacheCounter.WithLabelValues("hit").Inc()
+			entities = append(entities, entity)
+			continue
+		}
+		promEntityCacheCounter.WithLabelValues("miss").Inc()
+		missing = append(missing, id)
+	}
+	return entities, missing
+}
+
+// add caches the entities returned by a boundary query
+func (c *entityCache) add(prefix string, typeName string, entities []interface{}) {
+	expires := time.Now().Add(c.ttl[typeName])
+	for _, entity := range entities {
+		entityMap, ok := entity.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, err := boundaryIDFromMap(entityMap)
+		if err != nil {
+			continue
+		}
+		data, err := json.Marshal(entityMap)
+		if err != nil {
+			continue
+		}
+		c.cache.Add(prefix+id, cachedEntity{data: data, expires: expires})
+	}
+}
+
+func (c *entityCache) purge() {
+	c.cache.Purge()
+}
+
+// entityCacheKeyPrefix returns the prefix of the cache keys of the entities
+// fetched by the step, the key of an entity is the prefix followed by its id.
+// The values of the variables used by the selection set, the permissions of
+// the caller and the headers forwarded to the service are part of the key.
+func entityCacheKeyPrefix(ctx context.Context, step *QueryPlanStep) string {
+	var b strings.Builder
+	writeSelectionSetSignature(&b, step.SelectionSet)
+
+	h := sha256.New()
+	io.WriteString(h, step.ServiceURL)
+	io.WriteString(h, "\ntype:")
+	io.WriteString(h, step.ParentType)
+	io.WriteString(h, "\nselection:")
+	io.WriteString(h, b.String())
+	if names := selectionSetVariables(step.SelectionSet); len(names) > 0 && graphql.HasOperationContext(ctx) {
+		variables := graphql.GetOperationContext(ctx).Variables
+		used := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			used[name] = variables[name]
+		}
+		io.WriteString(h, "\nvariables:")
+		json.NewEncoder(h).Encode(used)
+	}
+	if perms, ok := GetPermissionsFromContext(ctx); ok {
+		io.WriteString(h, "\npermissions:")
+		json.NewEncoder(h).Encode(perms)
+	}
+	if headers := GetOutgoingRequestHeadersFromContext(ctx); len(headers) > 0 {
+		io.WriteString(h, "\nheaders:")
+		json.NewEncoder(h).Encode(headers)
+	}
+	return hex.EncodeToString(h.Sum(nil)) + ":"
+}
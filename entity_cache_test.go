@@ -0,0 +1,209 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestEntityCache(t *testing.T) {
+	titleSchema := `directive @boundary on OBJECT | FIELD_DEFINITION
+
+	type Movie @boundary {
+		id: ID!
+		title: String!
+		rating: Float!
+	}
+
+	type Query {
+		_movie(id: ID!): Movie @boundary
+	}`
+
+	var mutex sync.Mutex
+	var fetched []string
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+
+				type Movie @boundary {
+					id: ID!
+				}
+
+				type Query {
+					_movie(id: ID!): Movie @boundary
+					movies(ids: [ID!]!): [Movie!]!
+				}
+
+				type Mutation {
+					updateMovies(ids: [ID!]!): [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					ids, _ := req.Variables["ids"].([]interface{})
+					var movies []string
+					for _, id := range ids {
+						movies = append(movies, fmt.Sprintf(`{"_bramble_id": "%s", "_bramble__typename": "Movie", "id": "%s"}`, id, id))
+					}
+					field := "movies"
+					if strings.Contains(req.Query, "updateMovies") {
+						field = "updateMovies"
+					}
+					fmt.Fprintf(w, `{"data": {%q: [%s]}}`, field, strings.Join(movies, ","))
+				}),
+			},
+			{
+				schema: titleSchema,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: titleSchema}), req.Query)
+					var movies []string
+					for _, s := range query.Operations[0].SelectionSet {
+						field := s.(*ast.Field)
+						id := req.argument(field, 0).(string)
+						mutex.Lock()
+						fetched = append(fetched, id)
+						mutex.Unlock()
+						movies = append(movies, fmt.Sprintf(`"%s": {"_bramble_id": "%s", "_bramble__typename": "Movie", "title": "title %s", "rating": 4.5}`, field.Alias, id, id))
+					}
+					fmt.Fprintf(w, `{"data": {%s}}`, strings.Join(movies, ","))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	es.SetEntityCache(10, map[string]time.Duration{"Movie": time.Minute})
+
+	executeWithContext := func(t *testing.T, ids []string, query string, withContext func(context.Context) context.Context) ([]string, string) {
+		mutex.Lock()
+		fetched = nil
+		mutex.Unlock()
+
+		operation := gqlparser.MustLoadQuery(f.mergedSchema, query).Operations[0]
+		ctx := withContext(testContextWithVariables(map[string]interface{}{"ids": ids}, operation))
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return fetched, string(resp.Data)
+	}
+	execute := func(t *testing.T, ids []string, query string, perms *OperationPermissions) ([]string, string) {
+		return executeWithContext(t, ids, query, func(ctx context.Context) context.Context {
+			if perms != nil {
+				ctx = AddPermissionsToContext(ctx, *perms)
+			}
+			return ctx
+		})
+	}
+	titleQuery := `query($ids: [ID!]!) { movies(ids: $ids) { id title } }`
+
+	t.Run("only missing entities are fetched", func(t *testing.T) {
+		fetched, _ := execute(t, []string{"1", "2"}, titleQuery, nil)
+		assert.ElementsMatch(t, []string{"1", "2"}, fetched)
+
+		fetched, data := execute(t, []string{"1", "2", "3"}, titleQuery, nil)
+		assert.Equal(t, []string{"3"}, fetched)
+		assert.JSONEq(t, `{"movies": [
+			{"id": "1", "title": "title 1"},
+			{"id": "2", "title": "title 2"},
+			{"id": "3", "title": "title 3"}
+		]}`, data)
+
+		fetched, _ = execute(t, []string{"3", "1"}, titleQuery, nil)
+		assert.Empty(t, fetched)
+	})
+
+	t.Run("entities are cached per selection set", func(t *testing.T) {
+		fetched, data := execute(t, []string{"1"}, `query($ids: [ID!]!) { movies(ids: $ids) { id title rating } }`, nil)
+		assert.Equal(t, []string{"1"}, fetched)
+		assert.JSONEq(t, `{"movies": [{"id": "1", "title": "title 1", "rating": 4.5}]}`, data)
+	})
+
+	t.Run("entities are cached per permissions", func(t *testing.T) {
+		perms := &OperationPermissions{AllowedRootQueryFields: AllowedFields{AllowAll: true}}
+		fetched, _ := execute(t, []string{"1"}, titleQuery, perms)
+		assert.Equal(t, []string{"1"}, fetched)
+		fetched, _ = execute(t, []string{"1"}, titleQuery, perms)
+		assert.Empty(t, fetched)
+	})
+
+	t.Run("entities are cached per forwarded headers", func(t *testing.T) {
+		withAuthorization := func(token string) func(context.Context) context.Context {
+			return func(ctx context.Context) context.Context {
+				return AddOutgoingRequestsHeaderToContext(ctx, "Authorization", token)
+			}
+		}
+		fetched, _ := executeWithContext(t, []string{"1"}, titleQuery, withAuthorization("user-a"))
+		assert.Equal(t, []string{"1"}, fetched)
+		fetched, _ = executeWithContext(t, []string{"1"}, titleQuery, withAuthorization("user-b"))
+		assert.Equal(t, []string{"1"}, fetched, "the entities of another user are not returned")
+		fetched, _ = executeWithContext(t, []string{"1"}, titleQuery, withAuthorization("user-a"))
+		assert.Empty(t, fetched)
+	})
+
+	t.Run("mutations don't read the cache", func(t *testing.T) {
+		fetched, _ := execute(t, []string{"1"}, titleQuery, nil)
+		assert.Empty(t, fetched)
+		fetched, data := execute(t, []string{"1"}, `mutation($ids: [ID!]!) { updateMovies(ids: $ids) { id title } }`, nil)
+		assert.Equal(t, []string{"1"}, fetched)
+		assert.JSONEq(t, `{"updateMovies": [{"id": "1", "title": "title 1"}]}`, data)
+	})
+
+	t.Run("the cache is purged when the schema is updated", func(t *testing.T) {
+		es.mutex.Lock()
+		es.entityCache.purge()
+		es.mutex.Unlock()
+		fetched, _ := execute(t, []string{"1"}, titleQuery, nil)
+		assert.Equal(t, []string{"1"}, fetched)
+	})
+}
+
+func TestEntityCacheEntries(t *testing.T) {
+	cache := newEntityCache(10, map[string]time.Duration{"Movie": time.Minute, "Cinema": -time.Second})
+	assert.True(t, cache.enabled("Movie"))
+	assert.False(t, cache.enabled("Person"))
+
+	cache.add("movies:", "Movie", []interface{}{
+		map[string]interface{}{"_bramble_id": "1", "title": "Alien"},
+		nil,
+	})
+	cache.add("cinemas:", "Cinema", []interface{}{
+		map[string]interface{}{"_bramble_id": "1", "name": "Roxy"},
+	})
+
+	entities, missing := cache.get("movies:", []string{"1", "2"})
+	require.Len(t, entities, 1)
+	assert.Equal(t, []string{"2"}, missing)
+
+	// the returned entities are copies and can be modified when merging
+	entities[0].(map[string]interface{})["title"] = "modified"
+	entities, _ = cache.get("movies:", []string{"1"})
+	assert.Equal(t, "Alien", entities[0].(map[string]interface{})["title"])
+
+	entities, missing = cache.get("cinemas:", []string{"1"})
+	assert.Empty(t, entities, "expired entities are not returned")
+	assert.Equal(t, []string{"1"}, missing)
+}
+
+func TestEntityCacheConfig(t *testing.T) {
+	cfg := EntityCacheConfig{TTL: map[string]string{"Movie": "30s"}}
+	require.NoError(t, cfg.load())
+	assert.Equal(t, map[string]time.Duration{"Movie": 30 * time.Second}, cfg.TTLDurations)
+
+	assert.Error(t, (&EntityCacheConfig{TTL: map[string]string{"Movie": "soon"}}).load())
+	assert.Error(t, (&EntityCacheConfig{TTL: map[string]string{"Movie": "0s"}}).load())
+	assert.Error(t, (&EntityCacheConfig{Size: -1}).load())
+}
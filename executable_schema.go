@@ -5,7 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	log "log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql"
@@ -21,9 +26,11 @@ import (
 
 func NewExecutableSchema(plugins []Plugin, maxRequestsPerQuery int64, client *GraphQLClient, services ...*Service) *ExecutableSchema {
 	serviceMap := make(map[string]*Service)
+	configs := make([]ServiceConfig, 0, len(services))
 
 	for _, s := range services {
 		serviceMap[s.ServiceURL] = s
+		configs = append(configs, s.Config)
 	}
 
 	if client == nil {
@@ -31,16 +38,24 @@ func NewExecutableSchema(plugins []Plugin, maxRequestsPerQuery int64, client *Gr
 	}
 
 	return &ExecutableSchema{
-		Services: serviceMap,
+		Services:           serviceMap,
+		configuredServices: configs,
 
 		GraphqlClient:       client,
 		plugins:             plugins,
 		tracer:              otel.GetTracerProvider().Tracer(instrumentationName),
 		MaxRequestsPerQuery: maxRequestsPerQuery,
+		DefaultFieldCost:    DefaultFieldCost,
+		DefaultListSize:     DefaultListSize,
+		BoundaryBatchSize:   defaultBoundaryBatchSize,
+		BoundaryParallelism: defaultBoundaryParallelism,
 	}
 }
 
-// ExecutableSchema contains all the necessary information to execute queries
+// ExecutableSchema contains all the necessary information to execute queries.
+// The merged schema, locations, boundary maps and services are the state of
+// the last schema update, operations are executed with an immutable snapshot
+// of them.
 type ExecutableSchema struct {
 	MergedSchema        *ast.Schema
 	Locations           FieldURLMap
@@ -49,43 +64,176 @@ type ExecutableSchema struct {
 	BoundaryQueries     BoundaryFieldsMap
 	GraphqlClient       *GraphQLClient
 	MaxRequestsPerQuery int64
+	// BoundaryBatchSize is the number of ids per request for non array
+	// boundary lookups, services can override it.
+	BoundaryBatchSize int
+	// BoundaryArrayBatchSize is the maximum number of ids per request for
+	// array boundary lookups, 0 means no limit. Services can override it.
+	BoundaryArrayBatchSize int
+	// BoundaryParallelism is the number of requests sent concurrently for
+	// the boundary lookups of a plan step.
+	BoundaryParallelism int
+	// MutationFailurePolicy defines how the remaining root mutation fields
+	// are handled when one fails, defaults to MutationFailureContinue.
+	MutationFailurePolicy MutationFailurePolicy
+	// MaxQueryCost is the maximum static cost of an operation, operations
+	// over budget are rejected before being planned. 0 means no limit.
+	MaxQueryCost int
+	// DefaultFieldCost is the cost of fields returning a composite type and
+	// without a @cost directive.
+	DefaultFieldCost int
+	// DefaultListSize is the size assumed for lists without a @listSize
+	// directive.
+	DefaultListSize int
+	// QueryLimits bounds the shape of operations, operations exceeding a
+	// limit are rejected before being planned.
+	QueryLimits QueryLimitsConfig
+	// RequiredServices are the names or URLs of the services that must be
+	// reachable for the gateway to be ready.
+	RequiredServices []string
+	// SchemaSnapshotFile is the file the schemas of the services are
+	// persisted to after every schema update. On startup the persisted
+	// schemas are used for the unreachable services.
+	SchemaSnapshotFile string
+	// ResponseCache stores the responses of queries with a positive max age
+	// computed from the @cacheControl hints. nil disables the response
+	// cache and the Cache-Control header.
+	ResponseCache ResponseCacheStore
+
+	// configuredServices are the services of the configuration, registry
+	// records the runtime changes applied on top of them
+	configuredServices []ServiceConfig
+	registry           serviceRegistry
+	// servicesRevision changes every time the services are replaced
+	servicesRevision int
+	// serviceDefaults fills the settings of the services registered at
+	// runtime
+	serviceDefaults func(ServiceConfig) ServiceConfig
+
+	planCacheSize int
+	entityCache   *entityCache
+	schemaChanges SchemaChangesConfig
+	usage         *schemaUsage
+	tracer        trace.Tracer
+	plugins       []Plugin
+	// mutex serializes the updates, operations never hold it
+	mutex    sync.Mutex
+	snapshot atomic.Pointer[schemaSnapshot]
+	// health is the health of the services at the last update
+	health atomic.Pointer[[]ServiceHealth]
+	// operations are the queries and mutations in flight
+	operations inFlightOperations
+}
+
+// SetPlanCacheSize enables the query plan cache with the given number of
+// entries, a size of 0 disables it.
+func (s *ExecutableSchema) SetPlanCacheSize(size int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.planCacheSize = max(size, 0)
+	if s.snapshot.Load() != nil {
+		s.publishSnapshot()
+	}
+}
+
+// boundaryBatching returns the batching settings of the boundary lookups
+func (s *ExecutableSchema) boundaryBatching() boundaryBatching {
+	return boundaryBatching{
+		batchSize:      s.BoundaryBatchSize,
+		arrayBatchSize: s.BoundaryArrayBatchSize,
+		parallelism:    s.BoundaryParallelism,
+	}
+}
+
+// SetEntityCache enables the cache of the entities fetched by boundary
+// queries, for the types with a positive time to live. A size of 0 disables
+// it.
+func (s *ExecutableSchema) SetEntityCache(size int, ttl map[string]time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if size <= 0 || len(ttl) == 0 {
+		s.entityCache = nil
+	} else {
+		s.entityCache = newEntityCache(size, ttl)
+	}
+	if s.snapshot.Load() != nil {
+		s.publishSnapshot()
+	}
+}
 
-	tracer  trace.Tracer
-	mutex   sync.RWMutex
-	plugins []Plugin
+// SetSchemaChanges sets the policy applied to the breaking changes of the
+// service schema updates. The usage of the schema is tracked for the
+// allow-unused policy.
+func (s *ExecutableSchema) SetSchemaChanges(config SchemaChangesConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.schemaChanges = config
+	switch {
+	case config.Policy != SchemaChangesAllowUnused:
+		s.usage = nil
+	case s.usage == nil:
+		s.usage = newSchemaUsage()
+	}
+	if s.snapshot.Load() != nil {
+		s.publishSnapshot()
+	}
 }
 
 // UpdateServiceList replaces the list of services with the provided one and
 // update the schema.
 func (s *ExecutableSchema) UpdateServiceList(ctx context.Context, services []string) error {
+	configs := make([]ServiceConfig, 0, len(services))
+	for _, svcURL := range services {
+		configs = append(configs, ServiceConfig{URL: svcURL})
+	}
+	return s.UpdateServices(ctx, configs)
+}
+
+// UpdateServices replaces the list of services with the provided one and
+// update the schema. Services whose settings changed are replaced. The
+// services registered or removed at runtime are kept.
+func (s *ExecutableSchema) UpdateServices(ctx context.Context, services []ServiceConfig) error {
 	ctx, span := s.tracer.Start(ctx, "Federated Services Update",
 		trace.WithSpanKind(trace.SpanKindInternal),
 		trace.WithAttributes(
-			attribute.StringSlice("graphql.federation.services", services),
+			attribute.StringSlice("graphql.federation.services", serviceURLs(services)),
 		),
 	)
 
 	defer span.End()
 
-	newServices := make(map[string]*Service)
-	for _, svcURL := range services {
-		if svc, ok := s.Services[svcURL]; ok {
-			newServices[svcURL] = svc
-		} else {
-			newServices[svcURL] = NewService(svcURL, WithHTTPClient(s.GraphqlClient.HTTPClient))
-		}
-	}
-	s.Services = newServices
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	return s.UpdateSchema(ctx, true)
+	s.configuredServices = services
+	s.Services = s.buildServices(s.registry.apply(services))
+	s.servicesRevision++
+
+	return s.updateSchema(ctx, true)
 }
 
 // UpdateSchema updates the schema from every service and then update the merged
-// schema.
+// schema. The new state is published as a snapshot, operations in flight
+// complete with the snapshot they started with.
 func (s *ExecutableSchema) UpdateSchema(ctx context.Context, forceRebuild bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateSchema(ctx, forceRebuild)
+}
+
+// updateSchema updates the schema, it must be called with the mutex held.
+// Services are only polled once their poll interval elapsed, unless the
+// schema is rebuilt.
+func (s *ExecutableSchema) updateSchema(ctx context.Context, forceRebuild bool) error {
+	return s.pollAndMerge(ctx, forceRebuild, func(svc *Service, now time.Time) bool {
+		return forceRebuild || svc.pollDue(now)
+	})
+}
+
+// pollAndMerge polls the services for which poll returns true and merges the
+// schemas of the services, it must be called with the mutex held
+func (s *ExecutableSchema) pollAndMerge(ctx context.Context, forceRebuild bool, poll func(*Service, time.Time) bool) error {
 	var services []*Service
-	var schemas []*ast.Schema
-	var updatedServices []string
 	var invalidSchema bool
 
 	defer func() {
@@ -95,6 +243,7 @@ func (s *ExecutableSchema) UpdateSchema(ctx context.Context, forceRebuild bool)
 			promInvalidSchema.Set(0)
 		}
 	}()
+	defer s.publishHealth()
 
 	// Only fetch at most 64 services in parallel
 	var mutex sync.Mutex
@@ -103,32 +252,51 @@ func (s *ExecutableSchema) UpdateSchema(ctx context.Context, forceRebuild bool)
 	// Avoid fetching more than 64 servides in parallel,
 	// as high concurrency can actually hurt performance
 	group.SetLimit(64)
+	now := time.Now()
+	// the persisted schemas are only used until the first merge
+	var persisted map[string]PersistedServiceSchema
+	if s.MergedSchema == nil && s.SchemaSnapshotFile != "" {
+		persisted = readPersistedSchemas(s.SchemaSnapshotFile)
+	}
 	for url, s := range s.Services {
 		group.Go(func() error {
-			logger := log.With("url", url)
-			updated, err := s.Update(ctx)
-			if err != nil {
+			if !poll(s, now) {
+				if (s.pollErr == nil || s.Stale) && (s.Schema != nil || s.pending != nil) {
+					mutex.Lock()
+					services = append(services, s)
+					mutex.Unlock()
+				}
+				return nil
+			}
+
+			if err := s.poll(ctx, now); err != nil {
 				promServiceUpdateErrorCounter.WithLabelValues(s.ServiceURL).Inc()
 				promServiceUpdateErrorGauge.WithLabelValues(s.ServiceURL).Set(1)
 				invalidSchema, forceRebuild = true, true
-				logger.With("error", err).Error("failed updating service")
-				// Ignore this service in this update
+				log.With("url", url, "error", err).Error("failed updating service")
+				if schema, ok := persisted[url]; ok && s.Schema == nil {
+					if err := s.useStaleSchema(schema); err != nil {
+						log.With("url", url, "error", err).Error("invalid persisted schema")
+					}
+				}
+				if !s.Stale {
+					// Ignore this service in this update
+					return nil
+				}
+				log.With("url", url, "version", s.Version).Warn("using persisted schema")
+				mutex.Lock()
+				defer mutex.Unlock()
+				services = append(services, s)
 				return nil
 			}
 			promServiceUpdateErrorGauge.WithLabelValues(s.ServiceURL).Set(0)
+			if s.Quarantined {
+				log.With("url", url, "error", s.QuarantineError).Error("service quarantined")
+			}
 
 			mutex.Lock()
 			defer mutex.Unlock()
-			if updated {
-				logger.With(
-					"version", s.Version,
-					"service", s.Name,
-				).Info("service updated")
-				updatedServices = append(updatedServices, s.Name)
-			}
-
 			services = append(services, s)
-			schemas = append(schemas, s.Schema)
 
 			return nil
 		})
@@ -136,35 +304,157 @@ func (s *ExecutableSchema) UpdateSchema(ctx context.Context, forceRebuild bool)
 
 	group.Wait()
 
+	updatedServices := applyPendingSchemas(services, func(candidate *ast.Schema) error {
+		return s.checkSchemaChanges(s.MergedSchema, candidate)
+	})
 	if len(updatedServices) > 0 || forceRebuild {
+		services = slices.DeleteFunc(services, func(svc *Service) bool { return svc.Schema == nil })
+		var schemas []*ast.Schema
+		for _, svc := range services {
+			schemas = append(schemas, svc.Schema)
+		}
 		schema, err := MergeSchemas(schemas...)
 		if err != nil {
 			invalidSchema = true
 			return fmt.Errorf("update of service %v caused schema error: %w", updatedServices, err)
 		}
+		if s.MergedSchema != nil {
+			logSchemaChanges(updatedServices, diffSchemas(s.MergedSchema, schema))
+		}
 
 		boundaryQueries := buildBoundaryFieldsMap(services...)
 		locations := buildFieldURLMap(services...)
 		isBoundary := buildIsBoundaryMap(services...)
 
-		s.mutex.Lock()
-		s.Locations = locations
-		s.IsBoundary = isBoundary
-		s.MergedSchema = schema
-		s.BoundaryQueries = boundaryQueries
-		s.mutex.Unlock()
-		log.Info("merged schema updated")
+		// a rebuild, e.g. after a failed poll, often produces the same
+		// schema: the cached plans and entities are only dropped on change
+		if s.MergedSchema != nil && formatSchema(s.MergedSchema) == formatSchema(schema) &&
+			reflect.DeepEqual(s.Locations, locations) &&
+			reflect.DeepEqual(s.IsBoundary, isBoundary) &&
+			reflect.DeepEqual(s.BoundaryQueries, boundaryQueries) {
+			s.refreshSnapshot()
+		} else {
+			s.Locations = locations
+			s.IsBoundary = isBoundary
+			s.MergedSchema = schema
+			s.BoundaryQueries = boundaryQueries
+			if s.entityCache != nil {
+				s.entityCache.purge()
+			}
+			s.publishSnapshot()
+			log.Info("merged schema updated")
+		}
+
+		if s.SchemaSnapshotFile != "" {
+			s.writeSchemaSnapshot(services)
+		}
+	} else {
+		// the status of the services changes without a rebuild
+		s.refreshSnapshot()
 	}
 
 	return nil
 }
 
+// applyPendingSchemas applies the pending schemas that can be merged with the
+// schemas of the other services and whose merged schema passes the check. The
+// services whose pending schema is rejected are quarantined. It returns the
+// names of the updated services.
+func applyPendingSchemas(services []*Service, check func(*ast.Schema) error) []string {
+	var pending []*Service
+	for _, svc := range services {
+		if svc.pending != nil {
+			pending = append(pending, svc)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ServiceURL < pending[j].ServiceURL })
+
+	merge := func(applied map[*Service]bool) error {
+		var schemas []*ast.Schema
+		for _, svc := range services {
+			switch {
+			case applied[svc]:
+				schemas = append(schemas, svc.pending.schema)
+			case svc.Schema != nil:
+				schemas = append(schemas, svc.Schema)
+			}
+		}
+		schema, err := MergeSchemas(schemas...)
+		if err != nil {
+			return fmt.Errorf("schema can't be merged: %w", err)
+		}
+		return check(schema)
+	}
+
+	// the pending schemas are merged one by one only if they can't all be
+	// merged together, to find the ones to quarantine
+	applied := make(map[*Service]bool)
+	for _, svc := range pending {
+		applied[svc] = true
+	}
+	if err := merge(applied); err != nil {
+		applied = make(map[*Service]bool)
+		for _, svc := range pending {
+			applied[svc] = true
+			if err := merge(applied); err != nil {
+				delete(applied, svc)
+				svc.quarantine(err)
+				log.With("url", svc.ServiceURL, "error", svc.QuarantineError).Error("service quarantined")
+			}
+		}
+	}
+
+	var updated []string
+	for _, svc := range pending {
+		if !applied[svc] {
+			continue
+		}
+		svc.applyPending()
+		log.With("url", svc.ServiceURL, "version", svc.Version, "service", svc.Name).Info("service updated")
+		updated = append(updated, svc.Name)
+	}
+	return updated
+}
+
 // Exec returns the query execution handler
 func (s *ExecutableSchema) Exec(ctx context.Context) graphql.ResponseHandler {
+	operationCtx := graphql.GetOperationContext(ctx)
+	switch operationCtx.Operation.Operation {
+	case ast.Subscription:
+		return s.ExecuteSubscription(ctx)
+	case ast.Query:
+		if acceptsIncrementalDelivery(operationCtx.Headers) {
+			return s.ExecuteIncrementalQuery(ctx)
+		}
+	}
 	return s.ExecuteQuery
 }
 
 func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
+	response, _ := s.executeQuery(ctx, false)
+	return response
+}
+
+// executeQuery executes the query or mutation. When incremental delivery is
+// enabled the deferred fragments and streamed list items are returned as
+// pending incremental responses.
+func (s *ExecutableSchema) executeQuery(ctx context.Context, incremental bool) (*graphql.Response, *incrementalDelivery) {
+	done := s.operations.start()
+	var delivery *incrementalDelivery
+	defer func() {
+		if delivery == nil {
+			done()
+			return
+		}
+		// the operation is in flight until the incremental responses are
+		// delivered or the request is cancelled
+		delivery.done = sync.OnceFunc(done)
+		context.AfterFunc(delivery.ctx, delivery.done)
+	}()
+
 	operationCtx := graphql.GetOperationContext(ctx)
 	operation := operationCtx.Operation
 	variables := operationCtx.Variables
@@ -196,32 +486,118 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 	AddField(ctx, "operation.name", operation.Name)
 	AddField(ctx, "operation.type", operation.Operation)
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	if errs := s.checkQueryLimits(ctx, operation); len(errs) > 0 {
+		traceErr(errs)
+		AddField(ctx, "errors", errs)
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: errs,
+		}), nil
+	}
+
+	snapshot := s.currentSnapshot()
+	if snapshot.usage != nil {
+		snapshot.usage.record(snapshot.schema, operation)
+	}
 
 	// The op passed in is a cached value
 	// so it must be copied before modification
 	operation = s.evaluateSkipAndInclude(variables, operation)
-	filteredSchema := s.MergedSchema
+	evaluateIncrementalDirectives(variables, operation.SelectionSet, incremental && operation.Operation == ast.Query)
+	filteredSchema := snapshot.schema
 
 	var errs gqlerror.List
 	perms, hasPerms := GetPermissionsFromContext(ctx)
+
+	// the cache key is computed before the unauthorized fields are removed
+	var plan *QueryPlan
+	var planKey string
+	var planCached bool
+	operationVariables := variables
+	if snapshot.planCache != nil {
+		// the literal arguments are hoisted into variables so the operations
+		// only differing by their literal arguments share the same plan
+		var literals map[string]interface{}
+		operation, literals = hoistLiteralArguments(operation)
+		if len(literals) > 0 {
+			operationVariables = maps.Clone(variables)
+			if operationVariables == nil {
+				operationVariables = make(map[string]interface{}, len(literals))
+			}
+			maps.Copy(operationVariables, literals)
+			hoistedCtx := *operationCtx
+			hoistedCtx.Operation = operation
+			hoistedCtx.Variables = operationVariables
+			ctx = graphql.WithOperationContext(ctx, &hoistedCtx)
+		}
+
+		var cachedSchema *ast.Schema
+		if hasPerms {
+			planKey = planCacheKey(operation, &perms)
+		} else {
+			planKey = planCacheKey(operation, nil)
+		}
+		plan, cachedSchema, planCached = snapshot.planCache.get(planKey)
+		if planCached {
+			filteredSchema = cachedSchema
+		}
+	}
+
 	if hasPerms {
-		filteredSchema = perms.FilterSchema(s.MergedSchema)
+		if !planCached {
+			filteredSchema = perms.FilterSchema(snapshot.schema)
+		}
 		errs = perms.FilterAuthorizedFields(operation)
 	}
 
-	plan, err := Plan(&PlanningContext{
-		Operation:  operation,
-		Schema:     filteredSchema,
-		Locations:  s.Locations,
-		IsBoundary: s.IsBoundary,
-		Services:   s.Services,
-	})
-	if err != nil {
+	cost := s.operationCost(filteredSchema, operation, operationVariables)
+	AddField(ctx, "query.cost", cost)
+	if s.MaxQueryCost > 0 || hasCostDirectives(snapshot.schema) {
+		graphql.RegisterExtension(ctx, "cost", cost)
+	}
+	if s.MaxQueryCost > 0 && cost > s.MaxQueryCost {
+		err := queryCostExceededError(cost, s.MaxQueryCost)
 		traceErr(err)
-		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, "%s", err.Error()))
+		AddField(ctx, "errors", gqlerror.List{err})
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: gqlerror.List{err},
+		}), nil
+	}
+
+	var cache *responseCacheEntry
+	if s.ResponseCache != nil && operation.Operation == ast.Query && len(errs) == 0 {
+		var keyPerms *OperationPermissions
+		if hasPerms {
+			keyPerms = &perms
+		}
+		cache = s.lookupResponseCache(ctx, filteredSchema, operation, operationVariables, keyPerms)
+		if cache.response != nil {
+			AddField(ctx, "response.cached", true)
+			setCachePolicy(ctx, cache.policy)
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Data: cache.response,
+			}), nil
+		}
+		AddField(ctx, "response.cached", false)
+	}
+
+	if !planCached {
+		var err error
+		plan, err = Plan(&PlanningContext{
+			Operation:  operation,
+			Schema:     filteredSchema,
+			Locations:  snapshot.locations,
+			IsBoundary: snapshot.isBoundary,
+			Services:   snapshot.services,
+		})
+		if err != nil {
+			traceErr(err)
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, graphql.ErrorResponse(ctx, "%s", err.Error())), nil
+		}
+		if snapshot.planCache != nil {
+			snapshot.planCache.add(planKey, plan, filteredSchema)
+		}
 	}
+	AddField(ctx, "plan.cached", planCached)
 
 	extensions := make(map[string]interface{})
 	timings := make(map[string]interface{})
@@ -234,6 +610,7 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		}
 		if debugInfo.Plan {
 			extensions["plan"] = plan
+			extensions["planCached"] = planCached
 		}
 		if debugInfo.Timing {
 			extensions["timings"] = timings
@@ -246,14 +623,18 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 
 	executionStart := time.Now()
 
-	qe := newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, s.BoundaryQueries, int32(s.MaxRequestsPerQuery))
+	qe := newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, snapshot.boundaryQueries, int32(s.MaxRequestsPerQuery))
+	qe.mutationFailurePolicy = s.MutationFailurePolicy
+	qe.entityCache = snapshot.entityCache
+	qe.services = snapshot.services
+	qe.batching = s.boundaryBatching()
 
 	results, executeErrs := qe.Execute(plan)
 	if len(executeErrs) > 0 {
 		traceErr(executeErrs)
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: executeErrs,
-		})
+		}), nil
 	}
 
 	for _, result := range results {
@@ -272,6 +653,14 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		}
 	}
 
+	if len(results) == 0 && len(plan.Deferred) > 0 {
+		// every root field is deferred
+		results = append(results, executionResult{
+			ServiceURL: internalServiceName,
+			Data:       map[string]interface{}{},
+		})
+	}
+
 	timings["execution"] = time.Since(executionStart).String()
 
 	mergeStart := time.Now()
@@ -284,7 +673,7 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: errs,
-		})
+		}), nil
 	}
 
 	bubbleErrs, err := bubbleUpNullValuesInPlace(filteredSchema, operation.SelectionSet, mergedResult)
@@ -298,14 +687,39 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 
 		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
 			Errors: errs,
-		})
+		}), nil
 	}
 
 	errs = append(errs, bubbleErrs...)
 	timings["merge"] = time.Since(mergeStart).String()
 
 	formattingStart := time.Now()
-	formattedResponse := formatResponseData(filteredSchema, operation.SelectionSet, mergedResult)
+	var formattedResponse []byte
+	if incremental && mergedResult != nil {
+		delivery = &incrementalDelivery{
+			executableSchema: s,
+			ctx:              ctx,
+			operationName:    operationCtx.OperationName,
+			rawQuery:         operationCtx.RawQuery,
+			variables:        variables,
+			schema:           filteredSchema,
+			boundaryFields:   snapshot.boundaryQueries,
+			services:         snapshot.services,
+			entityCache:      snapshot.entityCache,
+			maxRequest:       int32(s.MaxRequestsPerQuery),
+			requestCount:     qe.requestCount,
+			data:             mergedResult,
+			deferred:         deferredDirectives(plan.Deferred, nil),
+			completed:        make(chan deferredFragmentResult),
+		}
+		formattedResponse = delivery.formatInitialResponse(operation.SelectionSet)
+		delivery.start(plan.Deferred)
+		if !delivery.hasNext() {
+			delivery = nil
+		}
+	} else {
+		formattedResponse = formatResponseData(filteredSchema, operation.SelectionSet, mergedResult)
+	}
 	timings["format"] = time.Since(formattingStart).String()
 
 	if len(errs) > 0 {
@@ -313,10 +727,66 @@ func (s *ExecutableSchema) ExecuteQuery(ctx context.Context) *graphql.Response {
 		AddField(ctx, "errors", errs)
 	}
 
-	return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+	if cache != nil {
+		if len(errs) > 0 || len(plan.Deferred) > 0 {
+			cache.policy = cachePolicy{}
+		}
+		setCachePolicy(ctx, cache.policy)
+		if cache.key != "" && cache.policy.cacheable() {
+			s.ResponseCache.Set(ctx, cache.key, encodeCachedResponse(formattedResponse, time.Now()), time.Duration(cache.policy.maxAge)*time.Second)
+		}
+	}
+
+	response := &graphql.Response{
 		Data:   formattedResponse,
 		Errors: errs,
-	})
+	}
+	if delivery != nil {
+		hasNext := true
+		response.HasNext = &hasNext
+	}
+	return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, response), delivery
+}
+
+// responseCacheEntry is the result of a response cache lookup
+type responseCacheEntry struct {
+	key      string
+	policy   cachePolicy
+	response []byte
+}
+
+// lookupResponseCache computes the cache policy of the operation and returns
+// the cached response if there is one, with the max age that remains for it.
+// The key is empty if the response can't be cached: private responses are
+// only cached for authenticated subjects.
+func (s *ExecutableSchema) lookupResponseCache(ctx context.Context, schema *ast.Schema, operation *ast.OperationDefinition, variables map[string]interface{}, perms *OperationPermissions) *responseCacheEntry {
+	entry := &responseCacheEntry{policy: operationCachePolicy(schema, operation)}
+	if !entry.policy.cacheable() {
+		return entry
+	}
+
+	var subject string
+	if entry.policy.private {
+		var ok bool
+		subject, ok = GetSubjectFromContext(ctx)
+		if !ok || subject == "" {
+			return entry
+		}
+	}
+
+	entry.key = responseCacheKey(operation, variables, perms, subject)
+	if value, ok := s.ResponseCache.Get(ctx, entry.key); ok {
+		response, storedAt, ok := decodeCachedResponse(value)
+		// the stores may keep the responses a bit longer than their max age
+		if age := int(time.Since(storedAt).Seconds()); ok && age < entry.policy.maxAge {
+			promResponseCacheCounter.WithLabelValues("hit").Inc()
+			entry.policy.maxAge -= age
+			entry.response = response
+			return entry
+		}
+	}
+	promResponseCacheCounter.WithLabelValues("miss").Inc()
+	return entry
 }
 
 func (s *ExecutableSchema) interceptResponse(ctx context.Context, operationName, rawQuery string, variables map[string]interface{}, response *graphql.Response) *graphql.Response {
@@ -328,13 +798,42 @@ func (s *ExecutableSchema) interceptResponse(ctx context.Context, operationName,
 
 // Schema returns the merged schema
 func (s *ExecutableSchema) Schema() *ast.Schema {
-	return s.MergedSchema
+	return s.currentSnapshot().schema
 }
 
-// Complexity returns the query complexity (unimplemented)
+// Complexity returns the cost of the field, using the same cost model as the
+// static cost analysis
 func (s *ExecutableSchema) Complexity(ctx context.Context, typeName, fieldName string, childComplexity int, args map[string]interface{}) (int, bool) {
-	// FIXME: TBD
-	return 0, false
+	schema := s.currentSnapshot().schema
+	typ := schema.Types[typeName]
+	if typ == nil {
+		return 0, false
+	}
+	field := typ.Fields.ForName(fieldName)
+	if field == nil {
+		return 0, false
+	}
+	weight := fieldWeight(schema, field, s.DefaultFieldCost)
+	size := fieldListSize(field, args, s.DefaultListSize)
+	return multiplyCost(size, addCost(weight, childComplexity)), true
+}
+
+// checkQueryLimits checks the operation against the query limits of the role
+// in the context
+func (s *ExecutableSchema) checkQueryLimits(ctx context.Context, operation *ast.OperationDefinition) gqlerror.List {
+	role, _ := GetRoleFromContext(ctx)
+	return s.QueryLimits.ForRole(role).Check(operation)
+}
+
+// operationCost returns the static cost of the operation
+func (s *ExecutableSchema) operationCost(schema *ast.Schema, operation *ast.OperationDefinition, variables map[string]interface{}) int {
+	analysis := costAnalysis{
+		schema:           schema,
+		variables:        variables,
+		defaultFieldCost: s.DefaultFieldCost,
+		defaultListSize:  s.DefaultListSize,
+	}
+	return analysis.selectionSetCost(operation.SelectionSet)
 }
 
 func resolveIntrospectionFields(ctx context.Context, selectionSet ast.SelectionSet, filteredSchema *ast.Schema) map[string]interface{} {
@@ -17,6 +17,15 @@ import (
 
 var errNullBubbledToRoot = errors.New("bubbleUpNullValuesInPlace: null bubbled up to root")
 
+const (
+	// boundaryIDsVariableName is the variable holding the ids of array
+	// boundary lookups
+	boundaryIDsVariableName = "_bramble_ids"
+	// boundaryIDVariablePrefix is the prefix of the variables holding the
+	// ids of non array boundary lookups
+	boundaryIDVariablePrefix = "_bramble_id_"
+)
+
 type executionResult struct {
 	ServiceURL     string
 	InsertionPoint []string
@@ -24,14 +33,43 @@ type executionResult struct {
 	Errors         gqlerror.List
 }
 
+// MutationFailurePolicy defines how the remaining root mutation fields are
+// handled when one of them fails. A field fails when its root step or one of
+// its child steps fails or returns errors.
+type MutationFailurePolicy string
+
+const (
+	// MutationFailureContinue executes the remaining mutation fields
+	MutationFailureContinue MutationFailurePolicy = "continue"
+	// MutationFailureAbort skips the remaining mutation fields, they are
+	// returned as null with an error
+	MutationFailureAbort MutationFailurePolicy = "abort"
+)
+
+// boundaryBatching holds the settings used to split and send the boundary
+// lookups of a step
+type boundaryBatching struct {
+	batchSize      int
+	arrayBatchSize int
+	parallelism    int
+}
+
 type queryExecution struct {
-	ctx            context.Context
-	operationName  string
-	schema         *ast.Schema
-	requestCount   int32
-	maxRequest     int32
-	graphqlClient  *GraphQLClient
-	boundaryFields BoundaryFieldsMap
+	ctx                   context.Context
+	operationName         string
+	schema                *ast.Schema
+	requestCount          int32
+	maxRequest            int32
+	graphqlClient         *GraphQLClient
+	boundaryFields        BoundaryFieldsMap
+	mutationFailurePolicy MutationFailurePolicy
+	entityCache           *entityCache
+	services              map[string]*Service
+	batching              boundaryBatching
+
+	// mutation is set when executing a mutation, the entity cache is then
+	// only written to as the mutation can change the cached entities
+	mutation bool
 
 	group   *errgroup.Group
 	results chan executionResult
@@ -52,20 +90,18 @@ func newQueryExecution(ctx context.Context, operationName string, client *GraphQ
 }
 
 func (q *queryExecution) Execute(queryPlan *QueryPlan) ([]executionResult, gqlerror.List) {
-	wg := &sync.WaitGroup{}
+	if len(queryPlan.RootSteps) > 0 && queryPlan.RootSteps[0].ParentType == mutationObjectName {
+		q.mutation = true
+		return q.executeSerially(queryPlan)
+	}
+
 	results := []executionResult{}
 
 	for _, step := range queryPlan.RootSteps {
 		if step.ServiceURL == internalServiceName {
-			reqStart := time.Now()
-			r, err := executeBrambleStep(step)
+			r, err := q.executeInternalStep(step)
 			if err != nil {
-				return nil, q.createGQLErrors(step, err)
-			}
-			step.executionResult = &executionStepResult{
-				executed:  true,
-				error:     err,
-				timeTaken: time.Since(reqStart),
+				return nil, err
 			}
 			results = append(results, *r)
 			continue
@@ -77,6 +113,132 @@ func (q *queryExecution) Execute(queryPlan *QueryPlan) ([]executionResult, gqler
 		})
 	}
 
+	return q.collectResults(results)
+}
+
+// executeSerially executes the root steps one after the other, in plan
+// order. Each step and all its child steps complete before the next root step
+// starts.
+func (q *queryExecution) executeSerially(queryPlan *QueryPlan) ([]executionResult, gqlerror.List) {
+	results := []executionResult{}
+	failed := false
+
+	for _, step := range queryPlan.RootSteps {
+		if step.ServiceURL == internalServiceName {
+			r, err := q.executeInternalStep(step)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, *r)
+			continue
+		}
+
+		if failed && q.mutationFailurePolicy == MutationFailureAbort {
+			results = append(results, q.skippedStepResult(step))
+			continue
+		}
+
+		stepExecution := newQueryExecution(q.ctx, q.operationName, q.graphqlClient, q.schema, q.boundaryFields, q.maxRequest)
+		stepExecution.requestCount = q.requestCount
+		stepExecution.entityCache = q.entityCache
+		stepExecution.services = q.services
+		stepExecution.batching = q.batching
+		stepExecution.mutation = q.mutation
+		stepExecution.group.Go(func() error {
+			return stepExecution.executeRootStep(step)
+		})
+		stepResults, errs := stepExecution.collectResults(results)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		q.requestCount = stepExecution.requestCount
+
+		// the field failed if the root step or one of its child steps failed
+		if step.executionResult != nil && step.executionResult.error != nil {
+			failed = true
+		}
+		for _, result := range stepResults[len(results):] {
+			if len(result.Errors) > 0 {
+				failed = true
+			}
+		}
+		results = stepResults
+	}
+
+	return results, nil
+}
+
+func (q *queryExecution) executeInternalStep(step *QueryPlanStep) (*executionResult, gqlerror.List) {
+	reqStart := time.Now()
+	r, err := executeBrambleStep(step)
+	if err != nil {
+		return nil, q.createGQLErrors(step, err)
+	}
+	step.executionResult = &executionStepResult{
+		executed:  true,
+		error:     err,
+		timeTaken: time.Since(reqStart),
+	}
+	return r, nil
+}
+
+// skippedStepResult returns a null value and an error for every field of a
+// root step that was not executed.
+func (q *queryExecution) skippedStepResult(step *QueryPlanStep) executionResult {
+	data, errs := q.skippedSelectionSetData(step, nil, step.SelectionSet)
+	return executionResult{
+		ServiceURL:     step.ServiceURL,
+		InsertionPoint: step.InsertionPoint,
+		Data:           data,
+		Errors:         errs,
+	}
+}
+
+func (q *queryExecution) skippedSelectionSetData(step *QueryPlanStep, path ast.Path, selectionSet ast.SelectionSet) (map[string]interface{}, gqlerror.List) {
+	data := make(map[string]interface{})
+	var errs gqlerror.List
+	for _, f := range selectionSetToFields(selectionSet) {
+		fieldPath := append(append(ast.Path{}, path...), ast.PathName(f.Alias))
+
+		// namespaces can contain fields from other steps, only the fields
+		// of this step are nulled
+		if f.Definition != nil {
+			if def, ok := q.schema.Types[f.Definition.Type.Name()]; ok && isNamespaceObject(def) {
+				fieldData, fieldErrs := q.skippedSelectionSetData(step, fieldPath, f.SelectionSet)
+				data[f.Alias] = fieldData
+				errs = append(errs, fieldErrs...)
+				continue
+			}
+		}
+
+		data[f.Alias] = nil
+		errs = append(errs, &gqlerror.Error{
+			Message: "mutation field not executed because a previous mutation field failed",
+			Path:    fieldPath,
+			Extensions: map[string]interface{}{
+				"serviceName": step.ServiceName,
+				"serviceUrl":  step.ServiceURL,
+			},
+		})
+	}
+	return data, errs
+}
+
+// ExecuteSubscriptionEvent runs the child steps of a subscription root step
+// using the data of a single event received from the downstream service.
+func (q *queryExecution) ExecuteSubscriptionEvent(step *QueryPlanStep, data map[string]interface{}, err error) ([]executionResult, gqlerror.List) {
+	eventStart := time.Now()
+	q.group.Go(func() error {
+		return q.handleRootStepResult(step, data, err, eventStart)
+	})
+
+	return q.collectResults([]executionResult{})
+}
+
+// collectResults waits for all the steps started on the execution group and
+// returns their results appended to the provided ones.
+func (q *queryExecution) collectResults(results []executionResult) ([]executionResult, gqlerror.List) {
+	wg := &sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
 		for result := range q.results {
@@ -116,7 +278,35 @@ func (q *queryExecution) executeRootStep(step *QueryPlanStep) error {
 		WithOperationType(step.ParentType)
 
 	var data map[string]interface{}
-	err := q.graphqlClient.Request(q.ctx, step.ServiceURL, req, &data)
+	err := q.request(step.ServiceURL, req, &data)
+	return q.handleRootStepResult(step, data, err, reqStart)
+}
+
+// serviceConfig returns the settings of the service, the defaults are used
+// for unknown services
+func (q *queryExecution) serviceConfig(serviceURL string) ServiceConfig {
+	if svc, ok := q.services[serviceURL]; ok {
+		return svc.Config
+	}
+	return ServiceConfig{URL: serviceURL}
+}
+
+// request sends the request to the service with the service settings, once
+// the bulkhead of the service lets it through
+func (q *queryExecution) request(serviceURL string, req *Request, out interface{}) error {
+	if svc, ok := q.services[serviceURL]; ok && svc.bulkhead != nil {
+		release, err := svc.bulkhead.acquire(q.ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	return q.graphqlClient.Request(q.ctx, serviceURL, q.serviceConfig(serviceURL).apply(req), out)
+}
+
+// handleRootStepResult writes the result of a root step and starts its child
+// steps.
+func (q *queryExecution) handleRootStepResult(step *QueryPlanStep, data map[string]interface{}, err error, reqStart time.Time) error {
 	q.writeExecutionResult(step, data, err)
 	step.executionResult = &executionStepResult{
 		executed:  true,
@@ -170,12 +360,7 @@ func (q *queryExecution) executeChildStep(step *QueryPlanStep, boundaryIDs []str
 		return err
 	}
 
-	documents, variables, err := buildBoundaryQueryDocuments(q.ctx, q.schema, step, boundaryIDs, boundaryField, 50)
-	if err != nil {
-		return err
-	}
-
-	data, err := q.executeBoundaryQuery(documents, step.ServiceURL, variables, boundaryField)
+	data, err := q.fetchBoundaryEntities(step, boundaryIDs, boundaryField)
 	q.writeExecutionResult(step, data, err)
 	step.executionResult = &executionStepResult{
 		executed:  true,
@@ -212,6 +397,41 @@ func (q *queryExecution) executeChildStep(step *QueryPlanStep, boundaryIDs []str
 	return nil
 }
 
+// fetchBoundaryEntities returns the entities for the boundary ids. When the
+// entity cache is enabled for the step type only the ids missing from the
+// cache are queried, the cached entities are returned along with the fetched
+// ones. Mutations always query the entities.
+func (q *queryExecution) fetchBoundaryEntities(step *QueryPlanStep, boundaryIDs []string, boundaryField BoundaryField) ([]interface{}, error) {
+	var cacheKeyPrefix string
+	var cached []interface{}
+	if q.entityCache != nil && q.entityCache.enabled(step.ParentType) {
+		cacheKeyPrefix = entityCacheKeyPrefix(q.ctx, step)
+		if !q.mutation {
+			cached, boundaryIDs = q.entityCache.get(cacheKeyPrefix, boundaryIDs)
+			if len(boundaryIDs) == 0 {
+				return cached, nil
+			}
+		}
+	}
+
+	batchSize, arrayBatchSize := q.batchSizes(step.ServiceURL)
+	documents, err := buildBoundaryQueryDocuments(q.ctx, q.schema, step, boundaryIDs, boundaryField, batchSize, arrayBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	promBoundaryStepIDsHistogram.WithLabelValues(step.ServiceURL).Observe(float64(len(boundaryIDs)))
+	promBoundaryStepDocumentsHistogram.WithLabelValues(step.ServiceURL).Observe(float64(len(documents)))
+
+	data, err := q.executeBoundaryQuery(documents, step.ServiceURL, boundaryField)
+	if err != nil {
+		return nil, err
+	}
+	if cacheKeyPrefix != "" {
+		q.entityCache.add(cacheKeyPrefix, step.ParentType, data)
+	}
+	return append(data, cached...), nil
+}
+
 func extractNonNilBoundaryResults(data []interface{}) []interface{} {
 	var nonNilResults []interface{}
 	for _, d := range data {
@@ -224,44 +444,67 @@ func extractNonNilBoundaryResults(data []interface{}) []interface{} {
 	return nonNilResults
 }
 
-func (q *queryExecution) executeBoundaryQuery(documents []string, serviceURL string, variables map[string]interface{}, boundaryFieldGetter BoundaryField) ([]interface{}, error) {
-	output := make([]interface{}, 0)
-	if !boundaryFieldGetter.Array {
-		for _, document := range documents {
-			req := NewRequest(document).
-				WithVariables(variables).
+// executeBoundaryQuery sends the boundary query documents concurrently, up to
+// the configured parallelism, and returns the entities in document order
+func (q *queryExecution) executeBoundaryQuery(documents []boundaryQueryDocument, serviceURL string, boundaryFieldGetter BoundaryField) ([]interface{}, error) {
+	results := make([][]interface{}, len(documents))
+	group := errgroup.Group{}
+	group.SetLimit(max(q.batching.parallelism, 1))
+	for i, document := range documents {
+		group.Go(func() error {
+			req := NewRequest(document.query).
+				WithVariables(document.variables).
 				WithHeaders(GetOutgoingRequestHeadersFromContext(q.ctx)).
 				WithOperationName(q.operationName).
 				WithOperationType(queryObjectName)
 
+			if boundaryFieldGetter.Array {
+				data := struct {
+					Result []interface{} `json:"_result"`
+				}{}
+				if err := q.request(serviceURL, req, &data); err != nil {
+					return err
+				}
+				results[i] = data.Result
+				return nil
+			}
+
 			partialData := make(map[string]interface{})
-			err := q.graphqlClient.Request(q.ctx, serviceURL, req, &partialData)
-			if err != nil {
-				return nil, err
+			if err := q.request(serviceURL, req, &partialData); err != nil {
+				return err
 			}
 			for _, value := range partialData {
-				output = append(output, value)
+				results[i] = append(results[i], value)
 			}
-		}
-		return output, nil
+			return nil
+		})
 	}
-
-	if len(documents) != 1 {
-		return nil, errors.New("there should only be a single document for array boundary field lookups")
+	if err := group.Wait(); err != nil {
+		return nil, err
 	}
 
-	data := struct {
-		Result []interface{} `json:"_result"`
-	}{}
-
-	req := NewRequest(documents[0]).
-		WithVariables(variables).
-		WithHeaders(GetOutgoingRequestHeadersFromContext(q.ctx)).
-		WithOperationName(q.operationName).
-		WithOperationType(queryObjectName)
+	output := make([]interface{}, 0)
+	for _, result := range results {
+		output = append(output, result...)
+	}
+	return output, nil
+}
 
-	err := q.graphqlClient.Request(q.ctx, serviceURL, req, &data)
-	return data.Result, err
+// batchSizes returns the number of ids per request for the non array and
+// array boundary lookups of the service
+func (q *queryExecution) batchSizes(serviceURL string) (int, int) {
+	config := q.serviceConfig(serviceURL)
+	batchSize, arrayBatchSize := q.batching.batchSize, q.batching.arrayBatchSize
+	if config.BatchSize > 0 {
+		batchSize = config.BatchSize
+	}
+	if config.ArrayBatchSize > 0 {
+		arrayBatchSize = config.ArrayBatchSize
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBoundaryBatchSize
+	}
+	return batchSize, arrayBatchSize
 }
 
 func (q *queryExecution) createGQLErrors(step *QueryPlanStep, err error) gqlerror.List {
@@ -276,7 +519,10 @@ func (q *queryExecution) createGQLErrors(step *QueryPlanStep, err error) gqlerro
 		if pos == nil {
 			continue
 		}
-		locs = append(locs, gqlerror.Location{Line: pos.Line, Column: pos.Column})
+		// the positions of a cached plan don't match the current operation
+		if !step.cached {
+			locs = append(locs, gqlerror.Location{Line: pos.Line, Column: pos.Column})
+		}
 
 		// if the field has a selection set it's part of the path
 		if len(f.SelectionSet) > 0 {
@@ -310,6 +556,36 @@ func (q *queryExecution) createGQLErrors(step *QueryPlanStep, err error) gqlerro
 		}
 		return outputErrs
 
+	case errors.Is(err, errCircuitOpen):
+		outputErrs = append(outputErrs, &gqlerror.Error{
+			Err:       err,
+			Message:   "downstream service unavailable: circuit breaker open",
+			Path:      path,
+			Locations: locs,
+			Extensions: map[string]interface{}{
+				"code":         circuitOpenCode,
+				"selectionSet": formatSelectionSetSingleLine(q.ctx, q.schema, step.SelectionSet),
+				"serviceName":  step.ServiceName,
+				"serviceUrl":   step.ServiceURL,
+			},
+			Rule: "",
+		})
+
+	case errors.Is(err, errServiceOverloaded):
+		outputErrs = append(outputErrs, &gqlerror.Error{
+			Err:       err,
+			Message:   err.Error(),
+			Path:      path,
+			Locations: locs,
+			Extensions: map[string]interface{}{
+				"code":         serviceOverloadedCode,
+				"selectionSet": formatSelectionSetSingleLine(q.ctx, q.schema, step.SelectionSet),
+				"serviceName":  step.ServiceName,
+				"serviceUrl":   step.ServiceURL,
+			},
+			Rule: "",
+		})
+
 	case os.IsTimeout(err):
 		outputErrs = append(outputErrs, &gqlerror.Error{
 			Err:       err,
@@ -482,35 +758,57 @@ func extractBoundaryIDs(data interface{}, insertionPoint []string, parentType st
 	}
 }
 
-func buildBoundaryQueryDocuments(ctx context.Context, schema *ast.Schema, step *QueryPlanStep, ids []string, parentTypeBoundaryField BoundaryField, batchSize int) ([]string, map[string]interface{}, error) {
-	operation, variables := formatOperation(ctx, step.SelectionSet)
+// boundaryQueryDocument is a boundary query document and its variables
+type boundaryQueryDocument struct {
+	query     string
+	variables map[string]interface{}
+}
+
+// buildBoundaryQueryDocuments builds the documents looking up the boundary
+// objects. The boundary ids and the literal arguments are passed as
+// variables, the documents for batches of the same size are identical.
+func buildBoundaryQueryDocuments(ctx context.Context, schema *ast.Schema, step *QueryPlanStep, ids []string, parentTypeBoundaryField BoundaryField, batchSize int, arrayBatchSize int) ([]boundaryQueryDocument, error) {
+	selectionVariables := &documentVariables{}
+	selectionSetQL := multipleSpacesRegex.ReplaceAllString(formatSelectionSetWithVariables(schema, selectionVariables, step.SelectionSet), " ")
 
-	selectionSetQL := formatSelectionSetSingleLine(ctx, schema, step.SelectionSet)
 	if parentTypeBoundaryField.Array {
-		var qids []string
-		for _, id := range ids {
-			qids = append(qids, fmt.Sprintf("%q", id))
+		// a batch size of 0 sends all the ids in a single document
+		batches := [][]string{ids}
+		if arrayBatchSize > 0 {
+			batches = batchBy(ids, arrayBatchSize)
+		}
+		var documents []boundaryQueryDocument
+		for _, batch := range batches {
+			variables := &documentVariables{}
+			idsVariable := variables.add(boundaryIDsVariableName, "[ID!]!", batch)
+			variables.merge(selectionVariables)
+			operation, values := formatOperation(ctx, step.SelectionSet, variables)
+			documents = append(documents, boundaryQueryDocument{
+				query:     fmt.Sprintf(`query %s { _result: %s(%s: %s) %s }`, operation, parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, idsVariable, selectionSetQL),
+				variables: values,
+			})
 		}
-		idsQL := fmt.Sprintf("[%s]", strings.Join(qids, ", "))
-		return []string{fmt.Sprintf(`query %s { _result: %s(%s: %s) %s }`, operation, parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, idsQL, selectionSetQL)}, variables, nil
+		return documents, nil
 	}
 
-	var (
-		documents      []string
-		selectionIndex int
-	)
+	var documents []boundaryQueryDocument
 	for _, batch := range batchBy(ids, batchSize) {
+		variables := &documentVariables{}
 		var selections []string
-		for _, id := range batch {
-			selection := fmt.Sprintf("%s: %s(%s: %q) %s", fmt.Sprintf("_%d", selectionIndex), parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, id, selectionSetQL)
+		for i, id := range batch {
+			idVariable := variables.add(fmt.Sprintf("%s%d", boundaryIDVariablePrefix, i), "ID!", id)
+			selection := fmt.Sprintf("_%d: %s(%s: %s) %s", i, parentTypeBoundaryField.Field, parentTypeBoundaryField.Argument, idVariable, selectionSetQL)
 			selections = append(selections, selection)
-			selectionIndex++
 		}
-		document := fmt.Sprintf("query %s { %s }", operation, strings.Join(selections, " "))
-		documents = append(documents, document)
+		variables.merge(selectionVariables)
+		operation, values := formatOperation(ctx, step.SelectionSet, variables)
+		documents = append(documents, boundaryQueryDocument{
+			query:     fmt.Sprintf("query %s { %s }", operation, strings.Join(selections, " ")),
+			variables: values,
+		})
 	}
 
-	return documents, variables, nil
+	return documents, nil
 }
 
 func batchBy(items []string, batchSize int) (batches [][]string) {
@@ -52,12 +52,14 @@ func TestBuildBoundaryQueryDocuments(t *testing.T) {
 		InsertionPoint: []string{"gizmos", "owner"},
 		Then:           nil,
 	}
-	expected := []string{`query operationName { _result: getOwners(ids: ["1", "2", "3"]) { _bramble_id: id name } }`}
+	expected := []boundaryQueryDocument{{
+		query:     `query operationName($_bramble_ids: [ID!]!) { _result: getOwners(ids: $_bramble_ids) { _bramble_id: id name } }`,
+		variables: map[string]interface{}{"_bramble_ids": []string{"1", "2", "3"}},
+	}}
 	ctx := testContextWithoutVariables(&ast.OperationDefinition{Name: "operationName"})
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1)
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1, 0)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
-	require.Equal(t, (map[string]interface{})(nil), vars)
 }
 
 func TestBuildBoundaryQueryDocumentsWithVariables(t *testing.T) {
@@ -93,12 +95,65 @@ func TestBuildBoundaryQueryDocumentsWithVariables(t *testing.T) {
 		InsertionPoint: []string{"gizmos", "owner"},
 		Then:           nil,
 	}
-	expected := []string{`query ($format: String) { _result: getOwners(ids: ["1", "2", "3"]) { _bramble_id: id name(format: $format) } }`}
+	expected := []boundaryQueryDocument{{
+		query:     `query ($format: String,$_bramble_ids: [ID!]!) { _result: getOwners(ids: $_bramble_ids) { _bramble_id: id name(format: $format) } }`,
+		variables: map[string]interface{}{"format": "upper", "_bramble_ids": []string{"1", "2", "3"}},
+	}}
 	ctx := testContextWithVariables(map[string]interface{}{"format": "upper"}, query.Operations[0])
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1)
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, expected, docs)
+}
+
+func TestBuildBatchedArrayBoundaryQueryDocuments(t *testing.T) {
+	ddl := `
+		type Gizmo {
+			id: ID!
+			owner: Owner
+		}
+
+		type Owner {
+			id: ID!
+		}
+
+		type Query {
+			gizmos: [Gizmo!]!
+			getOwners(ids: [ID!]!): [Owner!]!
+		}
+	`
+	schema := gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: ddl})
+	boundaryField := BoundaryField{Field: "getOwners", Argument: "ids", Array: true}
+	ids := []string{"1", "2", "3"}
+	selectionSet := []ast.Selection{
+		&ast.Field{
+			Alias:            "_bramble_id",
+			Name:             "id",
+			Definition:       schema.Types["Owner"].Fields.ForName("id"),
+			ObjectDefinition: schema.Types["Owner"],
+		},
+	}
+	step := &QueryPlanStep{
+		ServiceURL:     "http://example.com:8080",
+		ServiceName:    "test",
+		ParentType:     "Gizmo",
+		SelectionSet:   selectionSet,
+		InsertionPoint: []string{"gizmos", "owner"},
+		Then:           nil,
+	}
+	expected := []boundaryQueryDocument{
+		{
+			query:     `query op($_bramble_ids: [ID!]!) { _result: getOwners(ids: $_bramble_ids) { _bramble_id: id } }`,
+			variables: map[string]interface{}{"_bramble_ids": []string{"1", "2"}},
+		},
+		{
+			query:     `query op($_bramble_ids: [ID!]!) { _result: getOwners(ids: $_bramble_ids) { _bramble_id: id } }`,
+			variables: map[string]interface{}{"_bramble_ids": []string{"3"}},
+		},
+	}
+	ctx := testContextWithoutVariables(&ast.OperationDefinition{Name: "op"})
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 50, 2)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
-	require.Equal(t, map[string]interface{}{"format": "upper"}, vars)
 }
 
 func TestBuildNonArrayBoundaryQueryDocuments(t *testing.T) {
@@ -144,12 +199,14 @@ func TestBuildNonArrayBoundaryQueryDocuments(t *testing.T) {
 		InsertionPoint: []string{"gizmos", "owner"},
 		Then:           nil,
 	}
-	expected := []string{`query name { _0: getOwner(id: "1") { _bramble_id: id name } _1: getOwner(id: "2") { _bramble_id: id name } _2: getOwner(id: "3") { _bramble_id: id name } }`}
+	expected := []boundaryQueryDocument{{
+		query:     `query name($_bramble_id_0: ID!,$_bramble_id_1: ID!,$_bramble_id_2: ID!) { _0: getOwner(id: $_bramble_id_0) { _bramble_id: id name } _1: getOwner(id: $_bramble_id_1) { _bramble_id: id name } _2: getOwner(id: $_bramble_id_2) { _bramble_id: id name } }`,
+		variables: map[string]interface{}{"_bramble_id_0": "1", "_bramble_id_1": "2", "_bramble_id_2": "3"},
+	}}
 	ctx := testContextWithoutVariables(&ast.OperationDefinition{Name: "name"})
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10)
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10, 0)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
-	require.Equal(t, (map[string]interface{})(nil), vars)
 }
 
 func TestBuildNonArrayBoundaryQueryDocumentsWithVariables(t *testing.T) {
@@ -186,12 +243,14 @@ func TestBuildNonArrayBoundaryQueryDocumentsWithVariables(t *testing.T) {
 		Then:           nil,
 	}
 
-	expected := []string{`query ($format: String) { _0: getOwner(id: "1") { _bramble_id: id name(format: $format) } _1: getOwner(id: "2") { _bramble_id: id name(format: $format) } _2: getOwner(id: "3") { _bramble_id: id name(format: $format) } }`}
+	expected := []boundaryQueryDocument{{
+		query:     `query ($format: String,$_bramble_id_0: ID!,$_bramble_id_1: ID!,$_bramble_id_2: ID!) { _0: getOwner(id: $_bramble_id_0) { _bramble_id: id name(format: $format) } _1: getOwner(id: $_bramble_id_1) { _bramble_id: id name(format: $format) } _2: getOwner(id: $_bramble_id_2) { _bramble_id: id name(format: $format) } }`,
+		variables: map[string]interface{}{"format": "lower", "_bramble_id_0": "1", "_bramble_id_1": "2", "_bramble_id_2": "3"},
+	}}
 	ctx := testContextWithVariables(map[string]interface{}{"format": "lower"}, query.Operations[0])
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10)
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 10, 0)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
-	require.Equal(t, map[string]interface{}{"format": "lower"}, vars)
 }
 
 func TestBuildBatchedNonArrayBoundaryQueryDocuments(t *testing.T) {
@@ -237,12 +296,20 @@ func TestBuildBatchedNonArrayBoundaryQueryDocuments(t *testing.T) {
 		InsertionPoint: []string{"gizmos", "owner"},
 		Then:           nil,
 	}
-	expected := []string{`query op { _0: getOwner(id: "1") { _bramble_id: id name } _1: getOwner(id: "2") { _bramble_id: id name } }`, `query op { _2: getOwner(id: "3") { _bramble_id: id name } }`}
+	expected := []boundaryQueryDocument{
+		{
+			query:     `query op($_bramble_id_0: ID!,$_bramble_id_1: ID!) { _0: getOwner(id: $_bramble_id_0) { _bramble_id: id name } _1: getOwner(id: $_bramble_id_1) { _bramble_id: id name } }`,
+			variables: map[string]interface{}{"_bramble_id_0": "1", "_bramble_id_1": "2"},
+		},
+		{
+			query:     `query op($_bramble_id_0: ID!) { _0: getOwner(id: $_bramble_id_0) { _bramble_id: id name } }`,
+			variables: map[string]interface{}{"_bramble_id_0": "3"},
+		},
+	}
 	ctx := testContextWithoutVariables(&ast.OperationDefinition{Name: "op"})
-	docs, vars, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 2)
+	docs, err := buildBoundaryQueryDocuments(ctx, schema, step, ids, boundaryField, 2, 0)
 	require.NoError(t, err)
 	require.Equal(t, expected, docs)
-	require.Equal(t, (map[string]interface{})(nil), vars)
 }
 
 func TestUnionAndTrimSelectionSet(t *testing.T) {
@@ -432,6 +432,46 @@ func TestIntrospectionQuery(t *testing.T) {
 					  }
 					}
 				  ]
+				},
+				{
+				  "name": "defer",
+				  "args": [
+					{
+					  "name": "if",
+					  "type": {
+						"name": "Boolean"
+					  }
+					},
+					{
+					  "name": "label",
+					  "type": {
+						"name": "String"
+					  }
+					}
+				  ]
+				},
+				{
+				  "name": "stream",
+				  "args": [
+					{
+					  "name": "if",
+					  "type": {
+						"name": "Boolean"
+					  }
+					},
+					{
+					  "name": "label",
+					  "type": {
+						"name": "String"
+					  }
+					},
+					{
+					  "name": "initialCount",
+					  "type": {
+						"name": "Int"
+					  }
+					}
+				  ]
 				}
 			  ]
 			}
@@ -10,10 +10,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2"
@@ -1744,12 +1747,12 @@ func TestQueryExecutionMultipleServicesWithArray(t *testing.T) {
 			{
 				schema: schema1,
 				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					var req map[string]string
+					var req testRequest
 					json.NewDecoder(r.Body).Decode(&req)
-					query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: schema1}), req["query"])
+					query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: schema1}), req.Query)
 					var ids []string
 					for _, s := range query.Operations[0].SelectionSet {
-						ids = append(ids, s.(*ast.Field).Arguments[0].Value.Raw)
+						ids = append(ids, req.argument(s.(*ast.Field), 0).(string))
 					}
 					if query.Operations[0].SelectionSet[0].(*ast.Field).Name == "_movie" {
 						var res string
@@ -1949,12 +1952,12 @@ func TestQueryExecutionMultipleServicesWithNestedArrays(t *testing.T) {
 		{
 			schema: schema1,
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				var req map[string]string
+				var req testRequest
 				json.NewDecoder(r.Body).Decode(&req)
-				query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: schema1}), req["query"])
+				query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: schema1}), req.Query)
 				var ids []string
 				for _, s := range query.Operations[0].SelectionSet {
-					ids = append(ids, s.(*ast.Field).Arguments[0].Value.Raw)
+					ids = append(ids, req.argument(s.(*ast.Field), 0).(string))
 				}
 				if query.Operations[0].SelectionSet[0].(*ast.Field).Name == "_movie" {
 					var res string
@@ -2202,13 +2205,13 @@ func TestQueryExecutionWithInputObject(t *testing.T) {
 			{
 				schema: schema1,
 				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					var q map[string]string
-					json.NewDecoder(r.Body).Decode(&q)
-					assertQueriesEqual(t, schema1, `{
-						movie(in: {id: "1", title: "title"}) {
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					assertQueriesEqual(t, schema1, `query ($_bramble_arg_0: MovieInput, $_bramble_arg_1: MovieInput) {
+						movie(in: $_bramble_arg_0) {
 							id
 							title
-							otherMovie(arg: {id: "2", title: "another title"}) {
+							otherMovie(arg: $_bramble_arg_1) {
 								title
 								_bramble_id: id
 								_bramble__typename: __typename
@@ -2216,7 +2219,11 @@ func TestQueryExecutionWithInputObject(t *testing.T) {
 							_bramble_id: id
 							_bramble__typename: __typename
 						}
-					}`, q["query"])
+					}`, req.Query)
+					assert.Equal(t, map[string]interface{}{
+						"_bramble_arg_0": map[string]interface{}{"id": "1", "title": "title"},
+						"_bramble_arg_1": map[string]interface{}{"id": "2", "title": "another title"},
+					}, req.Variables)
 					w.Write([]byte(`{
 						"data": {
 							"movie": {
@@ -2706,9 +2713,10 @@ func TestMutationExecution(t *testing.T) {
 			{
 				schema: schema1,
 				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					var q map[string]string
-					json.NewDecoder(r.Body).Decode(&q)
-					assertQueriesEqual(t, schema1, `mutation { updateTitle(id: "2", title: "New title") { title _bramble_id: id _bramble__typename: __typename } }`, q["query"])
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					assertQueriesEqual(t, schema1, `mutation ($_bramble_arg_0: ID!, $_bramble_arg_1: String) { updateTitle(id: $_bramble_arg_0, title: $_bramble_arg_1) { title _bramble_id: id _bramble__typename: __typename } }`, req.Query)
+					assert.Equal(t, map[string]interface{}{"_bramble_arg_0": "2", "_bramble_arg_1": "New title"}, req.Variables)
 
 					w.Write([]byte(`{
 						"data": {
@@ -3286,6 +3294,85 @@ func TestQueryWithArrayBoundaryFields(t *testing.T) {
 	f.run(t, es, f.checkSuccess())
 }
 
+func TestQueryWithBatchedArrayBoundaryFields(t *testing.T) {
+	var requests, inFlight, maxInFlight int32
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+
+				type Movie @boundary {
+					id: ID!
+				}
+
+				type Query {
+					randomMovies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"randomMovies": [
+						{"_bramble_id": "1", "_bramble__typename": "Movie", "id": "1"},
+						{"_bramble_id": "2", "_bramble__typename": "Movie", "id": "2"},
+						{"_bramble_id": "3", "_bramble__typename": "Movie", "id": "3"}
+					]}}`))
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+
+				type Movie @boundary {
+					id: ID!
+					title: String
+				}
+
+				type Query {
+					movies(ids: [ID!]): [Movie]! @boundary
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&requests, 1)
+					current := atomic.AddInt32(&inFlight, 1)
+					defer atomic.AddInt32(&inFlight, -1)
+					for {
+						previous := atomic.LoadInt32(&maxInFlight)
+						if current <= previous || atomic.CompareAndSwapInt32(&maxInFlight, previous, current) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					var movies []string
+					for _, id := range req.Variables["_bramble_ids"].([]interface{}) {
+						movies = append(movies, fmt.Sprintf(`{"_bramble_id": "%s", "_bramble__typename": "Movie", "title": "Movie %s"}`, id, id))
+					}
+					fmt.Fprintf(w, `{"data": {"_result": [%s]}}`, strings.Join(movies, ","))
+				}),
+			},
+		},
+		query: `{
+			randomMovies {
+				id
+				title
+			}
+		}`,
+		expected: `{
+			"randomMovies": [
+				{"id": "1", "title": "Movie 1"},
+				{"id": "2", "title": "Movie 2"},
+				{"id": "3", "title": "Movie 3"}
+			]
+		}`,
+	}
+
+	es := f.setup(t)
+	es.BoundaryArrayBatchSize = 1
+	es.BoundaryParallelism = 2
+	f.run(t, es, f.checkSuccess())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "the ids are split in batches of 1")
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(2), "at most 2 batches are sent concurrently")
+	assert.Positive(t, testutil.CollectAndCount(promBoundaryStepDocumentsHistogram))
+}
+
 func TestQueryWithAbstractType(t *testing.T) {
 	f := &queryExecutionFixture{
 		services: []testService{
@@ -3492,11 +3579,243 @@ func TestSchemaUpdate_serviceError(t *testing.T) {
 	}
 }
 
+func TestSchemaUpdate_quarantine(t *testing.T) {
+	const serviceType = `type Service {
+		name: String!
+		version: String!
+		schema: String!
+	}
+	`
+	gizmos := serviceType + `type Gizmo { name: String! } type Query { service: Service! gizmo: Gizmo }`
+	gadgets := serviceType + `type Gadget { name: String! } type Query { service: Service! gadget: Gadget }`
+
+	var schemaA, schemaB atomic.Value
+	schemaA.Store(gizmos)
+	schemaB.Store(gadgets)
+	newSchemaService := func(name string, schema *atomic.Value) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeServiceSchema(w, name, schema.Load().(string))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	serviceA := NewService(newSchemaService("serviceA", &schemaA).URL)
+	serviceB := NewService(newSchemaService("serviceB", &schemaB).URL)
+	es := NewExecutableSchema(nil, 50, nil, serviceA, serviceB)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+
+	// serviceA now conflicts with serviceB, serviceB is still updated
+	schemaA.Store(gizmos + ` type Gadget { id: ID! }`)
+	schemaB.Store(serviceType + `type Gadget { name: String! } type Query { service: Service! gadget: Gadget gadgets: [Gadget!] }`)
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.True(t, serviceA.Quarantined)
+	assert.Contains(t, serviceA.QuarantineError, "schema can't be merged")
+	assert.Equal(t, gizmos, serviceA.SchemaSource, "the previous schema is kept")
+	assert.Equal(t, 1.0, testutil.ToFloat64(promServiceQuarantinedGauge.WithLabelValues(serviceA.ServiceURL)))
+	assert.False(t, serviceB.Quarantined)
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("gizmo"))
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("gadgets"))
+
+	// the quarantined schema is not used on the next rebuild
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.True(t, serviceA.Quarantined)
+	assert.Equal(t, "Gizmo", es.MergedSchema.Query.Fields.ForName("gizmo").Type.Name())
+
+	schemaA.Store(`type Query {`)
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.True(t, serviceA.Quarantined, "invalid schemas are quarantined")
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("gizmo"))
+
+	schemaA.Store(gizmos)
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.False(t, serviceA.Quarantined)
+	assert.Equal(t, "OK", serviceA.Status)
+	assert.Equal(t, 0.0, testutil.ToFloat64(promServiceQuarantinedGauge.WithLabelValues(serviceA.ServiceURL)))
+}
+
 type testService struct {
 	schema  string
 	handler http.Handler
 }
 
+// serialMutationFixture returns a fixture with two services where the root
+// mutation fields alternate between services. The calls received by the
+// services are appended to calls in the order they are received, the call
+// named fail returns an error.
+func serialMutationFixture(calls *[]string, fail string) *queryExecutionFixture {
+	var mutex sync.Mutex
+	record := func(call string) {
+		mutex.Lock()
+		*calls = append(*calls, call)
+		mutex.Unlock()
+	}
+
+	return &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Order @boundary {
+					id: ID!
+					total: Int!
+				}
+
+				type Query {
+					order(id: ID!): Order @boundary
+				}
+
+				type Mutation {
+					createOrder: Order!
+					shipOrder(id: ID!): Boolean
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					switch {
+					case strings.Contains(string(body), "createOrder"):
+						// slow down the first mutation so any concurrent
+						// execution would be observed
+						time.Sleep(20 * time.Millisecond)
+						record("createOrder")
+						w.Write([]byte(`{"data": {"createOrder": {"_bramble_id": "1", "_bramble__typename": "Order", "id": "1"}}}`))
+					case strings.Contains(string(body), "shipOrder"):
+						record("shipOrder")
+						w.Write([]byte(`{"data": {"shipOrder": true}}`))
+					}
+				}),
+			},
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+				type Order @boundary {
+					id: ID!
+					paid: Boolean
+				}
+
+				type Query {
+					order(id: ID!): Order @boundary
+				}
+
+				type Mutation {
+					chargeCard(orderId: ID!): Boolean
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					switch {
+					case strings.Contains(string(body), "chargeCard"):
+						record("chargeCard")
+						if fail == "chargeCard" {
+							w.Write([]byte(`{"data": {"chargeCard": null}, "errors": [{"message": "card declined"}]}`))
+							return
+						}
+						w.Write([]byte(`{"data": {"chargeCard": true}}`))
+					case strings.Contains(string(body), "order"):
+						time.Sleep(20 * time.Millisecond)
+						record("order")
+						if fail == "order" {
+							w.Write([]byte(`{"data": {"_0": null}, "errors": [{"message": "order not found"}]}`))
+							return
+						}
+						w.Write([]byte(`{"data": {"_0": {"_bramble_id": "1", "_bramble__typename": "Order", "paid": false}}}`))
+					}
+				}),
+			},
+		},
+		query: `mutation {
+			createOrder {
+				id
+				paid
+			}
+			chargeCard(orderId: "1")
+			shipOrder(id: "1")
+		}`,
+	}
+}
+
+func TestQueryExecutionSerialMutations(t *testing.T) {
+	var calls []string
+	f := serialMutationFixture(&calls, "")
+	f.expected = `{
+		"createOrder": {
+			"id": "1",
+			"paid": false
+		},
+		"chargeCard": true,
+		"shipOrder": true
+	}`
+
+	es := f.setup(t)
+	f.run(t, es, f.checkSuccess())
+	assert.Equal(t, []string{"createOrder", "order", "chargeCard", "shipOrder"}, calls)
+}
+
+func TestQueryExecutionSerialMutationsContinueOnFailure(t *testing.T) {
+	var calls []string
+	f := serialMutationFixture(&calls, "chargeCard")
+	f.expected = `{
+		"createOrder": {
+			"id": "1",
+			"paid": false
+		},
+		"chargeCard": null,
+		"shipOrder": true
+	}`
+
+	es := f.setup(t)
+	es.MutationFailurePolicy = MutationFailureContinue
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		jsonEqWithOrder(t, f.expected, string(resp.Data))
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "card declined", resp.Errors[0].Message)
+	})
+	assert.Equal(t, []string{"createOrder", "order", "chargeCard", "shipOrder"}, calls)
+}
+
+func TestQueryExecutionSerialMutationsAbortOnFailure(t *testing.T) {
+	var calls []string
+	f := serialMutationFixture(&calls, "chargeCard")
+	f.expected = `{
+		"createOrder": {
+			"id": "1",
+			"paid": false
+		},
+		"chargeCard": null,
+		"shipOrder": null
+	}`
+
+	es := f.setup(t)
+	es.MutationFailurePolicy = MutationFailureAbort
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		jsonEqWithOrder(t, f.expected, string(resp.Data))
+		require.Len(t, resp.Errors, 2)
+		assert.Equal(t, "card declined", resp.Errors[0].Message)
+		assert.Equal(t, "mutation field not executed because a previous mutation field failed", resp.Errors[1].Message)
+		assert.Equal(t, ast.Path{ast.PathName("shipOrder")}, resp.Errors[1].Path)
+	})
+	assert.Equal(t, []string{"createOrder", "order", "chargeCard"}, calls)
+}
+
+func TestQueryExecutionSerialMutationsAbortOnChildStepFailure(t *testing.T) {
+	var calls []string
+	f := serialMutationFixture(&calls, "order")
+	f.expected = `{
+		"createOrder": {
+			"id": "1",
+			"paid": null
+		},
+		"chargeCard": null,
+		"shipOrder": null
+	}`
+
+	es := f.setup(t)
+	es.MutationFailurePolicy = MutationFailureAbort
+	f.run(t, es, func(t *testing.T, resp *graphql.Response) {
+		jsonEqWithOrder(t, f.expected, string(resp.Data))
+		require.Len(t, resp.Errors, 3)
+		assert.Equal(t, "order not found", resp.Errors[0].Message)
+		assert.Equal(t, ast.Path{ast.PathName("chargeCard")}, resp.Errors[1].Path)
+		assert.Equal(t, ast.Path{ast.PathName("shipOrder")}, resp.Errors[2].Path)
+	})
+	assert.Equal(t, []string{"createOrder", "order"}, calls)
+}
+
 type queryExecutionFixture struct {
 	services     []testService
 	variables    map[string]interface{}
@@ -3619,6 +3938,22 @@ func jsonEqWithOrder(t *testing.T, expected, actual string) {
 	}
 }
 
+// testRequest is a downstream request received by a test service
+type testRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// argument returns the value of the i-th argument of the field, with the
+// variables of the request replaced
+func (r testRequest) argument(field *ast.Field, i int) interface{} {
+	value, err := field.Arguments[i].Value.Value(r.Variables)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
 func assertQueriesEqual(t *testing.T, schema, expected, actual string) bool {
 	t.Helper()
 	s := gqlparser.MustLoadSchema(&ast.Source{Input: schema})
@@ -13,6 +13,10 @@ import (
 	"github.com/vektah/gqlparser/v2/formatter"
 )
 
+// hoistedArgumentPrefix is the prefix of the variables holding the literal
+// argument values of downstream documents
+const hoistedArgumentPrefix = "_bramble_arg_"
+
 func indentPrefix(sb *strings.Builder, level int, suffix ...string) (int, error) {
 	sb.WriteString("\n")
 
@@ -36,42 +40,54 @@ func indentPrefix(sb *strings.Builder, level int, suffix ...string) (int, error)
 }
 
 func formatDocument(ctx context.Context, schema *ast.Schema, operationType string, selectionSet ast.SelectionSet) (string, map[string]interface{}) {
-	operation, vars := formatOperation(ctx, selectionSet)
-	return strings.ToLower(operationType) + " " + operation + formatSelectionSet(ctx, schema, selectionSet), vars
+	hoisted := &documentVariables{}
+	selectionSetQL := formatSelectionSetWithVariables(schema, hoisted, selectionSet)
+	operation, vars := formatOperation(ctx, selectionSet, hoisted)
+	return strings.ToLower(operationType) + " " + operation + selectionSetQL, vars
 }
 
-func formatOperation(ctx context.Context, selection ast.SelectionSet) (string, map[string]interface{}) {
+// formatOperation returns the operation name and variable definitions for the
+// selection set, with the values of the variables used. The hoisted
+// variables are defined after the variables of the incoming operation.
+func formatOperation(ctx context.Context, selection ast.SelectionSet, hoisted *documentVariables) (string, map[string]interface{}) {
 	sb := strings.Builder{}
 
-	if !graphql.HasOperationContext(ctx) {
-		return "", nil
-	}
-	operationCtx := graphql.GetOperationContext(ctx)
-
-	variables := selectionSetVariables(selection)
-	variableNames := map[string]struct{}{}
-	for _, s := range variables {
-		variableNames[s] = struct{}{}
-	}
-
 	var arguments []string
 	usedVariables := map[string]interface{}{}
-	for _, variableDefinition := range operationCtx.Operation.VariableDefinitions {
-		if _, exists := variableNames[variableDefinition.Variable]; !exists {
-			continue
+	if graphql.HasOperationContext(ctx) {
+		operationCtx := graphql.GetOperationContext(ctx)
+
+		variables := selectionSetVariables(selection)
+		variableNames := map[string]struct{}{}
+		for _, s := range variables {
+			variableNames[s] = struct{}{}
 		}
 
-		for varName, varValue := range operationCtx.Variables {
-			if varName == variableDefinition.Variable {
-				usedVariables[varName] = varValue
+		for _, variableDefinition := range operationCtx.Operation.VariableDefinitions {
+			if _, exists := variableNames[variableDefinition.Variable]; !exists {
+				continue
+			}
+
+			for varName, varValue := range operationCtx.Variables {
+				if varName == variableDefinition.Variable {
+					usedVariables[varName] = varValue
+				}
 			}
+
+			argument := fmt.Sprintf("$%s: %s", variableDefinition.Variable, variableDefinition.Type.String())
+			arguments = append(arguments, argument)
 		}
 
-		argument := fmt.Sprintf("$%s: %s", variableDefinition.Variable, variableDefinition.Type.String())
-		arguments = append(arguments, argument)
+		sb.WriteString(operationCtx.OperationName)
+	}
+
+	if hoisted != nil {
+		arguments = append(arguments, hoisted.definitions...)
+		for name, value := range hoisted.values {
+			usedVariables[name] = value
+		}
 	}
 
-	sb.WriteString(operationCtx.OperationName)
 	if len(arguments) == 0 {
 		return sb.String(), nil
 	}
@@ -83,6 +99,54 @@ func formatOperation(ctx context.Context, selection ast.SelectionSet) (string, m
 	return sb.String(), usedVariables
 }
 
+// documentVariables contains the variables added to a downstream document by
+// the gateway. Literal argument values are hoisted into variables so the text
+// of the document only depends on the shape of the plan step.
+type documentVariables struct {
+	definitions []string
+	values      map[string]interface{}
+	arguments   int
+}
+
+// add defines the variable and returns its reference
+func (d *documentVariables) add(name string, typ string, value interface{}) string {
+	d.definitions = append(d.definitions, fmt.Sprintf("$%s: %s", name, typ))
+	if d.values == nil {
+		d.values = map[string]interface{}{}
+	}
+	d.values[name] = value
+	return "$" + name
+}
+
+// merge adds the variables of other
+func (d *documentVariables) merge(other *documentVariables) {
+	d.definitions = append(d.definitions, other.definitions...)
+	for name, value := range other.values {
+		if d.values == nil {
+			d.values = map[string]interface{}{}
+		}
+		d.values[name] = value
+	}
+}
+
+// hoistArgument returns a variable holding the literal value. Values
+// containing variables of the incoming operation and values for which the
+// type is unknown are formatted inline.
+func (d *documentVariables) hoistArgument(schema *ast.Schema, v *ast.Value, definition *ast.ArgumentDefinition) string {
+	if d == nil || schema == nil || definition == nil || v == nil || len(valueVariables(v)) > 0 {
+		return formatArgument(schema, v, nil)
+	}
+
+	value, err := v.Value(nil)
+	if err != nil {
+		return formatArgument(schema, v, nil)
+	}
+
+	name := fmt.Sprintf("%s%d", hoistedArgumentPrefix, d.arguments)
+	d.arguments++
+	return d.add(name, definition.Type.String(), value)
+}
+
 func selectionSetVariables(selectionSet ast.SelectionSet) []string {
 	var vars []string
 	for _, s := range selectionSet {
@@ -105,6 +169,9 @@ func selectionSetVariables(selectionSet ast.SelectionSet) []string {
 func directiveListVariables(directives ast.DirectiveList) []string {
 	var output []string
 	for _, d := range directives {
+		if isIncrementalDirective(d.Name) {
+			continue
+		}
 		output = append(output, argumentListVariables(d.Arguments)...)
 	}
 
@@ -134,13 +201,13 @@ func valueVariables(a *ast.Value) []string {
 	return output
 }
 
-func formatSelectionSelectionSet(sb *strings.Builder, schema *ast.Schema, vars map[string]interface{}, level int, selectionSet ast.SelectionSet) {
+func formatSelectionSelectionSet(sb *strings.Builder, schema *ast.Schema, hoisted *documentVariables, level int, selectionSet ast.SelectionSet) {
 	sb.WriteString(" {")
-	formatSelection(sb, schema, vars, level+1, selectionSet)
+	formatSelection(sb, schema, hoisted, level+1, selectionSet)
 	indentPrefix(sb, level, "}")
 }
 
-func formatSelection(sb *strings.Builder, schema *ast.Schema, vars map[string]interface{}, level int, selectionSet ast.SelectionSet) {
+func formatSelection(sb *strings.Builder, schema *ast.Schema, hoisted *documentVariables, level int, selectionSet ast.SelectionSet) {
 	for _, selection := range selectionSet {
 		indentPrefix(sb, level)
 		switch selection := selection.(type) {
@@ -152,18 +219,30 @@ func formatSelection(sb *strings.Builder, schema *ast.Schema, vars map[string]in
 			} else {
 				sb.WriteString(selection.Alias)
 			}
-			formatArgumentList(sb, schema, vars, selection.Arguments)
+			var argumentDefinitions ast.ArgumentDefinitionList
+			if selection.Definition != nil {
+				argumentDefinitions = selection.Definition.Arguments
+			}
+			formatArgumentList(sb, schema, hoisted, argumentDefinitions, selection.Arguments)
 			for _, d := range selection.Directives {
+				// incremental delivery is handled by the gateway
+				if isIncrementalDirective(d.Name) {
+					continue
+				}
 				sb.WriteString(" @")
 				sb.WriteString(d.Name)
-				formatArgumentList(sb, schema, vars, d.Arguments)
+				var directiveArgumentDefinitions ast.ArgumentDefinitionList
+				if d.Definition != nil {
+					directiveArgumentDefinitions = d.Definition.Arguments
+				}
+				formatArgumentList(sb, schema, hoisted, directiveArgumentDefinitions, d.Arguments)
 			}
 			if len(selection.SelectionSet) > 0 {
-				formatSelectionSelectionSet(sb, schema, vars, level, selection.SelectionSet)
+				formatSelectionSelectionSet(sb, schema, hoisted, level, selection.SelectionSet)
 			}
 		case *ast.InlineFragment:
 			fmt.Fprintf(sb, "... on %v", selection.TypeCondition)
-			formatSelectionSelectionSet(sb, schema, vars, level, selection.SelectionSet)
+			formatSelectionSelectionSet(sb, schema, hoisted, level, selection.SelectionSet)
 		case *ast.FragmentSpread:
 			sb.WriteString("...")
 			sb.WriteString(selection.Name)
@@ -171,29 +250,32 @@ func formatSelection(sb *strings.Builder, schema *ast.Schema, vars map[string]in
 	}
 }
 
-func formatArgumentList(sb *strings.Builder, schema *ast.Schema, vars map[string]interface{}, args ast.ArgumentList) {
+func formatArgumentList(sb *strings.Builder, schema *ast.Schema, hoisted *documentVariables, definitions ast.ArgumentDefinitionList, args ast.ArgumentList) {
 	if len(args) > 0 {
 		sb.WriteString("(")
 		for i, arg := range args {
 			if i != 0 {
 				sb.WriteString(", ")
 			}
-			fmt.Fprintf(sb, "%s: %s", arg.Name, formatArgument(schema, arg.Value, vars))
+			fmt.Fprintf(sb, "%s: %s", arg.Name, hoisted.hoistArgument(schema, arg.Value, definitions.ForName(arg.Name)))
 		}
 		sb.WriteString(")")
 	}
 }
 
+// formatSelectionSet formats the selection set with the argument values
+// inlined
 func formatSelectionSet(ctx context.Context, schema *ast.Schema, selection ast.SelectionSet) string {
-	vars := map[string]interface{}{}
-	if reqctx := graphql.GetOperationContext(ctx); reqctx != nil {
-		vars = reqctx.Variables
-	}
+	return formatSelectionSetWithVariables(schema, nil, selection)
+}
 
+// formatSelectionSetWithVariables formats the selection set, hoisting the
+// literal argument values into variables when hoisted isn't nil
+func formatSelectionSetWithVariables(schema *ast.Schema, hoisted *documentVariables, selection ast.SelectionSet) string {
 	sb := strings.Builder{}
 
 	sb.WriteString("{")
-	formatSelection(&sb, schema, vars, 0, selection)
+	formatSelection(&sb, schema, hoisted, 0, selection)
 	sb.WriteString("\n}")
 
 	return sb.String()
@@ -396,8 +396,8 @@ func TestFormatDocument(t *testing.T) {
 		string(operationDefinition.Operation),
 		operationDefinition.SelectionSet,
 	)
-	assert.Equal(t, "query {\n  search(id: \"123\") {\n    id\n    title\n  }\n}", res)
-	assert.Equal(t, (map[string]interface{})(nil), vars)
+	assert.Equal(t, "query ($_bramble_arg_0: ID!){\n  search(id: $_bramble_arg_0) {\n    id\n    title\n  }\n}", res)
+	assert.Equal(t, map[string]interface{}{"_bramble_arg_0": "123"}, vars)
 }
 
 func TestFormatDocumentWithOperationName(t *testing.T) {
@@ -423,8 +423,8 @@ func TestFormatDocumentWithOperationName(t *testing.T) {
 		string(operationDefinition.Operation),
 		operationDefinition.SelectionSet,
 	)
-	assert.Equal(t, "query search{\n  search(id: \"123\") {\n    id\n    title\n  }\n}", res)
-	assert.Equal(t, (map[string]interface{})(nil), vars)
+	assert.Equal(t, "query search($_bramble_arg_0: ID!){\n  search(id: $_bramble_arg_0) {\n    id\n    title\n  }\n}", res)
+	assert.Equal(t, map[string]interface{}{"_bramble_arg_0": "123"}, vars)
 }
 
 func TestFormatDocumentWithVariable(t *testing.T) {
@@ -604,3 +604,52 @@ func TestFormatDocumentWithVariableWithinNestedInput(t *testing.T) {
 	assert.Equal(t, "query search($id: ID!){\n  search(filter: {sub:{id:$id}}) {\n    id\n    title\n  }\n}", res)
 	assert.Equal(t, map[string]interface{}{"id": "123"}, vars)
 }
+
+func TestFormatDocumentHoistsLiteralArguments(t *testing.T) {
+	schema := loadSchema(`
+	enum Sort {
+		ASC
+		DESC
+	}
+
+	input Filter {
+		title: String
+		years: [Int!]
+	}
+
+	type Movie {
+		id: ID!
+		title(upper: Boolean): String!
+	}
+
+	type Query {
+		search(filter: Filter, sort: Sort, limit: Int): [Movie!]!
+	}
+	`)
+
+	format := func(query string) (string, map[string]interface{}) {
+		operationDefinition := gqlparser.MustLoadQuery(schema, query).Operations[0]
+		return formatDocument(
+			testContextWithVariables(map[string]interface{}{}, operationDefinition),
+			schema,
+			string(operationDefinition.Operation),
+			operationDefinition.SelectionSet,
+		)
+	}
+
+	res, vars := format(`{
+		search(filter: {title: "alien", years: [1979, 1986]}, sort: DESC, limit: 10) { id title(upper: true) }
+	}`)
+	assert.Equal(t, "query ($_bramble_arg_0: Filter,$_bramble_arg_1: Sort,$_bramble_arg_2: Int,$_bramble_arg_3: Boolean){\n  search(filter: $_bramble_arg_0, sort: $_bramble_arg_1, limit: $_bramble_arg_2) {\n    id\n    title(upper: $_bramble_arg_3)\n  }\n}", res)
+	assert.Equal(t, map[string]interface{}{
+		"_bramble_arg_0": map[string]interface{}{"title": "alien", "years": []interface{}{int64(1979), int64(1986)}},
+		"_bramble_arg_1": "DESC",
+		"_bramble_arg_2": int64(10),
+		"_bramble_arg_3": true,
+	}, vars)
+
+	other, _ := format(`{
+		search(filter: {title: "aliens", years: [1986]}, sort: ASC, limit: 5) { id title(upper: false) }
+	}`)
+	assert.Equal(t, res, other, "the document doesn't depend on the argument values")
+}
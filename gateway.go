@@ -4,6 +4,7 @@ import (
 	"context"
 	log "log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
@@ -17,6 +18,9 @@ type Gateway struct {
 	ExecutableSchema *ExecutableSchema
 
 	plugins []Plugin
+	// draining is set once the gateway is shutting down, the readiness
+	// check fails
+	draining atomic.Bool
 }
 
 // NewGateway returns the graphql gateway server mux
@@ -47,9 +51,14 @@ func (g *Gateway) Router(cfg *Config) http.Handler {
 		plugin.SetupGatewayHandler(gatewayHandler)
 	}
 	// Duplicated from `handler.NewDefaultServer` minus
-	// the websocket transport and persisted query extension
+	// the persisted query extension, configured below
+	gatewayHandler.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+	})
 	gatewayHandler.AddTransport(transport.Options{})
 	gatewayHandler.AddTransport(transport.GET{})
+	// must be registered before POST as both accept JSON POST requests
+	gatewayHandler.AddTransport(transport.MultipartMixed{})
 	gatewayHandler.AddTransport(transport.POST{})
 	gatewayHandler.AddTransport(transport.MultipartForm{
 		MaxUploadSize: cfg.MaxFileUploadSize,
@@ -57,8 +66,16 @@ func (g *Gateway) Router(cfg *Config) http.Handler {
 	if !cfg.DisableIntrospection {
 		gatewayHandler.Use(extension.Introspection{})
 	}
+	// the trusted documents must be resolved before the automatic persisted
+	// queries
+	if cfg.trustedDocuments != nil {
+		gatewayHandler.Use(trustedDocumentsExtension{documents: cfg.trustedDocuments})
+	}
+	if cfg.PersistedQueries.Automatic && cfg.PersistedQueryStore != nil {
+		gatewayHandler.Use(extension.AutomaticPersistedQuery{Cache: cfg.PersistedQueryStore})
+	}
 
-	mux.Handle("/query", applyMiddleware(otelhttp.NewHandler(gatewayHandler, "/query"), debugMiddleware))
+	mux.Handle("/query", applyMiddleware(otelhttp.NewHandler(gatewayHandler, "/query"), debugMiddleware, cacheControlMiddleware, inFlightLimitMiddleware(cfg.MaxInFlightOperations)))
 
 	for _, plugin := range g.plugins {
 		plugin.SetupPublicMux(mux)
@@ -76,6 +93,11 @@ func (g *Gateway) Router(cfg *Config) http.Handler {
 // PrivateRouter returns the private http handler
 func (g *Gateway) PrivateRouter() http.Handler {
 	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", livenessHandler)
+	mux.HandleFunc("/readyz", g.readinessHandler)
+	mux.HandleFunc("/health", g.healthHandler)
+	mux.HandleFunc("POST /services/refresh", g.refreshHandler)
+	g.setupServiceRegistry(mux)
 
 	for _, plugin := range g.plugins {
 		plugin.SetupPrivateMux(mux)
@@ -0,0 +1,143 @@
+package bramble
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// HealthConfig configures the readiness of the gateway
+type HealthConfig struct {
+	// RequiredServices are the names or URLs of the services that must be
+	// reachable for the gateway to be ready
+	RequiredServices []string `json:"required-services"`
+}
+
+// HealthReport is the health of the gateway and its services
+type HealthReport struct {
+	// Ready is set when the gateway has a merged schema and the required
+	// services are reachable
+	Ready bool `json:"ready"`
+	// Reasons explains why the gateway is not ready
+	Reasons  []string        `json:"reasons,omitempty"`
+	Services []ServiceHealth `json:"services"`
+}
+
+// ServiceHealth is the health of a federated service at its last poll
+type ServiceHealth struct {
+	Name               string     `json:"name"`
+	URL                string     `json:"url"`
+	Source             string     `json:"source,omitempty"`
+	Version            string     `json:"version"`
+	Status             string     `json:"status"`
+	Required           bool       `json:"required"`
+	Reachable          bool       `json:"reachable"`
+	LastSuccessfulPoll *time.Time `json:"lastSuccessfulPoll,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	SchemaHash         string     `json:"schemaHash,omitempty"`
+}
+
+// publishHealth records the health of the services, it must be called with
+// the mutex held
+func (s *ExecutableSchema) publishHealth() {
+	services := make([]ServiceHealth, 0, len(s.Services))
+	for _, svc := range s.Services {
+		health := ServiceHealth{
+			Name:      svc.Name,
+			URL:       svc.ServiceURL,
+			Source:    svc.Config.Source,
+			Version:   svc.Version,
+			Status:    svc.Status,
+			Reachable: !svc.polledAt.IsZero() && svc.pollErr == nil,
+		}
+		if !svc.polledOKAt.IsZero() {
+			polledOKAt := svc.polledOKAt
+			health.LastSuccessfulPoll = &polledOKAt
+		}
+		if svc.pollErr != nil {
+			health.LastError = svc.pollErr.Error()
+		}
+		if svc.SchemaSource != "" {
+			hash := sha256.Sum256([]byte(svc.SchemaSource))
+			health.SchemaHash = hex.EncodeToString(hash[:])
+		}
+		services = append(services, health)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].URL < services[j].URL })
+	s.health.Store(&services)
+}
+
+// Health returns the health of the gateway and of its services at the last
+// schema update
+func (s *ExecutableSchema) Health() HealthReport {
+	report := HealthReport{Ready: true, Services: []ServiceHealth{}}
+	if services := s.health.Load(); services != nil {
+		report.Services = slices.Clone(*services)
+	}
+
+	if snapshot := s.snapshot.Load(); snapshot == nil || snapshot.schema == nil {
+		report.Ready = false
+		report.Reasons = append(report.Reasons, "no merged schema")
+	}
+	for _, required := range s.RequiredServices {
+		found := false
+		for i, svc := range report.Services {
+			if svc.Name != required && svc.URL != required {
+				continue
+			}
+			found = true
+			report.Services[i].Required = true
+			if !svc.Reachable {
+				report.Ready = false
+				report.Reasons = append(report.Reasons, fmt.Sprintf("required service %s is unreachable", required))
+			}
+		}
+		if !found {
+			report.Ready = false
+			report.Reasons = append(report.Reasons, fmt.Sprintf("required service %s not found", required))
+		}
+	}
+	return report
+}
+
+// livenessHandler reports that the process is alive
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// health returns the health report of the gateway, a draining gateway is
+// not ready
+func (g *Gateway) health() HealthReport {
+	report := g.ExecutableSchema.Health()
+	if g.draining.Load() {
+		report.Ready = false
+		report.Reasons = append(report.Reasons, "draining")
+	}
+	return report
+}
+
+// readinessHandler reports whether the gateway can serve operations
+func (g *Gateway) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := g.health()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, reason := range report.Reasons {
+			fmt.Fprintln(w, reason)
+		}
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// healthHandler returns the detailed health report
+func (g *Gateway) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(g.health())
+}
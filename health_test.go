@@ -0,0 +1,64 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	srv := newPolledService(t, "movies", "movies: [String!]", nil)
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(srv.URL), NewService(shows.URL))
+	es.RequiredServices = []string{"movies"}
+	router := NewGateway(es, nil).PrivateRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+	rec := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "the schema wasn't merged yet")
+	assert.Contains(t, rec.Body.String(), "no merged schema")
+
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, http.StatusOK, get("/readyz").Code)
+
+	var report HealthReport
+	require.NoError(t, json.NewDecoder(get("/health").Body).Decode(&report))
+	require.Len(t, report.Services, 2)
+	service := report.Services[slices.IndexFunc(report.Services, func(s ServiceHealth) bool { return s.URL == srv.URL })]
+	assert.True(t, report.Ready)
+	assert.Equal(t, "movies", service.Name)
+	assert.Equal(t, "OK", service.Status)
+	assert.True(t, service.Required)
+	assert.True(t, service.Reachable)
+	assert.NotNil(t, service.LastSuccessfulPoll)
+	assert.Len(t, service.SchemaHash, 64)
+
+	srv.Down.Store(true)
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	rec = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "required service movies is unreachable\n", rec.Body.String())
+
+	report = es.Health()
+	unreachable := report.Services[slices.IndexFunc(report.Services, func(s ServiceHealth) bool { return s.URL == srv.URL })]
+	assert.False(t, unreachable.Reachable)
+	assert.Equal(t, "unexpected response code: 503 Service Unavailable", unreachable.LastError)
+	assert.Equal(t, service.LastSuccessfulPoll.UnixNano(), unreachable.LastSuccessfulPoll.UnixNano())
+
+	es.RequiredServices = []string{shows.URL}
+	assert.True(t, es.Health().Ready)
+	es.RequiredServices = []string{"books"}
+	assert.Equal(t, []string{"required service books not found"}, es.Health().Reasons)
+}
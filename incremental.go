@@ -0,0 +1,620 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// DeferredFragment is a fragment marked with @defer. Its steps are executed
+// after the initial response has been sent and its data is delivered in a
+// separate incremental response.
+type DeferredFragment struct {
+	Label        string
+	Path         []string
+	ParentType   string
+	SelectionSet ast.SelectionSet
+	Steps        []*QueryPlanStep
+	Deferred     []*DeferredFragment
+
+	directive *ast.Directive
+}
+
+// MarshalJSON marshals the deferred fragment to JSON
+func (f *DeferredFragment) MarshalJSON() ([]byte, error) {
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		Variables: map[string]interface{}{},
+	})
+	return json.Marshal(&struct {
+		Label        string `json:",omitempty"`
+		Path         []string
+		ParentType   string
+		SelectionSet string
+		Steps        []*QueryPlanStep
+		Deferred     []*DeferredFragment `json:",omitempty"`
+	}{
+		Label:        f.Label,
+		Path:         f.Path,
+		ParentType:   f.ParentType,
+		SelectionSet: formatSelectionSetSingleLine(ctx, nil, f.SelectionSet),
+		Steps:        f.Steps,
+		Deferred:     f.Deferred,
+	})
+}
+
+// newDeferredFragment returns the deferred fragment for the given fragment
+// selection, or nil if the fragment is not deferred.
+// Only fragments on the root query or on boundary objects can be deferred as
+// their fields can be resolved independently, other fragments are executed
+// with the rest of the selection set.
+func newDeferredFragment(ctx *PlanningContext, insertionPoint []string, parentType, typeCondition string, directives ast.DirectiveList, selectionSet ast.SelectionSet) *DeferredFragment {
+	directive := directives.ForName(deferDirectiveName)
+	if directive == nil {
+		return nil
+	}
+	if typeCondition != "" && typeCondition != parentType {
+		return nil
+	}
+	if isRootObjectName(parentType) {
+		if parentType != queryObjectName || len(insertionPoint) > 0 {
+			return nil
+		}
+	} else if !ctx.IsBoundary[parentType] {
+		return nil
+	}
+
+	var label string
+	if arg := directive.Arguments.ForName("label"); arg != nil {
+		label = arg.Value.Raw
+	}
+
+	var path []string
+	if len(insertionPoint) > 0 {
+		path = make([]string, len(insertionPoint))
+		copy(path, insertionPoint)
+	}
+
+	return &DeferredFragment{
+		Label:        label,
+		Path:         path,
+		ParentType:   parentType,
+		SelectionSet: selectionSet,
+		directive:    directive,
+	}
+}
+
+// createDeferredSteps plans the selection set of a deferred fragment, the
+// returned steps are marked as belonging to the fragment.
+func createDeferredSteps(ctx *PlanningContext, fragment *DeferredFragment, location string) ([]*QueryPlanStep, error) {
+	steps, err := createSteps(ctx, fragment.Path, fragment.ParentType, location, fragment.SelectionSet)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		step.Defer = fragment
+	}
+	return steps, nil
+}
+
+// createRootDeferredSteps creates the root steps of a query. Deferred
+// fragments on the root selection set are planned separately.
+func createRootDeferredSteps(ctx *PlanningContext, selectionSet ast.SelectionSet) ([]*QueryPlanStep, error) {
+	var immediateSelectionSet ast.SelectionSet
+	var deferredSteps []*QueryPlanStep
+	for _, selection := range selectionSet {
+		var fragment *DeferredFragment
+		switch selection := selection.(type) {
+		case *ast.InlineFragment:
+			fragment = newDeferredFragment(ctx, nil, queryObjectName, selection.TypeCondition, selection.Directives, selection.SelectionSet)
+		case *ast.FragmentSpread:
+			fragment = newDeferredFragment(ctx, nil, queryObjectName, selection.Definition.TypeCondition, selection.Directives, selection.Definition.SelectionSet)
+		}
+		if fragment == nil {
+			immediateSelectionSet = append(immediateSelectionSet, selection)
+			continue
+		}
+
+		steps, err := createDeferredSteps(ctx, fragment, "")
+		if err != nil {
+			return nil, err
+		}
+		deferredSteps = append(deferredSteps, steps...)
+	}
+
+	steps, err := createSteps(ctx, nil, queryObjectName, "", immediateSelectionSet)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, deferredSteps...), nil
+}
+
+// splitDeferredSteps removes the steps of deferred fragments from the step
+// tree. The steps are moved to their fragment and the outermost fragments are
+// returned.
+func splitDeferredSteps(steps []*QueryPlanStep) ([]*QueryPlanStep, []*DeferredFragment) {
+	var immediate []*QueryPlanStep
+	var deferred []*DeferredFragment
+	for _, step := range steps {
+		var nested []*DeferredFragment
+		step.Then, nested = splitDeferredSteps(step.Then)
+
+		fragment := step.Defer
+		if fragment == nil {
+			immediate = append(immediate, step)
+			deferred = append(deferred, nested...)
+			continue
+		}
+
+		if len(fragment.Steps) == 0 {
+			deferred = append(deferred, fragment)
+		}
+		fragment.Steps = append(fragment.Steps, step)
+		fragment.Deferred = append(fragment.Deferred, nested...)
+	}
+	return immediate, deferred
+}
+
+// acceptsIncrementalDelivery returns whether the client accepts multipart
+// responses
+func acceptsIncrementalDelivery(headers http.Header) bool {
+	return strings.Contains(headers.Get("Accept"), "multipart/mixed")
+}
+
+func isIncrementalDirective(name string) bool {
+	return name == deferDirectiveName || name == streamDirectiveName
+}
+
+// evaluateIncrementalDirectives removes the @defer and @stream directives
+// that are disabled, either through their "if" argument or because the
+// operation is not delivered incrementally. The selection set is modified in
+// place and must be a copy of the cached operation.
+func evaluateIncrementalDirectives(vars map[string]interface{}, selectionSet ast.SelectionSet, enabled bool) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			selection.Directives = removeDisabledIncrementalDirectives(vars, selection.Directives, enabled)
+			evaluateIncrementalDirectives(vars, selection.SelectionSet, enabled)
+		case *ast.InlineFragment:
+			selection.Directives = removeDisabledIncrementalDirectives(vars, selection.Directives, enabled)
+			evaluateIncrementalDirectives(vars, selection.SelectionSet, enabled)
+		case *ast.FragmentSpread:
+			selection.Directives = removeDisabledIncrementalDirectives(vars, selection.Directives, enabled)
+			evaluateIncrementalDirectives(vars, selection.Definition.SelectionSet, enabled)
+		}
+	}
+}
+
+func removeDisabledIncrementalDirectives(vars map[string]interface{}, directives ast.DirectiveList, enabled bool) ast.DirectiveList {
+	var result ast.DirectiveList
+	for _, d := range directives {
+		if isIncrementalDirective(d.Name) && (!enabled || !incrementalDirectiveEnabled(d, vars)) {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// incrementalDirectiveEnabled resolves the "if" argument of @defer and
+// @stream, it defaults to true
+func incrementalDirectiveEnabled(d *ast.Directive, vars map[string]interface{}) bool {
+	if d.Arguments.ForName("if") == nil {
+		return true
+	}
+	return resolveIfArgument(d, vars)
+}
+
+// deferredDirectives returns the @defer directives of the deferred fragments,
+// they are used to identify the fragments in the operation selection set.
+func deferredDirectives(fragments []*DeferredFragment, result map[*ast.Directive]bool) map[*ast.Directive]bool {
+	if result == nil {
+		result = make(map[*ast.Directive]bool)
+	}
+	for _, fragment := range fragments {
+		result[fragment.directive] = true
+		deferredDirectives(fragment.Deferred, result)
+	}
+	return result
+}
+
+// removeDeferredFragments returns a copy of the selection set without the
+// deferred fragments
+func removeDeferredFragments(selectionSet ast.SelectionSet, deferred map[*ast.Directive]bool) ast.SelectionSet {
+	if selectionSet == nil {
+		return nil
+	}
+	isDeferred := func(directives ast.DirectiveList) bool {
+		d := directives.ForName(deferDirectiveName)
+		return d != nil && deferred[d]
+	}
+
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.SelectionSet = removeDeferredFragments(selection.SelectionSet, deferred)
+			result = append(result, &field)
+		case *ast.InlineFragment:
+			if isDeferred(selection.Directives) {
+				continue
+			}
+			fragment := *selection
+			fragment.SelectionSet = removeDeferredFragments(selection.SelectionSet, deferred)
+			result = append(result, &fragment)
+		case *ast.FragmentSpread:
+			if isDeferred(selection.Directives) {
+				continue
+			}
+			definition := *selection.Definition
+			definition.SelectionSet = removeDeferredFragments(selection.Definition.SelectionSet, deferred)
+			fragment := *selection
+			fragment.Definition = &definition
+			result = append(result, &fragment)
+		}
+	}
+	return result
+}
+
+// streamedList is a list field marked with @stream
+type streamedList struct {
+	parent       map[string]interface{}
+	field        *ast.Field
+	items        []interface{}
+	initialCount int
+	label        string
+	path         ast.Path
+}
+
+// collectStreamedLists returns the lists selected with @stream that have
+// more items than their initial count
+func collectStreamedLists(vars map[string]interface{}, selectionSet ast.SelectionSet, data interface{}, path ast.Path) []streamedList {
+	var result []streamedList
+	switch data := data.(type) {
+	case map[string]interface{}:
+		for _, field := range selectionSetToFields(selectionSet) {
+			value, ok := data[field.Alias]
+			if !ok || value == nil {
+				continue
+			}
+			fieldPath := append(append(ast.Path{}, path...), ast.PathName(field.Alias))
+
+			if d := field.Directives.ForName(streamDirectiveName); d != nil {
+				if items, ok := value.([]interface{}); ok {
+					initialCount := streamInitialCount(d, vars)
+					if initialCount < len(items) {
+						var label string
+						if arg := d.Arguments.ForName("label"); arg != nil {
+							label = arg.Value.Raw
+						}
+						result = append(result, streamedList{
+							parent:       data,
+							field:        field,
+							items:        items,
+							initialCount: initialCount,
+							label:        label,
+							path:         fieldPath,
+						})
+					}
+				}
+			}
+
+			if len(field.SelectionSet) > 0 {
+				result = append(result, collectStreamedLists(vars, field.SelectionSet, value, fieldPath)...)
+			}
+		}
+	case []interface{}:
+		for i, value := range data {
+			result = append(result, collectStreamedLists(vars, selectionSet, value, appendPathIndex(path, i))...)
+		}
+	}
+	return result
+}
+
+func streamInitialCount(d *ast.Directive, vars map[string]interface{}) int {
+	arg := d.Arguments.ForName("initialCount")
+	if arg == nil {
+		return 0
+	}
+	value, err := arg.Value.Value(vars)
+	if err != nil {
+		return 0
+	}
+
+	var count int64
+	switch value := value.(type) {
+	case int64:
+		count = value
+	case int:
+		count = int64(value)
+	case float64:
+		count = int64(value)
+	case json.Number:
+		count, _ = value.Int64()
+	}
+	if count < 0 {
+		return 0
+	}
+	return int(count)
+}
+
+// deferredTarget is an object the data of a deferred fragment is delivered
+// for
+type deferredTarget struct {
+	path ast.Path
+	data map[string]interface{}
+}
+
+// deferredFragmentTargets returns the objects located at the fragment path
+func deferredFragmentTargets(fragment *DeferredFragment, data interface{}, path []string, currentPath ast.Path) []deferredTarget {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		if len(path) == 0 {
+			if !isRootObjectName(fragment.ParentType) && extractAndCastTypenameField(data) != fragment.ParentType {
+				return nil
+			}
+			return []deferredTarget{{path: currentPath, data: data}}
+		}
+		return deferredFragmentTargets(fragment, data[path[0]], path[1:], append(append(ast.Path{}, currentPath...), ast.PathName(path[0])))
+	case []interface{}:
+		var result []deferredTarget
+		for i, value := range data {
+			result = append(result, deferredFragmentTargets(fragment, value, path, appendPathIndex(currentPath, i))...)
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// incrementalDelivery delivers the deferred fragments and streamed list
+// items of a query once the initial response has been sent.
+// The deferred fragments are executed concurrently, their results are merged
+// into the data of the initial response by the response handler.
+type incrementalDelivery struct {
+	executableSchema *ExecutableSchema
+	ctx              context.Context
+	operationName    string
+	rawQuery         string
+	variables        map[string]interface{}
+	schema           *ast.Schema
+	boundaryFields   BoundaryFieldsMap
+	services         map[string]*Service
+	entityCache      *entityCache
+	maxRequest       int32
+	requestCount     int32
+
+	data     map[string]interface{}
+	deferred map[*ast.Directive]bool
+
+	ready     []*graphql.Response
+	running   int
+	completed chan deferredFragmentResult
+	// done is called once everything has been delivered
+	done func()
+}
+
+type deferredFragmentResult struct {
+	fragment *DeferredFragment
+	targets  []deferredTarget
+	results  []executionResult
+	errs     gqlerror.List
+}
+
+// formatInitialResponse formats the data of the initial response. The
+// deferred fragments are left out and the streamed lists are truncated to
+// their initial count, the remaining items are queued as incremental
+// responses.
+func (d *incrementalDelivery) formatInitialResponse(selectionSet ast.SelectionSet) []byte {
+	selectionSet = removeDeferredFragments(selectionSet, d.deferred)
+	streams := collectStreamedLists(d.variables, selectionSet, d.data, ast.Path{})
+	for _, s := range streams {
+		s.parent[s.field.Alias] = s.items[:s.initialCount]
+	}
+
+	formatted := formatResponseData(d.schema, selectionSet, d.data)
+	if len(d.data) == 0 {
+		// every root field is deferred
+		formatted = []byte("{}")
+	}
+
+	for _, s := range streams {
+		for i := s.initialCount; i < len(s.items); i++ {
+			d.ready = append(d.ready, &graphql.Response{
+				Data:  d.formatStreamedItem(s.field, s.items[i]),
+				Label: s.label,
+				Path:  appendPathIndex(s.path, i),
+			})
+		}
+	}
+
+	for _, s := range streams {
+		s.parent[s.field.Alias] = s.items
+	}
+	return formatted
+}
+
+func (d *incrementalDelivery) formatStreamedItem(field *ast.Field, item interface{}) []byte {
+	if len(field.SelectionSet) > 0 {
+		return formatResponseDataRec(d.schema, field.SelectionSet, item, false)
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return []byte("null")
+	}
+	return data
+}
+
+func (d *incrementalDelivery) newExecution() *queryExecution {
+	qe := newQueryExecution(d.ctx, d.operationName, d.executableSchema.GraphqlClient, d.schema, d.boundaryFields, d.maxRequest)
+	qe.requestCount = d.requestCount
+	qe.entityCache = d.entityCache
+	qe.services = d.services
+	qe.batching = d.executableSchema.boundaryBatching()
+	return qe
+}
+
+// start executes the deferred fragments that have at least one object to
+// deliver data for
+func (d *incrementalDelivery) start(fragments []*DeferredFragment) {
+	for _, fragment := range fragments {
+		targets := deferredFragmentTargets(fragment, d.data, fragment.Path, ast.Path{})
+		if len(targets) == 0 {
+			continue
+		}
+
+		// boundary ids are extracted before starting the execution as the
+		// data is modified when other fragments complete
+		qe := d.newExecution()
+		results, startErrs := qe.startDeferredFragment(fragment, d.data)
+
+		d.running++
+		fragment := fragment
+		go func() {
+			results, errs := qe.collectResults(results)
+			select {
+			case d.completed <- deferredFragmentResult{
+				fragment: fragment,
+				targets:  targets,
+				results:  results,
+				errs:     append(startErrs, errs...),
+			}:
+			case <-d.ctx.Done():
+			}
+		}()
+	}
+}
+
+// complete merges the results of a deferred fragment and returns the
+// incremental responses for the fragment
+func (d *incrementalDelivery) complete(result deferredFragmentResult) []*graphql.Response {
+	errs := result.errs
+	for _, r := range result.results {
+		if err := mergeExecutionResultsRec(r.Data, d.data, r.InsertionPoint); err != nil {
+			errs = append(errs, &gqlerror.Error{Message: err.Error()})
+		}
+		errs = append(errs, r.Errors...)
+	}
+
+	// nested fragments depend on the data of the fragment
+	d.start(result.fragment.Deferred)
+
+	selectionSet := removeDeferredFragments(result.fragment.SelectionSet, d.deferred)
+	var responses []*graphql.Response
+	for i, target := range result.targets {
+		response := &graphql.Response{
+			Label: result.fragment.Label,
+			Path:  target.path,
+		}
+		if i == 0 {
+			response.Errors = errs
+		}
+
+		bubbleErrs, bubbleUp, err := bubbleUpNullValuesInPlaceRec(d.schema, nil, selectionSet, target.data, append(ast.Path{}, target.path...))
+		switch {
+		case err != nil:
+			response.Errors = append(response.Errors, &gqlerror.Error{Message: err.Error()})
+			response.Data = []byte("null")
+		case bubbleUp:
+			response.Data = []byte("null")
+		default:
+			response.Data = formatResponseDataRec(d.schema, selectionSet, target.data, false)
+		}
+		response.Errors = append(response.Errors, bubbleErrs...)
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func (d *incrementalDelivery) hasNext() bool {
+	return len(d.ready) > 0 || d.running > 0
+}
+
+// next returns the next incremental response, or nil once everything has been
+// delivered
+func (d *incrementalDelivery) next(ctx context.Context) *graphql.Response {
+	for len(d.ready) == 0 {
+		if d.running == 0 {
+			d.done()
+			return nil
+		}
+		select {
+		case result := <-d.completed:
+			d.running--
+			d.ready = append(d.ready, d.complete(result)...)
+		case <-ctx.Done():
+			d.done()
+			return nil
+		}
+	}
+
+	response := d.ready[0]
+	d.ready = d.ready[1:]
+	hasNext := d.hasNext()
+	response.HasNext = &hasNext
+	if !hasNext {
+		d.done()
+	}
+	return d.executableSchema.interceptResponse(ctx, d.operationName, d.rawQuery, d.variables, response)
+}
+
+// startDeferredFragment starts the steps of a deferred fragment, the boundary
+// ids are extracted from the data merged so far
+func (q *queryExecution) startDeferredFragment(fragment *DeferredFragment, data map[string]interface{}) ([]executionResult, gqlerror.List) {
+	results := []executionResult{}
+	for _, step := range fragment.Steps {
+		if step.ServiceURL == internalServiceName {
+			r, err := q.executeInternalStep(step)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, *r)
+			continue
+		}
+
+		step := step
+		if isRootObjectName(step.ParentType) {
+			q.group.Go(func() error {
+				return q.executeRootStep(step)
+			})
+			continue
+		}
+
+		boundaryIDs, err := extractAndDedupeBoundaryIDs(data, step.InsertionPoint, step.ParentType)
+		if err != nil {
+			return results, gqlerror.List{gqlerror.Wrap(err)}
+		}
+		if len(boundaryIDs) == 0 {
+			continue
+		}
+		q.group.Go(func() error {
+			return q.executeChildStep(step, boundaryIDs)
+		})
+	}
+	return results, nil
+}
+
+// ExecuteIncrementalQuery returns a response handler delivering the query
+// incrementally: the initial response is followed by one response per
+// deferred fragment target and per streamed list item.
+func (s *ExecutableSchema) ExecuteIncrementalQuery(ctx context.Context) graphql.ResponseHandler {
+	var delivery *incrementalDelivery
+	started := false
+	return func(ctx context.Context) *graphql.Response {
+		if !started {
+			started = true
+			var response *graphql.Response
+			response, delivery = s.executeQuery(ctx, true)
+			return response
+		}
+		if delivery == nil {
+			return nil
+		}
+		return delivery.next(ctx)
+	}
+}
@@ -0,0 +1,197 @@
+package bramble
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const incrementalMovieServiceSchema = `
+directive @boundary on OBJECT | FIELD_DEFINITION
+
+type Service {
+	name: String!
+	version: String!
+	schema: String!
+}
+
+type Movie @boundary {
+	id: ID!
+	title: String!
+}
+
+type Query {
+	service: Service!
+	movies: [Movie!]!
+	movie(id: ID!): Movie @boundary
+}`
+
+func newIncrementalMovieService(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if strings.Contains(req.Query, "service") {
+			writeServiceSchema(w, "movie", incrementalMovieServiceSchema)
+			return
+		}
+		assert.NotContains(t, req.Query, "@stream")
+		w.Write([]byte(`{"data": {"movies": [
+			{"title": "Jurassic Park", "_bramble_id": "1", "_bramble__typename": "Movie"},
+			{"title": "Alien", "_bramble_id": "2", "_bramble__typename": "Movie"}
+		]}}`))
+	}))
+}
+
+var boundaryLookupRegexp = regexp.MustCompile(`(_\d+): movie\(id: \$(\w+)\)`)
+
+// newIncrementalReleaseService returns a service resolving the release of
+// movies, the lookups wait for the unblock channel to be closed
+func newIncrementalReleaseService(t *testing.T, unblock <-chan struct{}) *httptest.Server {
+	releases := map[string]int{"1": 1993, "2": 1979}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if strings.Contains(req.Query, "service") {
+			writeServiceSchema(w, "release", subscriptionReleaseServiceSchema)
+			return
+		}
+		<-unblock
+
+		var results []string
+		for _, match := range boundaryLookupRegexp.FindAllStringSubmatch(req.Query, -1) {
+			id, _ := req.Variables[match[2]].(string)
+			results = append(results, fmt.Sprintf(`%q: {"_bramble_id": %q, "_bramble__typename": "Movie", "release": %d}`, match[1], id, releases[id]))
+		}
+		fmt.Fprintf(w, `{"data": {%s}}`, strings.Join(results, ","))
+	}))
+}
+
+func postIncrementalQuery(t *testing.T, gatewayURL, query string) *http.Response {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req, err := http.NewRequest(http.MethodPost, gatewayURL+"/query", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "multipart/mixed; deferSpec=20220824, application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func readMultipartPayload(t *testing.T, reader *multipart.Reader) map[string]json.RawMessage {
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	data, err := io.ReadAll(part)
+	require.NoError(t, err)
+	var payload map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &payload))
+	return payload
+}
+
+// readIncrementalResults reads the remaining parts and returns the
+// incremental results, without their hasNext field
+func readIncrementalResults(t *testing.T, reader *multipart.Reader) []string {
+	var results []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return results
+		}
+		require.NoError(t, err)
+		var payload struct {
+			Incremental []map[string]json.RawMessage
+		}
+		require.NoError(t, json.NewDecoder(part).Decode(&payload))
+		for _, result := range payload.Incremental {
+			delete(result, "hasNext")
+			b, _ := json.Marshal(result)
+			results = append(results, string(b))
+		}
+	}
+}
+
+func newMultipartReader(t *testing.T, resp *http.Response) *multipart.Reader {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+	return multipart.NewReader(resp.Body, params["boundary"])
+}
+
+func TestGatewayDeferredFragment(t *testing.T) {
+	unblock := make(chan struct{})
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	releaseService := newIncrementalReleaseService(t, unblock)
+	defer releaseService.Close()
+
+	gateway := newTestGateway(t, movieService, releaseService)
+	defer gateway.Close()
+
+	resp := postIncrementalQuery(t, gateway.URL, `{ movies { title ... @defer(label: "release") { release } } }`)
+	defer resp.Body.Close()
+	reader := newMultipartReader(t, resp)
+
+	// the initial payload is sent while the release service is blocked
+	initial := readMultipartPayload(t, reader)
+	assert.JSONEq(t, `{"movies": [{"title": "Jurassic Park"}, {"title": "Alien"}]}`, string(initial["data"]))
+	assert.JSONEq(t, `true`, string(initial["hasNext"]))
+	close(unblock)
+
+	results := readIncrementalResults(t, reader)
+	require.Len(t, results, 2)
+	assert.ElementsMatch(t, []string{
+		`{"data":{"release":1993},"label":"release","path":["movies",0]}`,
+		`{"data":{"release":1979},"label":"release","path":["movies",1]}`,
+	}, results)
+}
+
+func TestGatewayStreamedList(t *testing.T) {
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+
+	gateway := newTestGateway(t, movieService)
+	defer gateway.Close()
+
+	resp := postIncrementalQuery(t, gateway.URL, `{ movies @stream(initialCount: 1, label: "movies") { title } }`)
+	defer resp.Body.Close()
+	reader := newMultipartReader(t, resp)
+
+	initial := readMultipartPayload(t, reader)
+	assert.JSONEq(t, `{"movies": [{"title": "Jurassic Park"}]}`, string(initial["data"]))
+
+	assert.Equal(t, []string{
+		`{"data":{"title":"Alien"},"label":"movies","path":["movies",1]}`,
+	}, readIncrementalResults(t, reader))
+}
+
+func TestGatewayDeferWithoutIncrementalDelivery(t *testing.T) {
+	unblock := make(chan struct{})
+	close(unblock)
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	releaseService := newIncrementalReleaseService(t, unblock)
+	defer releaseService.Close()
+
+	gateway := newTestGateway(t, movieService, releaseService)
+	defer gateway.Close()
+
+	body, _ := json.Marshal(map[string]string{"query": `{ movies { title ... @defer { release } } }`})
+	resp, err := http.Post(gateway.URL+"/query", "application/json", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var response struct {
+		Data json.RawMessage
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.JSONEq(t, `{"movies": [{"title": "Jurassic Park", "release": 1993}, {"title": "Alien", "release": 1979}]}`, string(response.Data))
+}
@@ -3,6 +3,8 @@ package bramble
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"time"
 
 	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
@@ -20,26 +22,96 @@ type Service struct {
 	SchemaSource string
 	Schema       *ast.Schema
 	Status       string
+	// Config contains the settings of the service
+	Config ServiceConfig
+	// Quarantined is set when the last schema of the service is invalid or
+	// can't be merged, the previous schema of the service is still used
+	Quarantined bool
+	// QuarantineError is the error that quarantined the service
+	QuarantineError string
+	// Stale is set when the service was unreachable on startup and its
+	// persisted schema is used until it can be polled
+	Stale bool
 
-	tracer trace.Tracer
-	client *GraphQLClient
+	tracer   trace.Tracer
+	client   *GraphQLClient
+	polledAt time.Time
+	pollErr  error
+	// polledOKAt is the time of the last successful poll
+	polledOKAt time.Time
+	// pollDelay is the delay between the last poll and the next one
+	pollDelay time.Duration
+	// pollFailures is the number of consecutive failed polls
+	pollFailures int
+	bulkhead     *bulkhead
+	// pending is the new schema of the service, applied once merged
+	pending *serviceSchema
+}
+
+// serviceSchema is a schema returned by a service
+type serviceSchema struct {
+	version string
+	source  string
+	schema  *ast.Schema
 }
 
 // NewService returns a new Service.
 func NewService(serviceURL string, opts ...ClientOpt) *Service {
+	return NewServiceFromConfig(ServiceConfig{URL: serviceURL}, opts...)
+}
+
+// NewServiceFromConfig returns a new Service using the given settings to poll
+// its schema and execute queries.
+func NewServiceFromConfig(config ServiceConfig, opts ...ClientOpt) *Service {
 	opts = append(opts, WithUserAgent(GenerateUserAgent("update")))
 	s := &Service{
-		ServiceURL: serviceURL,
+		ServiceURL: config.URL,
+		Name:       config.Name,
+		Config:     config,
 		tracer:     otel.GetTracerProvider().Tracer(instrumentationName),
 		client:     NewClientWithoutKeepAlive(opts...),
 	}
+	if config.Bulkhead != nil && config.Bulkhead.MaxInFlight > 0 {
+		s.bulkhead = newBulkhead(config.URL, *config.Bulkhead)
+	}
 	return s
 }
 
-// Update queries the service's schema, name and version and updates its status.
+// pollDue returns whether the poll delay of the service elapsed since its
+// last update. Polls are triggered by a ticker, a tenth of the delay is
+// tolerated so a service is not skipped because a tick came early.
+func (s *Service) pollDue(now time.Time) bool {
+	return now.Sub(s.polledAt) >= s.pollDelay*9/10
+}
+
+// schedulePoll sets the delay before the next poll. Services are polled at
+// their poll interval, unreachable services with an exponential backoff and
+// jitter up to their max poll backoff.
+func (s *Service) schedulePoll(err error) {
+	interval := s.Config.PollIntervalDuration
+	if err == nil {
+		s.pollFailures = 0
+		s.pollDelay = interval
+		return
+	}
+
+	s.pollFailures++
+	maxDelay := max(s.Config.maxPollBackoff(), interval)
+	delay := interval
+	for i := 1; i < s.pollFailures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	s.pollDelay = delay/2 + rand.N(delay/2+1)
+}
+
+// Update queries the service's schema, name and version and updates its
+// status. A new schema is pending until it is merged. If the new schema is
+// invalid and the service has a previous schema, the service is quarantined
+// and keeps its previous schema.
 func (s *Service) Update(ctx context.Context) (bool, error) {
-	req := NewRequest("query brambleServicePoll { service { name, version, schema} }").
-		WithOperationName("brambleServicePoll")
+	req := s.Config.apply(NewRequest("query brambleServicePoll { service { name, version, schema} }").
+		WithOperationName("brambleServicePoll"))
 
 	ctx, span := s.tracer.Start(ctx, "Federated Service Schema Update",
 		trace.WithSpanKind(trace.SpanKindInternal),
@@ -61,30 +133,125 @@ func (s *Service) Update(ctx context.Context) (bool, error) {
 		} `json:"service"`
 	}{}
 
-	if err := s.client.Request(ctx, s.ServiceURL, req, &response); err != nil {
-		s.SchemaSource = ""
-		s.Status = "Unreachable"
-		return false, err
+	s.pending = nil
+	// the schema source is cleared when the service is unreachable, its
+	// schema is then fetched to merge the service back in
+	if s.Config.versionCheck() && s.Schema != nil && s.Version != "" && s.SchemaSource != "" {
+		version, err := s.pollVersion(ctx)
+		if err != nil {
+			return false, s.unreachable(err)
+		}
+		if version == s.Version {
+			// the schema in use is current, it is not fetched
+			s.Stale = false
+			s.releaseQuarantine()
+			return false, nil
+		}
 	}
 
-	updated := response.Service.Schema != s.SchemaSource
+	if err := s.client.Request(ctx, s.ServiceURL, req, &response); err != nil {
+		return false, s.unreachable(err)
+	}
+	s.Stale = false
 
 	s.Name = response.Service.Name
-	s.Version = response.Service.Version
-	s.SchemaSource = response.Service.Schema
+	if s.Config.Name != "" {
+		s.Name = s.Config.Name
+	}
 
-	schema, err := gqlparser.LoadSchema(&ast.Source{Name: s.ServiceURL, Input: response.Service.Schema})
-	if err != nil {
-		s.Status = "Schema error"
-		return false, err
+	if response.Service.Schema == s.SchemaSource && s.Schema != nil {
+		// the service rolled back to the schema in use
+		s.Version = response.Service.Version
+		s.releaseQuarantine()
+		return false, nil
 	}
-	s.Schema = schema
 
-	if err := ValidateSchema(s.Schema); err != nil {
-		s.Status = fmt.Sprintf("Invalid (%s)", err)
-		return updated, err
+	schema, gqlErr := gqlparser.LoadSchema(&ast.Source{Name: s.ServiceURL, Input: response.Service.Schema})
+	if gqlErr != nil {
+		return false, s.reject(response.Service.Schema, "Schema error", gqlErr)
+	}
+	if err := ValidateSchema(schema); err != nil {
+		return false, s.reject(response.Service.Schema, fmt.Sprintf("Invalid (%s)", err), err)
+	}
+
+	s.pending = &serviceSchema{
+		version: response.Service.Version,
+		source:  response.Service.Schema,
+		schema:  schema,
+	}
+	return true, nil
+}
+
+// poll updates the service and records the result of the poll
+func (s *Service) poll(ctx context.Context, now time.Time) error {
+	_, err := s.Update(ctx)
+	s.polledAt, s.pollErr = now, err
+	s.schedulePoll(err)
+	if err == nil {
+		s.polledOKAt = now
 	}
+	return err
+}
+
+// pollVersion queries the version of the service, without its schema
+func (s *Service) pollVersion(ctx context.Context) (string, error) {
+	req := s.Config.apply(NewRequest("query brambleServiceVersion { service { version } }").
+		WithOperationName("brambleServiceVersion"))
+
+	response := struct {
+		Service struct {
+			Version string `json:"version"`
+		} `json:"service"`
+	}{}
+	if err := s.client.Request(ctx, s.ServiceURL, req, &response); err != nil {
+		return "", err
+	}
+	return response.Service.Version, nil
+}
+
+// unreachable handles a failed poll, stale services keep their persisted
+// schema
+func (s *Service) unreachable(err error) error {
+	if !s.Stale {
+		s.SchemaSource = ""
+		s.Status = "Unreachable"
+	}
+	return err
+}
+
+// reject handles an invalid schema. Services with a previous schema are
+// quarantined, others can't be used.
+func (s *Service) reject(source, status string, err error) error {
+	if s.Schema != nil {
+		s.quarantine(err)
+		return nil
+	}
+	s.SchemaSource = source
+	s.Status = status
+	return err
+}
+
+// applyPending uses the pending schema of the service
+func (s *Service) applyPending() {
+	s.Version = s.pending.version
+	s.SchemaSource = s.pending.source
+	s.Schema = s.pending.schema
+	s.pending = nil
+	s.releaseQuarantine()
+}
+
+// quarantine keeps the previous schema of the service
+func (s *Service) quarantine(err error) {
+	s.pending = nil
+	s.Quarantined = true
+	s.QuarantineError = err.Error()
+	s.Status = "Quarantined"
+	promServiceQuarantinedGauge.WithLabelValues(s.ServiceURL).Set(1)
+}
 
+func (s *Service) releaseQuarantine() {
+	s.Quarantined = false
+	s.QuarantineError = ""
 	s.Status = "OK"
-	return updated, nil
+	promServiceQuarantinedGauge.WithLabelValues(s.ServiceURL).Set(0)
 }
@@ -0,0 +1,169 @@
+package bramble
+
+import (
+	"fmt"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const queryLimitExceededCode = "QUERY_LIMIT_EXCEEDED"
+
+// QueryLimits bounds the shape of incoming operations, a limit set to 0 is
+// not enforced.
+type QueryLimits struct {
+	// MaxDepth is the maximum number of nested fields
+	MaxDepth int `json:"max-depth"`
+	// MaxAliases is the maximum number of aliased fields
+	MaxAliases int `json:"max-aliases"`
+	// MaxBreadth is the maximum number of fields in a selection set, fields
+	// from fragments included
+	MaxBreadth int `json:"max-breadth"`
+	// MaxRootFields is the maximum number of root fields
+	MaxRootFields int `json:"max-root-fields"`
+	// MaxFragmentSpreads is the maximum number of fragment spreads once all
+	// fragments are expanded
+	MaxFragmentSpreads int `json:"max-fragment-spreads"`
+}
+
+// QueryLimitsConfig contains the default query limits and the limits
+// specific to some roles.
+type QueryLimitsConfig struct {
+	QueryLimits
+	// Roles contains the limits for each role, a role listed here doesn't
+	// use the default limits.
+	Roles map[string]QueryLimits `json:"roles"`
+}
+
+// ForRole returns the limits applying to the given role
+func (c QueryLimitsConfig) ForRole(role string) QueryLimits {
+	if limits, ok := c.Roles[role]; ok {
+		return limits
+	}
+	return c.QueryLimits
+}
+
+func (c QueryLimitsConfig) validate() error {
+	if err := c.QueryLimits.validate(); err != nil {
+		return err
+	}
+	for role, limits := range c.Roles {
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("role %q: %w", role, err)
+		}
+	}
+	return nil
+}
+
+func (l QueryLimits) validate() error {
+	if l.MaxDepth < 0 || l.MaxAliases < 0 || l.MaxBreadth < 0 || l.MaxRootFields < 0 || l.MaxFragmentSpreads < 0 {
+		return fmt.Errorf("query limits must not be negative")
+	}
+	return nil
+}
+
+// Check returns an error for every limit exceeded by the operation. Skip and
+// include directives are not evaluated, every selection counts.
+func (l QueryLimits) Check(op *ast.OperationDefinition) gqlerror.List {
+	c := queryLimitsCheck{limits: l, errs: map[string]*gqlerror.Error{}}
+	c.checkSelectionSet(op.SelectionSet, 1)
+	if l.MaxRootFields > 0 && c.rootFields.count > l.MaxRootFields {
+		c.fail("root-fields", c.rootFields.position, "operation has %d root fields, exceeding the maximum of %d", c.rootFields.count, l.MaxRootFields)
+	}
+
+	var errs gqlerror.List
+	for _, limit := range []string{"depth", "aliases", "breadth", "root-fields", "fragment-spreads"} {
+		if err, ok := c.errs[limit]; ok {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// queryLimitsCheck walks an operation and keeps track of the first violation
+// of every limit
+type queryLimitsCheck struct {
+	limits          QueryLimits
+	aliases         int
+	fragmentSpreads int
+	rootFields      fieldCount
+	errs            map[string]*gqlerror.Error
+}
+
+// fieldCount counts the fields of a selection set and records the position
+// of the first field over the limit
+type fieldCount struct {
+	count    int
+	position *ast.Position
+}
+
+func (f *fieldCount) add(field *ast.Field, limit int) {
+	f.count++
+	if f.count == limit+1 {
+		f.position = field.Position
+	}
+}
+
+func (c *queryLimitsCheck) checkSelectionSet(selectionSet ast.SelectionSet, depth int) {
+	var breadth fieldCount
+	c.checkFields(selectionSet, depth, &breadth)
+	if c.limits.MaxBreadth > 0 && breadth.count > c.limits.MaxBreadth {
+		c.fail("breadth", breadth.position, "selection set has %d fields, exceeding the maximum of %d", breadth.count, c.limits.MaxBreadth)
+	}
+}
+
+// checkFields checks the fields of the selection set, the fields of
+// fragments count towards the breadth of the enclosing selection set
+func (c *queryLimitsCheck) checkFields(selectionSet ast.SelectionSet, depth int, breadth *fieldCount) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			breadth.add(selection, c.limits.MaxBreadth)
+			if depth == 1 {
+				c.rootFields.add(selection, c.limits.MaxRootFields)
+			}
+			if selection.Alias != "" && selection.Alias != selection.Name {
+				c.aliases++
+				if c.limits.MaxAliases > 0 && c.aliases == c.limits.MaxAliases+1 {
+					c.fail("aliases", selection.Position, "operation has more than %d aliases", c.limits.MaxAliases)
+				}
+			}
+			if len(selection.SelectionSet) == 0 {
+				continue
+			}
+			if c.limits.MaxDepth > 0 && depth+1 > c.limits.MaxDepth {
+				c.fail("depth", selection.SelectionSet[0].GetPosition(), "operation exceeds the maximum depth of %d", c.limits.MaxDepth)
+				continue
+			}
+			c.checkSelectionSet(selection.SelectionSet, depth+1)
+		case *ast.InlineFragment:
+			c.checkFields(selection.SelectionSet, depth, breadth)
+		case *ast.FragmentSpread:
+			c.fragmentSpreads++
+			if c.limits.MaxFragmentSpreads > 0 && c.fragmentSpreads > c.limits.MaxFragmentSpreads {
+				// stop expanding fragments, the expansion can grow
+				// exponentially with the size of the document
+				c.fail("fragment-spreads", selection.Position, "operation has more than %d fragment spreads", c.limits.MaxFragmentSpreads)
+				continue
+			}
+			if selection.Definition != nil {
+				c.checkFields(selection.Definition.SelectionSet, depth, breadth)
+			}
+		}
+	}
+}
+
+// fail records the error for the limit, only the first violation of every
+// limit is reported
+func (c *queryLimitsCheck) fail(limit string, position *ast.Position, format string, args ...interface{}) {
+	if _, ok := c.errs[limit]; ok {
+		return
+	}
+	err := gqlerror.ErrorPosf(position, format, args...)
+	err.Extensions = map[string]interface{}{
+		"code":  queryLimitExceededCode,
+		"limit": limit,
+	}
+	c.errs[limit] = err
+	promQueryLimitExceededCounter.WithLabelValues(limit).Inc()
+}
@@ -0,0 +1,145 @@
+package bramble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const limitsSchema = `
+type Movie {
+	id: ID!
+	title: String!
+	sequel: Movie
+}
+
+type Query {
+	movie(id: ID!): Movie
+	movies: [Movie!]!
+}`
+
+func TestQueryLimits(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: limitsSchema})
+
+	for _, tc := range []struct {
+		name   string
+		limits QueryLimits
+		query  string
+		errs   []string
+	}{
+		{
+			name:   "within limits",
+			limits: QueryLimits{MaxDepth: 3, MaxAliases: 1, MaxBreadth: 2, MaxRootFields: 1, MaxFragmentSpreads: 1},
+			query:  `{ movie(id: "1") { ...MovieFragment first: sequel { id } } } fragment MovieFragment on Movie { title }`,
+		},
+		{
+			name:   "depth",
+			limits: QueryLimits{MaxDepth: 2},
+			query: `{
+				movie(id: "1") {
+					sequel {
+						id
+					}
+				}
+			}`,
+			errs: []string{`4:7: operation exceeds the maximum depth of 2 (depth)`},
+		},
+		{
+			name:   "aliases",
+			limits: QueryLimits{MaxAliases: 1},
+			query:  `{ a: movie(id: "1") { id } b: movie(id: "2") { id } c: movie(id: "3") { id } }`,
+			errs:   []string{`1:28: operation has more than 1 aliases (aliases)`},
+		},
+		{
+			name:   "breadth includes fragments",
+			limits: QueryLimits{MaxBreadth: 2},
+			query:  `{ movies { id ... on Movie { title sequel { id } } } }`,
+			errs:   []string{`1:36: selection set has 3 fields, exceeding the maximum of 2 (breadth)`},
+		},
+		{
+			name:   "root fields",
+			limits: QueryLimits{MaxRootFields: 1},
+			query:  `{ movies { id } movie(id: "1") { id } }`,
+			errs:   []string{`1:17: operation has 2 root fields, exceeding the maximum of 1 (root-fields)`},
+		},
+		{
+			name:   "fragment spreads are counted once expanded",
+			limits: QueryLimits{MaxFragmentSpreads: 3},
+			query: `
+			{ movies { ...A ...A } }
+			fragment A on Movie { ...B ...B }
+			fragment B on Movie { id }`,
+			errs: []string{`2:23: operation has more than 3 fragment spreads (fragment-spreads)`},
+		},
+		{
+			name:   "multiple limits",
+			limits: QueryLimits{MaxDepth: 1, MaxRootFields: 1},
+			query:  `{ movies { id } movie(id: "1") { id } }`,
+			errs: []string{
+				`1:12: operation exceeds the maximum depth of 1 (depth)`,
+				`1:17: operation has 2 root fields, exceeding the maximum of 1 (root-fields)`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			query := gqlparser.MustLoadQuery(schema, tc.query)
+			var errs []string
+			for _, err := range tc.limits.Check(query.Operations[0]) {
+				require.Equal(t, queryLimitExceededCode, err.Extensions["code"])
+				errs = append(errs, formatLimitError(err))
+			}
+			assert.Equal(t, tc.errs, errs)
+		})
+	}
+}
+
+func formatLimitError(err *gqlerror.Error) string {
+	return fmt.Sprintf("%d:%d: %s (%s)", err.Locations[0].Line, err.Locations[0].Column, err.Message, err.Extensions["limit"])
+}
+
+func TestQueryLimitsForRole(t *testing.T) {
+	config := QueryLimitsConfig{
+		QueryLimits: QueryLimits{MaxDepth: 2},
+		Roles: map[string]QueryLimits{
+			"admin": {},
+		},
+	}
+	assert.Equal(t, QueryLimits{MaxDepth: 2}, config.ForRole(""))
+	assert.Equal(t, QueryLimits{MaxDepth: 2}, config.ForRole("public_role"))
+	assert.Equal(t, QueryLimits{}, config.ForRole("admin"))
+}
+
+func TestQueryLimitsExceededNeverPlanned(t *testing.T) {
+	schema, err := MergeSchemas(gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: limitsSchema}))
+	require.NoError(t, err)
+
+	es := ExecutableSchema{
+		tracer:       noop.NewTracerProvider().Tracer("test"),
+		MergedSchema: schema,
+		QueryLimits: QueryLimitsConfig{
+			QueryLimits: QueryLimits{MaxDepth: 1},
+			Roles: map[string]QueryLimits{
+				"admin": {MaxDepth: 3},
+			},
+		},
+	}
+
+	// no service owns the fields, planning would fail
+	query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movie(id: "1") { sequel { id } } }`)
+	violations := testutil.ToFloat64(promQueryLimitExceededCounter.WithLabelValues("depth"))
+	resp := es.ExecuteQuery(testContextWithoutVariables(query.Operations[0]))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "operation exceeds the maximum depth of 1", resp.Errors[0].Message)
+	assert.Equal(t, violations+1, testutil.ToFloat64(promQueryLimitExceededCounter.WithLabelValues("depth")))
+
+	resp = es.ExecuteQuery(AddRoleToContext(testContextWithoutVariables(query.Operations[0]), "admin"))
+	require.Len(t, resp.Errors, 1)
+	assert.NotEqual(t, queryLimitExceededCode, resp.Errors[0].Extensions["code"])
+}
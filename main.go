@@ -8,8 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
-	"time"
+	"syscall"
 )
 
 // Main runs the gateway. This function is exported so that it can be reused
@@ -57,48 +56,41 @@ func Main() {
 	gtw := NewGateway(cfg.executableSchema, cfg.plugins)
 	RegisterMetrics()
 
-	go gtw.UpdateSchemas(cfg.PollIntervalDuration)
+	go gtw.UpdateSchemas(cfg.schemaPollInterval())
 
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	var wg sync.WaitGroup
-	wg.Add(3)
-
-	go runHandler(ctx, &wg, "metrics", cfg.MetricAddress(), cfg.DefaultTimeouts, NewMetricsHandler())
-	go runHandler(ctx, &wg, "private", cfg.PrivateAddress(), cfg.PrivateTimeouts, gtw.PrivateRouter())
-	go runHandler(ctx, &wg, "public", cfg.GatewayAddress(), cfg.GatewayTimeouts, gtw.Router(cfg))
+	servers := []*http.Server{
+		newServer(cfg.MetricAddress(), cfg.DefaultTimeouts, NewMetricsHandler()),
+		newServer(cfg.PrivateAddress(), cfg.PrivateTimeouts, gtw.PrivateRouter()),
+		newServer(cfg.GatewayAddress(), cfg.GatewayTimeouts, gtw.Router(cfg)),
+	}
+	go serve("metrics", servers[0])
+	go serve("private", servers[1])
+	go serve("public", servers[2])
 
-	wg.Wait()
+	<-ctx.Done()
+	// stop capturing the signals so that a second one force-quits the drain
+	cancel()
+	gtw.Drain(cfg.Drain, servers...)
 }
 
-func runHandler(ctx context.Context, wg *sync.WaitGroup, name, addr string, timeouts TimeoutConfig, handler http.Handler) {
-	srv := &http.Server{
+func newServer(addr string, timeouts TimeoutConfig, handler http.Handler) *http.Server {
+	return &http.Server{
 		Addr:         addr,
 		Handler:      handler,
 		ReadTimeout:  timeouts.ReadTimeoutDuration,
 		WriteTimeout: timeouts.WriteTimeoutDuration,
 		IdleTimeout:  timeouts.IdleTimeoutDuration,
 	}
+}
 
-	go func() {
-		log.With("addr", addr).Info(fmt.Sprintf("serving %s handler", name))
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.With("error", err).Error("server terminated unexpectedly")
-			os.Exit(1)
-		}
-	}()
-
-	<-ctx.Done()
-
-	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	log.Info(fmt.Sprintf("shutting down %s handler", name))
-	err := srv.Shutdown(timeoutCtx)
-	if err != nil {
-		log.With("error", err).Error("failed shutting down server")
+func serve(name string, srv *http.Server) {
+	log.With("addr", srv.Addr).Info(fmt.Sprintf("serving %s handler", name))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.With("error", err).Error("server terminated unexpectedly")
+		os.Exit(1)
 	}
-	log.Info(fmt.Sprintf("shut down %s handler", name))
-	wg.Done()
+	log.Info(fmt.Sprintf("stopped serving %s handler", name))
 }
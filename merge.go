@@ -7,6 +7,14 @@ import (
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
+// streamDirectiveDefinition is added to the merged schema as the GraphQL
+// prelude only defines @defer
+var streamDirectiveDefinition = gqlparser.MustLoadSchema(&ast.Source{
+	Name:    "stream directive",
+	BuiltIn: true,
+	Input:   `directive @stream(if: Boolean = true, label: String, initialCount: Int = 0) on FIELD`,
+}).Directives[streamDirectiveName]
+
 // MergeSchemas merges the provided schemas together
 func MergeSchemas(schemas ...*ast.Schema) (*ast.Schema, error) {
 	if len(schemas) < 1 {
@@ -47,6 +55,9 @@ func MergeSchemas(schemas ...*ast.Schema) (*ast.Schema, error) {
 	merged.Implements = mergeImplements(schemas)
 	merged.PossibleTypes = mergePossibleTypes(schemas, merged.Types)
 	merged.Directives = mergeDirectives(schemas)
+	if _, ok := merged.Directives[streamDirectiveName]; !ok {
+		merged.Directives[streamDirectiveName] = streamDirectiveDefinition
+	}
 
 	merged.Query = merged.Types[queryObjectName]
 	merged.Mutation = merged.Types[mutationObjectName]
@@ -152,13 +163,15 @@ func mergeTypes(a, b map[string]*ast.Definition) (map[string]*ast.Definition, er
 			return nil, fmt.Errorf("name collision: %s(%s) conflicts with %s(%s)", newVB.Name, newVB.Kind, va.Name, va.Kind)
 		}
 
-		if newVB.Kind == ast.Scalar {
+		// the cache control scope enum is declared by every service using
+		// @cacheControl
+		if newVB.Kind == ast.Scalar || k == cacheControlScopeTypeName {
 			result[k] = &newVB
 			continue
 		}
 
 		if !hasFederationDirectives(&newVB) || !hasFederationDirectives(va) {
-			if k != queryObjectName && k != mutationObjectName {
+			if !isRootObjectName(k) {
 				if newVB.Kind == ast.Interface {
 					return nil, fmt.Errorf("conflicting interface: %s (interfaces may not span multiple services)", k)
 				}
@@ -170,13 +183,13 @@ func mergeTypes(a, b map[string]*ast.Definition) (map[string]*ast.Definition, er
 			return nil, fmt.Errorf("conflicting object directives, merged objects %q should both be boundary or namespaces", newVB.Name)
 		}
 
-		// now, either it's boundary type, namespace type or the Query/Mutation type
+		// now, either it's boundary type, namespace type or a root operation type
 
 		if va.Kind != ast.Object {
 			return nil, fmt.Errorf("non object boundary type")
 		}
 
-		if isNamespaceObject(&newVB) || k == queryObjectName || k == mutationObjectName || k == subscriptionObjectName {
+		if isNamespaceObject(&newVB) || isRootObjectName(k) {
 			mergedObject, err := mergeNamespaceObjects(a, b, &newVB, va)
 			if err != nil {
 				return nil, err
@@ -294,12 +307,26 @@ func mergeBoundaryObjects(a, b *ast.Definition) (*ast.Definition, error) {
 		Kind:        ast.Object,
 		Description: mergeDescriptions(a, b),
 		Name:        a.Name,
-		Directives:  a.Directives.ForNames(boundaryDirectiveName),
+		Directives:  mergeBoundaryObjectDirectives(a, b),
 		Interfaces:  append(a.Interfaces, b.Interfaces...),
 		Fields:      mergedFields,
 	}, nil
 }
 
+// mergeBoundaryObjectDirectives keeps the boundary directive and the first
+// @cost and @cacheControl directives found on the merged objects
+func mergeBoundaryObjectDirectives(a, b *ast.Definition) ast.DirectiveList {
+	directives := a.Directives.ForNames(boundaryDirectiveName)
+	for _, name := range []string{costDirectiveName, cacheControlDirectiveName} {
+		if d := a.Directives.ForName(name); d != nil {
+			directives = append(directives, d)
+		} else {
+			directives = append(directives, b.Directives.ForNames(name)...)
+		}
+	}
+	return directives
+}
+
 func mergeBoundaryObjectFields(a, b *ast.Definition) (ast.FieldList, error) {
 	var result ast.FieldList
 	for _, f := range a.Fields {
@@ -375,8 +402,10 @@ func cleanFields(fields ast.FieldList) ast.FieldList {
 			continue
 		}
 
-		f.Directives = cleanDirectives(f.Directives)
-		res = append(res, f)
+		// the field is copied as the source schema can be in use
+		field := *f
+		field.Directives = cleanDirectives(f.Directives)
+		res = append(res, &field)
 	}
 
 	return res
@@ -384,7 +413,7 @@ func cleanFields(fields ast.FieldList) ast.FieldList {
 
 func allowedDirective(name string) bool {
 	switch name {
-	case boundaryDirectiveName, namespaceDirectiveName, "skip", "include", "deprecated":
+	case boundaryDirectiveName, namespaceDirectiveName, deferDirectiveName, streamDirectiveName, costDirectiveName, listSizeDirectiveName, cacheControlDirectiveName, "skip", "include", "deprecated":
 		return true
 	default:
 		return false
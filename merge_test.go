@@ -210,6 +210,116 @@ func TestMergeTwoSchemasWithBoundaryTypes(t *testing.T) {
 	fixture.CheckSuccess(t)
 }
 
+func TestMergeKeepsCostDirectives(t *testing.T) {
+	fixture := MergeTestFixture{
+		Input1: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+			directive @cost(weight: Int!) on FIELD_DEFINITION | OBJECT
+			directive @listSize(assumedSize: Int, slicingArguments: [String!]) on FIELD_DEFINITION
+
+			type Gizmo @boundary @cost(weight: 2) {
+				id: ID!
+				name: String! @cost(weight: 3)
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo!
+				gizmos(first: Int): [Gizmo!]! @listSize(slicingArguments: ["first"])
+			}
+		`,
+		Input2: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+
+			type Gizmo @boundary {
+				id: ID!
+				size: Float!
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo! @boundary
+			}
+		`,
+		Expected: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+			directive @cost(weight: Int!) on FIELD_DEFINITION | OBJECT
+			directive @listSize(assumedSize: Int, slicingArguments: [String!]) on FIELD_DEFINITION
+
+			type Gizmo @boundary @cost(weight: 2) {
+				id: ID!
+				size: Float!
+				name: String! @cost(weight: 3)
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo!
+				gizmos(first: Int): [Gizmo!]! @listSize(slicingArguments: ["first"])
+			}
+		`,
+	}
+	fixture.CheckSuccess(t)
+}
+
+func TestMergeKeepsCacheControlDirectives(t *testing.T) {
+	fixture := MergeTestFixture{
+		Input1: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+			directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+
+			enum CacheControlScope {
+				PUBLIC
+				PRIVATE
+			}
+
+			type Gizmo @boundary @cacheControl(maxAge: 60) {
+				id: ID!
+				name: String! @cacheControl(maxAge: 30)
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo!
+			}
+		`,
+		Input2: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+			directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+
+			enum CacheControlScope {
+				PUBLIC
+				PRIVATE
+			}
+
+			type Gizmo @boundary @cacheControl(maxAge: 10) {
+				id: ID!
+				owner: String! @cacheControl(scope: PRIVATE)
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo! @boundary
+			}
+		`,
+		Expected: `
+			directive @boundary on OBJECT | FIELD_DEFINITION
+			directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+
+			enum CacheControlScope {
+				PUBLIC
+				PRIVATE
+			}
+
+			type Gizmo @boundary @cacheControl(maxAge: 10) {
+				id: ID!
+				owner: String! @cacheControl(scope: PRIVATE)
+				name: String! @cacheControl(maxAge: 30)
+			}
+
+			type Query {
+				gizmo(id: ID!): Gizmo!
+			}
+		`,
+	}
+	fixture.CheckSuccess(t)
+}
+
 func TestMergeBoundaryAndNamespace(t *testing.T) {
 	fixture := MergeTestFixture{
 		Input1: `
@@ -656,6 +766,36 @@ func TestHandlesMutationServices(t *testing.T) {
 	fixture.CheckSuccess(t)
 }
 
+func TestHandlesSubscriptionServices(t *testing.T) {
+	fixture := MergeTestFixture{
+		Input1: `
+		type Query {
+				gizmo: ID!
+		}
+		type Subscription {
+				gizmoAdded: ID!
+		}`,
+		Input2: `
+		type Query {
+				widget: ID!
+		}
+		type Subscription {
+				widgetAdded: ID!
+		}`,
+		Expected: `
+			type Query {
+				widget: ID!
+				gizmo: ID!
+			}
+			type Subscription {
+				widgetAdded: ID!
+				gizmoAdded: ID!
+			}
+		`,
+	}
+	fixture.CheckSuccess(t)
+}
+
 func TestMergeHandlesUnionConflict(t *testing.T) {
 	fixture := MergeTestFixture{
 		Input1: `
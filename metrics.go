@@ -35,6 +35,52 @@ var (
 		},
 	)
 
+	promServiceRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_request_retries_total",
+			Help: "A counter indicating how many times requests to services have been retried",
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promCircuitBreakerStateGauge is the state of the circuit breaker of each
+	// service: 0 closed, 1 half-open, 2 open
+	promCircuitBreakerStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "A gauge indicating the state of the circuit breaker of the services: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promServiceDiscoveredGauge indicates the services found by each
+	// discovery source
+	promServiceDiscoveredGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_discovered",
+			Help: "A gauge indicating what services were found by each discovery source",
+		},
+		[]string{
+			"service",
+			"source",
+		},
+	)
+
+	// promServiceDiscoveryErrorCounter counts the failed service discoveries
+	promServiceDiscoveryErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_discovery_error_total",
+			Help: "A counter indicating how many times service discoveries have failed",
+		},
+		[]string{
+			"source",
+		},
+	)
+
 	promServiceUpdateErrorGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "service_update_error",
@@ -45,12 +91,139 @@ var (
 		},
 	)
 
+	// promServiceQuarantinedGauge indicates the services whose last schema
+	// was rejected
+	promServiceQuarantinedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_quarantined",
+			Help: "A gauge indicating what services are using their previous schema because the last one was rejected",
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promSchemaChangesCounter counts the changes applied to the merged
+	// schema by severity: breaking, dangerous or safe
+	promSchemaChangesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "schema_changes_total",
+			Help: "A counter indicating how many changes were applied to the merged schema, by severity",
+		},
+		[]string{
+			"severity",
+		},
+	)
+
+	// promQueryLimitExceededCounter counts the operations rejected for
+	// exceeding a query limit
+	promQueryLimitExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "query_limit_exceeded_total",
+			Help: "A counter indicating how many times operations have exceeded a query limit",
+		},
+		[]string{
+			"limit",
+		},
+	)
+
+	// promPlanCacheCounter counts the plan cache hits and misses
+	promPlanCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "plan_cache_requests_total",
+			Help: "A counter indicating how many query plans were found in the plan cache",
+		},
+		[]string{
+			"result",
+		},
+	)
+
+	// promEntityCacheCounter counts the entity cache hits and misses
+	promEntityCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "entity_cache_requests_total",
+			Help: "A counter indicating how many boundary entities were found in the entity cache",
+		},
+		[]string{
+			"result",
+		},
+	)
+
+	// promBoundaryStepIDsHistogram tracks the number of ids looked up per
+	// boundary step
+	promBoundaryStepIDsHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "boundary_step_ids",
+			Help:    "A histogram of the number of ids looked up by a boundary step",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promBoundaryStepDocumentsHistogram tracks the number of requests sent
+	// per boundary step
+	promBoundaryStepDocumentsHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "boundary_step_documents",
+			Help:    "A histogram of the number of requests sent by a boundary step",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promResponseCacheCounter counts the response cache hits and misses
+	promResponseCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "response_cache_requests_total",
+			Help: "A counter indicating how many responses were found in the response cache",
+		},
+		[]string{
+			"result",
+		},
+	)
+
 	// promHTTPInFlightGauge is a gauge of requests currently being served by the wrapped handler
 	promHTTPInFlightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "http_in_flight_requests",
 		Help: "A gauge of requests currently being served",
 	})
 
+	// promHTTPRejectedCounter counts the requests rejected because too many
+	// operations were in flight
+	promHTTPRejectedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_rejected_requests_total",
+		Help: "A counter for requests rejected because too many operations were in flight",
+	})
+
+	// promServiceQueueGauge is a gauge of requests waiting for a slot in the
+	// bulkhead of a service
+	promServiceQueueGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "service_request_queue_depth",
+			Help: "A gauge of requests waiting to be sent to a service",
+		},
+		[]string{
+			"service",
+		},
+	)
+
+	// promServiceRejectionCounter counts the requests rejected by the
+	// bulkhead of a service
+	promServiceRejectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "service_rejected_requests_total",
+			Help: "A counter for requests to a service rejected by its bulkhead",
+		},
+		[]string{
+			"service",
+			"reason",
+		},
+	)
+
 	// promHTTPRequestCounter is a counter for requests to the wrapped handler
 	promHTTPRequestCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -95,9 +268,24 @@ var (
 func RegisterMetrics() {
 	prometheus.MustRegister(promInvalidSchema)
 	prometheus.MustRegister(promServiceTimeoutErrorCounter)
+	prometheus.MustRegister(promServiceRetryCounter)
+	prometheus.MustRegister(promCircuitBreakerStateGauge)
 	prometheus.MustRegister(promServiceUpdateErrorCounter)
 	prometheus.MustRegister(promServiceUpdateErrorGauge)
+	prometheus.MustRegister(promServiceQuarantinedGauge)
+	prometheus.MustRegister(promServiceDiscoveredGauge)
+	prometheus.MustRegister(promServiceDiscoveryErrorCounter)
+	prometheus.MustRegister(promSchemaChangesCounter)
+	prometheus.MustRegister(promQueryLimitExceededCounter)
+	prometheus.MustRegister(promPlanCacheCounter)
+	prometheus.MustRegister(promResponseCacheCounter)
+	prometheus.MustRegister(promEntityCacheCounter)
+	prometheus.MustRegister(promBoundaryStepIDsHistogram)
+	prometheus.MustRegister(promBoundaryStepDocumentsHistogram)
 	prometheus.MustRegister(promHTTPInFlightGauge)
+	prometheus.MustRegister(promHTTPRejectedCounter)
+	prometheus.MustRegister(promServiceQueueGauge)
+	prometheus.MustRegister(promServiceRejectionCounter)
 	prometheus.MustRegister(promHTTPRequestCounter)
 	prometheus.MustRegister(promHTTPResponseDurations)
 	prometheus.MustRegister(promHTTPRequestSizes)
@@ -11,8 +11,10 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/felixge/httpsnoop"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 type middleware func(http.Handler) http.Handler
@@ -64,6 +66,9 @@ func debugMiddleware(h http.Handler) http.Handler {
 
 func monitoringMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		promHTTPInFlightGauge.Inc()
+		defer promHTTPInFlightGauge.Dec()
+
 		ctx, event := startEvent(r.Context(), nameMonitoringEvent)
 		if !strings.HasPrefix(r.Header.Get("user-agent"), "Bramble") {
 			defer event.finish()
@@ -107,6 +112,36 @@ func monitoringMiddleware(h http.Handler) http.Handler {
 	})
 }
 
+// inFlightLimitMiddleware rejects requests with a 503 while the maximum
+// number of operations are in flight, 0 disables the limit. Websocket
+// connections are not limited.
+func inFlightLimitMiddleware(limit int) middleware {
+	slots := make(chan struct{}, limit)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit <= 0 || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				h.ServeHTTP(w, r)
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				h.ServeHTTP(w, r)
+			default:
+				promHTTPRejectedCounter.Inc()
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(graphql.Response{
+					Errors: gqlerror.List{{
+						Message:    "too many operations in flight",
+						Extensions: map[string]interface{}{"code": serviceOverloadedCode},
+					}},
+				})
+			}
+		})
+	}
+}
+
 func nameMonitoringEvent(fields EventFields) string {
 	if t := fields["operation.type"]; t != nil {
 		if n := fields["operation.name"]; n != "" {
@@ -0,0 +1,213 @@
+package bramble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	defaultPersistedQueryCacheSize = 1000
+
+	operationNotTrustedCode = "OPERATION_NOT_TRUSTED"
+)
+
+// PersistedQueriesConfig configures the automatic persisted queries and the
+// trusted documents.
+type PersistedQueriesConfig struct {
+	// Automatic enables automatic persisted queries
+	Automatic bool `json:"automatic"`
+	// CacheSize is the number of queries kept by the default in memory
+	// store, defaults to 1000
+	CacheSize int `json:"cache-size"`
+	// TrustedDocuments is the path to the trusted documents manifest
+	TrustedDocuments string `json:"trusted-documents"`
+	// Strict rejects every operation not in the trusted documents manifest
+	Strict bool `json:"strict"`
+	// AdHocRoles are the roles allowed to send operations not in the
+	// trusted documents manifest in strict mode
+	AdHocRoles []string `json:"ad-hoc-roles"`
+	// Downstream sends the hash of the downstream documents instead of
+	// their text, services must support automatic persisted queries
+	Downstream bool `json:"downstream"`
+}
+
+// PersistedQueryStore stores the queries sent by clients using automatic
+// persisted queries, indexed by their sha256 hash.
+type PersistedQueryStore interface {
+	Get(ctx context.Context, hash string) (string, bool)
+	Add(ctx context.Context, hash string, query string)
+}
+
+// NewLRUPersistedQueryStore returns an in memory store keeping the given
+// number of most recently used queries.
+func NewLRUPersistedQueryStore(size int) PersistedQueryStore {
+	return lru.New[string](size)
+}
+
+// TrustedDocuments contains the operations loaded from a trusted documents
+// manifest. The manifest is either an Apollo persisted query manifest or a
+// JSON object mapping document ids to documents.
+type TrustedDocuments struct {
+	mutex      sync.RWMutex
+	documents  map[string]string
+	hashes     map[string]bool
+	strict     bool
+	adHocRoles map[string]bool
+}
+
+// NewTrustedDocuments loads the trusted documents manifest according to the
+// config.
+func NewTrustedDocuments(config PersistedQueriesConfig) (*TrustedDocuments, error) {
+	t := &TrustedDocuments{}
+	if err := t.Reload(config); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reloads the manifest and the strict mode settings. The current
+// documents are kept if the manifest can't be loaded.
+func (t *TrustedDocuments) Reload(config PersistedQueriesConfig) error {
+	documents := map[string]string{}
+	if config.TrustedDocuments != "" {
+		var err error
+		documents, err = loadTrustedDocumentsManifest(config.TrustedDocuments)
+		if err != nil {
+			return err
+		}
+	}
+
+	hashes := make(map[string]bool, len(documents))
+	for _, document := range documents {
+		hashes[queryHash(document)] = true
+	}
+	adHocRoles := make(map[string]bool, len(config.AdHocRoles))
+	for _, role := range config.AdHocRoles {
+		adHocRoles[role] = true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.documents = documents
+	t.hashes = hashes
+	t.strict = config.Strict
+	t.adHocRoles = adHocRoles
+	return nil
+}
+
+func loadTrustedDocumentsManifest(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trusted documents manifest: %w", err)
+	}
+
+	var manifest struct {
+		Operations []struct {
+			ID   string `json:"id"`
+			Body string `json:"body"`
+		} `json:"operations"`
+	}
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.Operations != nil {
+		documents := make(map[string]string, len(manifest.Operations))
+		for _, op := range manifest.Operations {
+			documents[op.ID] = op.Body
+		}
+		return documents, nil
+	}
+
+	var documents map[string]string
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return nil, fmt.Errorf("error decoding trusted documents manifest %q: %w", path, err)
+	}
+	return documents, nil
+}
+
+// Get returns the document with the given id
+func (t *TrustedDocuments) Get(id string) (string, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	document, ok := t.documents[id]
+	return document, ok
+}
+
+// IsTrusted returns whether the query is one of the trusted documents
+func (t *TrustedDocuments) IsTrusted(query string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.hashes[queryHash(query)]
+}
+
+// IsStrict returns whether the operations of the role must be trusted
+// documents
+func (t *TrustedDocuments) IsStrict(role string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.strict && !t.adHocRoles[role]
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = trustedDocumentsExtension{}
+
+// trustedDocumentsExtension resolves the persisted queries from the trusted
+// documents and rejects the other operations in strict mode. It must be
+// registered before the automatic persisted query extension.
+type trustedDocumentsExtension struct {
+	documents *TrustedDocuments
+}
+
+func (e trustedDocumentsExtension) ExtensionName() string {
+	return "TrustedDocuments"
+}
+
+func (e trustedDocumentsExtension) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e trustedDocumentsExtension) MutateOperationParameters(ctx context.Context, rawParams *graphql.RawParams) *gqlerror.Error {
+	role, _ := GetRoleFromContext(ctx)
+	strict := e.documents.IsStrict(role)
+
+	if rawParams.Query == "" {
+		id := persistedQueryHash(rawParams.Extensions)
+		if document, ok := e.documents.Get(id); ok {
+			rawParams.Query = document
+			// the document is resolved, the ids of the manifest are not
+			// necessarily sha256 hashes
+			delete(rawParams.Extensions, "persistedQuery")
+			return nil
+		}
+		if !strict {
+			return nil
+		}
+	} else if !strict || e.documents.IsTrusted(rawParams.Query) {
+		return nil
+	}
+
+	err := gqlerror.Errorf("operation is not a trusted document")
+	errcode.Set(err, operationNotTrustedCode)
+	return err
+}
+
+// persistedQueryHash returns the hash of the persisted query extension
+func persistedQueryHash(extensions map[string]interface{}) string {
+	persistedQuery, _ := extensions["persistedQuery"].(map[string]interface{})
+	hash, _ := persistedQuery["sha256Hash"].(string)
+	return hash
+}
+
+func queryHash(query string) string {
+	b := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(b[:])
+}
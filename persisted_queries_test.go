@@ -0,0 +1,176 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const persistedQuery = `{ __type(name: "Query") { name } }`
+
+func newPersistedQueriesGateway(t *testing.T, cfg *Config) http.Handler {
+	schema, err := MergeSchemas(gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: `type Query { test: String }`}))
+	require.NoError(t, err)
+	es := NewExecutableSchema(nil, 50, nil)
+	es.MergedSchema = schema
+	return NewGateway(es, nil).Router(cfg)
+}
+
+func writeTrustedDocumentsManifest(t *testing.T, manifest string) string {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, os.WriteFile(path, []byte(manifest), 0o644))
+	return path
+}
+
+// postPersistedQuery sends the query and/or the persisted query hash and
+// returns the response data and the code of the first error
+func postPersistedQuery(t *testing.T, handler http.Handler, query, hash, role string) (string, string) {
+	params := map[string]interface{}{}
+	if query != "" {
+		params["query"] = query
+	}
+	if hash != "" {
+		params["extensions"] = map[string]interface{}{
+			"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+		}
+	}
+	body, _ := json.Marshal(params)
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	if role != "" {
+		req = req.WithContext(AddRoleToContext(req.Context(), role))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var resp struct {
+		Data   json.RawMessage
+		Errors []struct {
+			Extensions map[string]interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	if len(resp.Errors) > 0 {
+		code, _ := resp.Errors[0].Extensions["code"].(string)
+		return string(resp.Data), code
+	}
+	return string(resp.Data), ""
+}
+
+func TestAutomaticPersistedQueries(t *testing.T) {
+	cfg := &Config{
+		PersistedQueries:    PersistedQueriesConfig{Automatic: true},
+		PersistedQueryStore: NewLRUPersistedQueryStore(10),
+	}
+	handler := newPersistedQueriesGateway(t, cfg)
+	hash := queryHash(persistedQuery)
+
+	_, code := postPersistedQuery(t, handler, "", hash, "")
+	assert.Equal(t, "PERSISTED_QUERY_NOT_FOUND", code)
+
+	data, code := postPersistedQuery(t, handler, persistedQuery, hash, "")
+	assert.Empty(t, code)
+	assert.JSONEq(t, `{"__type": {"name": "Query"}}`, data)
+
+	data, code = postPersistedQuery(t, handler, "", hash, "")
+	assert.Empty(t, code)
+	assert.JSONEq(t, `{"__type": {"name": "Query"}}`, data)
+}
+
+func TestTrustedDocuments(t *testing.T) {
+	manifest := writeTrustedDocumentsManifest(t, `{
+		"format": "apollo-persisted-query-manifest",
+		"version": 1,
+		"operations": [{"id": "query-id", "name": "Test", "type": "query", "body": "{ __type(name: \"Query\") { name } }"}]
+	}`)
+	documents, err := NewTrustedDocuments(PersistedQueriesConfig{
+		TrustedDocuments: manifest,
+		Strict:           true,
+		AdHocRoles:       []string{"internal"},
+	})
+	require.NoError(t, err)
+	handler := newPersistedQueriesGateway(t, &Config{trustedDocuments: documents})
+
+	t.Run("document id", func(t *testing.T) {
+		data, code := postPersistedQuery(t, handler, "", "query-id", "")
+		assert.Empty(t, code)
+		assert.JSONEq(t, `{"__type": {"name": "Query"}}`, data)
+	})
+
+	t.Run("trusted document text", func(t *testing.T) {
+		_, code := postPersistedQuery(t, handler, persistedQuery, "", "")
+		assert.Empty(t, code)
+	})
+
+	t.Run("untrusted document", func(t *testing.T) {
+		_, code := postPersistedQuery(t, handler, `{ __typename }`, "", "")
+		assert.Equal(t, operationNotTrustedCode, code)
+		_, code = postPersistedQuery(t, handler, "", "unknown-id", "")
+		assert.Equal(t, operationNotTrustedCode, code)
+	})
+
+	t.Run("ad hoc role", func(t *testing.T) {
+		_, code := postPersistedQuery(t, handler, `{ __typename }`, "", "internal")
+		assert.Empty(t, code)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(manifest, []byte(`{"other-id": "{ __typename }"}`), 0o644))
+		require.NoError(t, documents.Reload(PersistedQueriesConfig{TrustedDocuments: manifest, Strict: true}))
+
+		_, code := postPersistedQuery(t, handler, `{ __typename }`, "", "")
+		assert.Empty(t, code)
+		_, code = postPersistedQuery(t, handler, "", "query-id", "")
+		assert.Equal(t, operationNotTrustedCode, code)
+		_, code = postPersistedQuery(t, handler, persistedQuery, "", "internal")
+		assert.Equal(t, operationNotTrustedCode, code)
+	})
+}
+
+func TestTrustedDocumentsInvalidManifest(t *testing.T) {
+	_, err := NewTrustedDocuments(PersistedQueriesConfig{TrustedDocuments: writeTrustedDocumentsManifest(t, `[]`)})
+	assert.Error(t, err)
+
+	documents, err := NewTrustedDocuments(PersistedQueriesConfig{})
+	require.NoError(t, err)
+	assert.Error(t, documents.Reload(PersistedQueriesConfig{TrustedDocuments: "does-not-exist.json"}))
+}
+
+func TestReloadWithInvalidManifest(t *testing.T) {
+	movies := newPolledService(t, "movies", "movies: [String!]", nil)
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+	manifest := writeTrustedDocumentsManifest(t, `{"query-id": "{ __typename }"}`)
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(manifest string, services ...string) {
+		config, _ := json.Marshal(map[string]interface{}{
+			"services":          services,
+			"persisted-queries": map[string]interface{}{"trusted-documents": manifest},
+		})
+		require.NoError(t, os.WriteFile(configFile, config, 0o644))
+	}
+
+	writeConfig(manifest, movies.URL)
+	cfg, err := GetConfig([]string{configFile})
+	require.NoError(t, err)
+	defer cfg.watcher.Close()
+	cfg.trustedDocuments, err = NewTrustedDocuments(cfg.PersistedQueries)
+	require.NoError(t, err)
+	cfg.executableSchema = NewExecutableSchema(nil, 50, nil)
+	require.NoError(t, cfg.executableSchema.UpdateServices(context.Background(), cfg.ServiceConfigs))
+
+	writeConfig("does-not-exist.json", movies.URL, shows.URL)
+	assert.ErrorContains(t, cfg.reload(), "failed reloading trusted documents")
+	assert.Contains(t, cfg.executableSchema.Services, shows.URL, "the services are updated")
+	_, ok := cfg.trustedDocuments.Get("query-id")
+	assert.True(t, ok, "the previous trusted documents are kept")
+}
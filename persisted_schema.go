@@ -0,0 +1,154 @@
+package bramble
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	log "log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// SchemaSnapshotFormatVersion is the version of the persisted schema snapshot
+// format
+const SchemaSnapshotFormatVersion = 1
+
+// PersistedSchemaSnapshot contains the schemas of the services of the last
+// merged schema. The gateway writes it after every schema update and uses it
+// on startup for the services that are unreachable. It can also be produced
+// offline and shipped with the gateway.
+type PersistedSchemaSnapshot struct {
+	Version  int                      `json:"version"`
+	Services []PersistedServiceSchema `json:"services"`
+}
+
+// PersistedServiceSchema is the schema of a service in a persisted snapshot
+type PersistedServiceSchema struct {
+	URL     string `json:"url"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Schema  string `json:"schema"`
+}
+
+// ReadSchemaSnapshot reads a persisted schema snapshot
+func ReadSchemaSnapshot(path string) (*PersistedSchemaSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshot PersistedSchemaSnapshot
+	if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("error decoding schema snapshot %q: %w", path, err)
+	}
+	if snapshot.Version != SchemaSnapshotFormatVersion {
+		return nil, fmt.Errorf("unsupported schema snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// WriteSchemaSnapshot writes the persisted schema snapshot. The file is
+// replaced atomically so it is never read partially written.
+func WriteSchemaSnapshot(path string, snapshot *PersistedSchemaSnapshot) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// newPersistedSchemaSnapshot returns the snapshot of the services schemas
+// along with the kept persisted schemas
+func newPersistedSchemaSnapshot(services []*Service, kept ...PersistedServiceSchema) *PersistedSchemaSnapshot {
+	snapshot := &PersistedSchemaSnapshot{Version: SchemaSnapshotFormatVersion}
+	snapshot.Services = append(snapshot.Services, kept...)
+	for _, svc := range services {
+		snapshot.Services = append(snapshot.Services, PersistedServiceSchema{
+			URL:     svc.ServiceURL,
+			Name:    svc.Name,
+			Version: svc.Version,
+			Schema:  svc.SchemaSource,
+		})
+	}
+	sort.Slice(snapshot.Services, func(i, j int) bool {
+		return snapshot.Services[i].URL < snapshot.Services[j].URL
+	})
+	return snapshot
+}
+
+// writeSchemaSnapshot persists the schemas of the merged services. The
+// services that failed their poll keep their persisted schema, so that it can
+// still be used if the gateway restarts while they are unreachable.
+func (s *ExecutableSchema) writeSchemaSnapshot(services []*Service) {
+	merged := make(map[string]bool, len(services))
+	for _, svc := range services {
+		merged[svc.ServiceURL] = true
+	}
+	var kept []PersistedServiceSchema
+	for url, persisted := range readPersistedSchemas(s.SchemaSnapshotFile) {
+		if svc, ok := s.Services[url]; ok && !merged[url] && svc.pollErr != nil {
+			kept = append(kept, persisted)
+		}
+	}
+
+	if err := WriteSchemaSnapshot(s.SchemaSnapshotFile, newPersistedSchemaSnapshot(services, kept...)); err != nil {
+		log.With("path", s.SchemaSnapshotFile, "error", err).Error("failed writing schema snapshot")
+	}
+}
+
+// readPersistedSchemas returns the persisted schemas by service URL, a missing
+// snapshot is not an error
+func readPersistedSchemas(path string) map[string]PersistedServiceSchema {
+	snapshot, err := ReadSchemaSnapshot(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.With("path", path, "error", err).Warn("failed reading schema snapshot")
+		}
+		return nil
+	}
+	schemas := make(map[string]PersistedServiceSchema, len(snapshot.Services))
+	for _, svc := range snapshot.Services {
+		schemas[svc.URL] = svc
+	}
+	return schemas
+}
+
+// useStaleSchema uses the persisted schema of an unreachable service until
+// it can be polled
+func (s *Service) useStaleSchema(persisted PersistedServiceSchema) error {
+	schema, gqlErr := gqlparser.LoadSchema(&ast.Source{Name: s.ServiceURL, Input: persisted.Schema})
+	if gqlErr != nil {
+		return gqlErr
+	}
+	if err := ValidateSchema(schema); err != nil {
+		return err
+	}
+
+	s.Name = persisted.Name
+	if s.Config.Name != "" {
+		s.Name = s.Config.Name
+	}
+	s.Version = persisted.Version
+	s.SchemaSource = persisted.Schema
+	s.Schema = schema
+	s.Stale = true
+	s.Status = "Stale"
+	return nil
+}
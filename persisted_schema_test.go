@@ -0,0 +1,79 @@
+package bramble
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistedSchemaSnapshot(t *testing.T) {
+	srv := newPolledService(t, "movies", "movies: [String!]", nil)
+	path := filepath.Join(t.TempDir(), "schema.json")
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(srv.URL))
+	es.SchemaSnapshotFile = path
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+
+	snapshot, err := ReadSchemaSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, &PersistedSchemaSnapshot{
+		Version: SchemaSnapshotFormatVersion,
+		Services: []PersistedServiceSchema{
+			{URL: srv.URL, Name: "movies", Version: "1.0", Schema: srv.Schema()},
+		},
+	}, snapshot)
+
+	t.Run("unreachable service on startup", func(t *testing.T) {
+		srv.Down.Store(true)
+		service := NewService(srv.URL)
+		es := NewExecutableSchema(nil, 50, nil, service)
+		es.SchemaSnapshotFile = path
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+		assert.True(t, service.Stale)
+		assert.Equal(t, "Stale", service.Status)
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.True(t, service.Stale, "the service is stale until it can be polled")
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+
+		srv.Down.Store(false)
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.False(t, service.Stale)
+		assert.Equal(t, "OK", service.Status)
+	})
+
+	t.Run("service down before a restart", func(t *testing.T) {
+		srv.Down.Store(false)
+		other := newPolledService(t, "shows", "shows: [String!]", nil)
+		path := filepath.Join(t.TempDir(), "schema.json")
+		es := NewExecutableSchema(nil, 50, nil, NewService(srv.URL), NewService(other.URL))
+		es.SchemaSnapshotFile = path
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+
+		srv.Down.Store(true)
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+		assert.Nil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+		snapshot, err := ReadSchemaSnapshot(path)
+		require.NoError(t, err)
+		assert.Len(t, snapshot.Services, 2, "the unreachable service keeps its persisted schema")
+
+		service := NewService(srv.URL)
+		es = NewExecutableSchema(nil, 50, nil, service, NewService(other.URL))
+		es.SchemaSnapshotFile = path
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+		assert.True(t, service.Stale)
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`{"version": 2, "services": []}`), 0o644))
+		_, err := ReadSchemaSnapshot(path)
+		assert.EqualError(t, err, "unsupported schema snapshot version 2")
+	})
+}
@@ -20,7 +20,12 @@ type QueryPlanStep struct {
 	SelectionSet   ast.SelectionSet
 	InsertionPoint []string
 	Then           []*QueryPlanStep
+	// Defer is set when the step resolves a deferred fragment
+	Defer *DeferredFragment
 
+	// cached is set for the steps of a cached plan, the positions of their
+	// selection sets are those of the operation the plan was built from
+	cached          bool
 	executionResult *executionStepResult
 }
 
@@ -67,6 +72,9 @@ func (s *QueryPlanStep) MarshalJSON() ([]byte, error) {
 // QueryPlan is a query execution plan
 type QueryPlan struct {
 	RootSteps []*QueryPlanStep
+	// Deferred contains the fragments marked with @defer, their steps are
+	// executed once the root steps have completed
+	Deferred []*DeferredFragment `json:",omitempty"`
 }
 
 // PlanningContext contains the necessary information used to plan a query.
@@ -86,19 +94,76 @@ func Plan(ctx *PlanningContext) (*QueryPlan, error) {
 		parentType = queryObjectName
 	case ast.Mutation:
 		parentType = mutationObjectName
+	case ast.Subscription:
+		parentType = subscriptionObjectName
 	default:
 		return nil, fmt.Errorf("not implemented")
 	}
 
-	steps, err := createSteps(ctx, nil, parentType, "", ctx.Operation.SelectionSet)
+	var steps []*QueryPlanStep
+	var err error
+	switch parentType {
+	case mutationObjectName:
+		steps, err = createSerialSteps(ctx, parentType, ctx.Operation.SelectionSet)
+	case queryObjectName:
+		steps, err = createRootDeferredSteps(ctx, ctx.Operation.SelectionSet)
+	default:
+		steps, err = createSteps(ctx, nil, parentType, "", ctx.Operation.SelectionSet)
+	}
 	if err != nil {
 		return nil, err
 	}
+	rootSteps, deferred := splitDeferredSteps(steps)
 	return &QueryPlan{
-		RootSteps: steps,
+		RootSteps: rootSteps,
+		Deferred:  deferred,
 	}, nil
 }
 
+// createSerialSteps creates the root steps for a selection set that must be
+// executed serially (i.e. mutations). The steps are returned in document
+// order, consecutive fields resolved by the same service are grouped in a
+// single step.
+func createSerialSteps(ctx *PlanningContext, parentType string, selectionSet ast.SelectionSet) ([]*QueryPlanStep, error) {
+	var result []*QueryPlanStep
+	var group ast.SelectionSet
+	var groupLocation string
+
+	flush := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		steps, err := createSteps(ctx, nil, parentType, "", group)
+		if err != nil {
+			return err
+		}
+		result = append(result, steps...)
+		group = nil
+		return nil
+	}
+
+	for _, field := range selectionSetToFields(selectionSet) {
+		location, err := ctx.Locations.URLFor(parentType, internalServiceName, field.Name)
+		if err != nil {
+			// namespaces can span multiple services, they are planned on
+			// their own
+			location = "namespace:" + field.Alias
+		}
+		if location != groupLocation {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			groupLocation = location
+		}
+		group = append(group, field)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func createSteps(ctx *PlanningContext, insertionPoint []string, parentType string, parentLocation string, selectionSet ast.SelectionSet) ([]*QueryPlanStep, error) {
 	var result []*QueryPlanStep
 
@@ -156,7 +221,7 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 					return nil, nil, gqlerror.Errorf("%s.%s: alias \"%s\" is reserved for system use", strings.Join(insertionPoint, "."), reservedAlias, reservedAlias)
 				}
 			}
-			if parentType != queryObjectName && parentType != mutationObjectName && ctx.IsBoundary[parentType] && selection.Name == IdFieldName {
+			if !isRootObjectName(parentType) && ctx.IsBoundary[parentType] && selection.Name == IdFieldName {
 				selectionSetResult = append(selectionSetResult, selection)
 				continue
 			}
@@ -186,10 +251,24 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 				childrenStepsResult = append(childrenStepsResult, childrenSteps...)
 			}
 		case *ast.InlineFragment:
+			if fragment := newDeferredFragment(ctx, insertionPoint, parentType, selection.TypeCondition, selection.Directives, selection.SelectionSet); fragment != nil {
+				childrenSteps, err := createDeferredSteps(ctx, fragment, location)
+				if err != nil {
+					return nil, nil, err
+				}
+				childrenStepsResult = append(childrenStepsResult, childrenSteps...)
+				continue
+			}
+			typeCondition := selection.TypeCondition
+			if typeCondition == "" {
+				// fragments without type condition (e.g. "... @defer") apply
+				// to the parent type
+				typeCondition = parentType
+			}
 			selectionSet, childrenSteps, err := extractSelectionSet(
 				ctx,
 				insertionPoint,
-				selection.TypeCondition,
+				typeCondition,
 				selection.SelectionSet,
 				location,
 			)
@@ -197,10 +276,19 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 				return nil, nil, err
 			}
 			inlineFragment := *selection
+			inlineFragment.TypeCondition = typeCondition
 			inlineFragment.SelectionSet = selectionSet
 			selectionSetResult = append(selectionSetResult, &inlineFragment)
 			childrenStepsResult = append(childrenStepsResult, childrenSteps...)
 		case *ast.FragmentSpread:
+			if fragment := newDeferredFragment(ctx, insertionPoint, parentType, selection.Definition.TypeCondition, selection.Directives, selection.Definition.SelectionSet); fragment != nil {
+				childrenSteps, err := createDeferredSteps(ctx, fragment, location)
+				if err != nil {
+					return nil, nil, err
+				}
+				childrenStepsResult = append(childrenStepsResult, childrenSteps...)
+				continue
+			}
 			selectionSet, childrenSteps, err := extractSelectionSet(
 				ctx,
 				insertionPoint,
@@ -237,6 +325,10 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 		mergedStepsMap := map[string]*QueryPlanStep{}
 		for _, step := range childrenStepsResult {
 			key := strings.Join(append([]string{step.ServiceURL, step.ParentType}, step.InsertionPoint...), "/")
+			if step.Defer != nil {
+				// deferred fragments are executed separately
+				key += fmt.Sprintf("/defer:%p", step.Defer)
+			}
 			if existingStep, ok := mergedStepsMap[key]; ok {
 				existingStep.SelectionSet = append(existingStep.SelectionSet, step.SelectionSet...)
 				existingStep.Then = append(existingStep.Then, step.Then...)
@@ -282,7 +374,7 @@ func extractSelectionSet(ctx *PlanningContext, insertionPoint []string, parentTy
 			Name:       "__typename",
 			Definition: &ast.FieldDefinition{Name: "__typename", Type: ast.NamedType("String", nil)},
 		})
-	} else if parentType != queryObjectName && parentType != mutationObjectName && ctx.IsBoundary[parentType] {
+	} else if !isRootObjectName(parentType) && ctx.IsBoundary[parentType] {
 		// Otherwise, add an id selection to all boundary types
 		if idDef := parentDef.Fields.ForName(IdFieldName); idDef != nil {
 			selectionSetResult = append(selectionSetResult,
@@ -322,6 +414,12 @@ func routeSelectionSet(ctx *PlanningContext, parentType string, parentLocation s
 			if isGraphQLBuiltinName(selection.Name) && parentLocation == "" {
 				continue
 			}
+			if !isRootObjectName(parentType) && ctx.IsBoundary[parentType] && selection.Name == IdFieldName {
+				// boundary ids can be resolved by any service, this happens
+				// when they are selected in a deferred fragment
+				result[parentLocation] = append(result[parentLocation], selection)
+				continue
+			}
 			loc, err := ctx.Locations.URLFor(parentType, parentLocation, selection.Name)
 			if err != nil {
 				return nil, err
@@ -0,0 +1,253 @@
+package bramble
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// planCache is a bounded cache of query plans. It is purged every time the
+// merged schema is updated.
+type planCache struct {
+	cache *lru.Cache[string, cachedPlan]
+}
+
+// cachedPlan contains the plan and the schema filtered by the permissions it
+// was planned against
+type cachedPlan struct {
+	plan   *QueryPlan
+	schema *ast.Schema
+}
+
+func newPlanCache(size int) *planCache {
+	cache, err := lru.New[string, cachedPlan](size)
+	if err != nil {
+		// only returned for a non positive size
+		panic(err)
+	}
+	return &planCache{cache: cache}
+}
+
+// get returns a copy of the cached plan, the steps of the cached plan must not
+// be shared between executions
+func (c *planCache) get(key string) (*QueryPlan, *ast.Schema, bool) {
+	cached, ok := c.cache.Get(key)
+	if !ok {
+		promPlanCacheCounter.WithLabelValues("miss").Inc()
+		return nil, nil, false
+	}
+	promPlanCacheCounter.WithLabelValues("hit").Inc()
+	return cached.plan.copy(), cached.schema, true
+}
+
+// add caches the plan. Plans with deferred fragments reference the
+// directives of the operation they were planned from and are not cached.
+func (c *planCache) add(key string, plan *QueryPlan, schema *ast.Schema) {
+	if len(plan.Deferred) > 0 {
+		return
+	}
+	c.cache.Add(key, cachedPlan{plan: plan.copy(), schema: schema})
+}
+
+func (c *planCache) purge() {
+	c.cache.Purge()
+}
+
+// copy returns a copy of the plan steps for the cache, without their
+// execution results
+func (p *QueryPlan) copy() *QueryPlan {
+	result := *p
+	result.RootSteps = copySteps(p.RootSteps)
+	return &result
+}
+
+func copySteps(steps []*QueryPlanStep) []*QueryPlanStep {
+	if steps == nil {
+		return nil
+	}
+	result := make([]*QueryPlanStep, len(steps))
+	for i, step := range steps {
+		stepCopy := *step
+		stepCopy.Then = copySteps(step.Then)
+		stepCopy.executionResult = nil
+		stepCopy.cached = true
+		result[i] = &stepCopy
+	}
+	return result
+}
+
+// literalArgumentPrefix is the prefix of the variables holding the literal
+// arguments of the incoming operation
+const literalArgumentPrefix = "_bramble_literal_"
+
+// hoistLiteralArguments returns the operation with the literal arguments of
+// its fields replaced by variables, and the values of these variables. The
+// operation must have its @skip and @include directives evaluated, its fields
+// are then copies that can be modified. The arguments of the introspection
+// fields are resolved by the gateway and are kept.
+func hoistLiteralArguments(operation *ast.OperationDefinition) (*ast.OperationDefinition, map[string]interface{}) {
+	literals := &literalArguments{}
+	literals.hoistSelectionSet(operation.SelectionSet)
+	if len(literals.values) == 0 {
+		return operation, nil
+	}
+
+	result := *operation
+	result.VariableDefinitions = append(append(ast.VariableDefinitionList{}, operation.VariableDefinitions...), literals.definitions...)
+	return &result, literals.values
+}
+
+// literalArguments contains the variables holding the literal arguments of
+// an operation
+type literalArguments struct {
+	definitions ast.VariableDefinitionList
+	values      map[string]interface{}
+}
+
+func (l *literalArguments) hoistSelectionSet(selectionSet ast.SelectionSet) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name, "__") {
+				continue
+			}
+			selection.Arguments = l.hoistArguments(selection.Arguments)
+			l.hoistSelectionSet(selection.SelectionSet)
+		case *ast.InlineFragment:
+			l.hoistSelectionSet(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			l.hoistSelectionSet(selection.Definition.SelectionSet)
+		}
+	}
+}
+
+// hoistArguments returns the arguments with the literal values replaced by
+// variables. Values containing variables and values for which the type is
+// unknown are kept.
+func (l *literalArguments) hoistArguments(arguments ast.ArgumentList) ast.ArgumentList {
+	if len(arguments) == 0 {
+		return arguments
+	}
+	result := make(ast.ArgumentList, 0, len(arguments))
+	for _, argument := range arguments {
+		v := argument.Value
+		if v == nil || v.ExpectedType == nil || len(valueVariables(v)) > 0 {
+			result = append(result, argument)
+			continue
+		}
+		value, err := v.Value(nil)
+		if err != nil {
+			result = append(result, argument)
+			continue
+		}
+
+		name := fmt.Sprintf("%s%d", literalArgumentPrefix, len(l.definitions))
+		definition := &ast.VariableDefinition{
+			Variable: name,
+			Type:     v.ExpectedType,
+			Position: v.Position,
+		}
+		if l.values == nil {
+			l.values = make(map[string]interface{})
+		}
+		l.definitions = append(l.definitions, definition)
+		l.values[name] = value
+		result = append(result, &ast.Argument{
+			Name:     argument.Name,
+			Position: argument.Position,
+			Value: &ast.Value{
+				Kind:               ast.Variable,
+				Raw:                name,
+				ExpectedType:       v.ExpectedType,
+				Definition:         v.Definition,
+				VariableDefinition: definition,
+				Position:           v.Position,
+			},
+		})
+	}
+	return result
+}
+
+// planCacheKey returns the cache key of the operation for the given
+// permissions. The operation must have its @skip and @include directives
+// evaluated and its literal arguments hoisted, the signature then only
+// depends on the variables affecting the directives.
+func planCacheKey(operation *ast.OperationDefinition, perms *OperationPermissions) string {
+	h := sha256.New()
+	io.WriteString(h, operationSignature(operation))
+	if perms != nil {
+		io.WriteString(h, "\npermissions:")
+		json.NewEncoder(h).Encode(perms)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// operationSignature returns a normalized representation of the operation,
+// fragment spreads are inlined so the fragment names and the formatting of
+// the document do not matter.
+func operationSignature(operation *ast.OperationDefinition) string {
+	var b strings.Builder
+	b.WriteString(string(operation.Operation))
+	writeSelectionSetSignature(&b, operation.SelectionSet)
+	return b.String()
+}
+
+func writeSelectionSetSignature(b *strings.Builder, selectionSet ast.SelectionSet) {
+	if len(selectionSet) == 0 {
+		return
+	}
+	b.WriteString("{")
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			b.WriteString(selection.Alias)
+			b.WriteString(":")
+			b.WriteString(selection.Name)
+			if len(selection.Arguments) > 0 {
+				b.WriteString("(")
+				for _, arg := range selection.Arguments {
+					b.WriteString(arg.Name)
+					b.WriteString(":")
+					b.WriteString(arg.Value.String())
+					b.WriteString(",")
+				}
+				b.WriteString(")")
+			}
+			writeDirectivesSignature(b, selection.Directives)
+			writeSelectionSetSignature(b, selection.SelectionSet)
+		case *ast.InlineFragment:
+			b.WriteString("...on ")
+			b.WriteString(selection.TypeCondition)
+			writeDirectivesSignature(b, selection.Directives)
+			writeSelectionSetSignature(b, selection.SelectionSet)
+		case *ast.FragmentSpread:
+			b.WriteString("...on ")
+			b.WriteString(selection.Definition.TypeCondition)
+			writeDirectivesSignature(b, selection.Directives)
+			writeSelectionSetSignature(b, selection.Definition.SelectionSet)
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString("}")
+}
+
+func writeDirectivesSignature(b *strings.Builder, directives ast.DirectiveList) {
+	for _, d := range directives {
+		b.WriteString("@")
+		b.WriteString(d.Name)
+		b.WriteString("(")
+		for _, arg := range d.Arguments {
+			b.WriteString(arg.Name)
+			b.WriteString(":")
+			b.WriteString(arg.Value.String())
+			b.WriteString(",")
+		}
+		b.WriteString(")")
+	}
+}
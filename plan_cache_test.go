@@ -0,0 +1,199 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestPlanCacheKey(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: `
+	type Movie {
+		id: ID!
+		title: String!
+	}
+
+	type Query {
+		movie(id: ID!): Movie
+	}`})
+	es := ExecutableSchema{}
+	key := func(query string, variables map[string]interface{}, perms *OperationPermissions) string {
+		operation := gqlparser.MustLoadQuery(schema, query).Operations[0]
+		operation, _ = hoistLiteralArguments(es.evaluateSkipAndInclude(variables, operation))
+		return planCacheKey(operation, perms)
+	}
+
+	reference := key(`query Movie($id: ID!) { movie(id: $id) { id title } }`, nil, nil)
+
+	assert.Equal(t, reference, key(`query Other($id: ID!) {
+		movie(id: $id) {
+			id
+			title
+		}
+	}`, nil, nil), "operation name and formatting are ignored")
+	assert.Equal(t,
+		key(`query($id: ID!) { movie(id: $id) { ... on Movie { id title } } }`, nil, nil),
+		key(`query($id: ID!) { movie(id: $id) { ...F } } fragment F on Movie { id title }`, nil, nil),
+		"fragment spreads are inlined",
+	)
+	assert.Equal(t, reference, key(`query($id: ID!, $skip: Boolean!) { movie(id: $id) { id title @skip(if: $skip) } }`, map[string]interface{}{"skip": false}, nil))
+	assert.NotEqual(t, reference, key(`query($id: ID!, $skip: Boolean!) { movie(id: $id) { id title @skip(if: $skip) } }`, map[string]interface{}{"skip": true}, nil))
+	assert.NotEqual(t, reference, key(`query($id: ID!) { movie(id: $id) { id t: title } }`, nil, nil))
+	assert.Equal(t,
+		key(`{ movie(id: "1") { id title } }`, nil, nil),
+		key(`{ movie(id: "2") { id title } }`, nil, nil),
+		"literal arguments are hoisted",
+	)
+	assert.NotEqual(t,
+		key(`{ __type(name: "Movie") { name } }`, nil, nil),
+		key(`{ __type(name: "Query") { name } }`, nil, nil),
+		"introspection arguments are kept",
+	)
+	assert.NotEqual(t, reference, key(`query Movie($id: ID!) { movie(id: $id) { id title } }`, nil, &OperationPermissions{
+		AllowedRootQueryFields: AllowedFields{AllowAll: true},
+	}))
+}
+
+func TestPlanCache(t *testing.T) {
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	es.SetPlanCacheSize(10)
+
+	execute := func() bool {
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movies { title } }`)
+		ctx := testContextWithVariables(nil, query.Operations[0])
+		ctx = context.WithValue(ctx, DebugKey, DebugInfo{Plan: true})
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"movies": [{"title": "Jurassic Park"}, {"title": "Alien"}]}`, string(resp.Data))
+		return graphql.GetExtension(ctx, "planCached").(bool)
+	}
+
+	hits := testutil.ToFloat64(promPlanCacheCounter.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(promPlanCacheCounter.WithLabelValues("miss"))
+
+	assert.False(t, execute())
+	assert.True(t, execute())
+	assert.True(t, execute())
+
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.True(t, execute(), "the cache is kept when the rebuilt schema didn't change")
+
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+	require.NoError(t, es.UpdateServiceList(context.Background(), []string{movieService.URL, shows.URL}))
+	assert.False(t, execute(), "the cache is purged when the schema is updated")
+
+	assert.Equal(t, hits+3, testutil.ToFloat64(promPlanCacheCounter.WithLabelValues("hit")))
+	assert.Equal(t, misses+2, testutil.ToFloat64(promPlanCacheCounter.WithLabelValues("miss")))
+}
+
+func TestPlanCacheLiteralArguments(t *testing.T) {
+	movieService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if strings.Contains(req.Query, "service") {
+			writeServiceSchema(w, "movies", polledServiceType+`type Query { service: Service! movie(id: ID!): String }`)
+			return
+		}
+		assert.Contains(t, req.Query, "movie(id: $"+literalArgumentPrefix+"0)")
+		fmt.Fprintf(w, `{"data": {"movie": "movie %s"}}`, req.Variables[literalArgumentPrefix+"0"])
+	}))
+	defer movieService.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	es.SetPlanCacheSize(10)
+	execute := func(id string) (string, bool) {
+		query := gqlparser.MustLoadQuery(es.MergedSchema, fmt.Sprintf(`{ movie(id: %q) }`, id))
+		ctx := testContextWithVariables(nil, query.Operations[0])
+		ctx = context.WithValue(ctx, DebugKey, DebugInfo{Plan: true})
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+		return string(resp.Data), graphql.GetExtension(ctx, "planCached").(bool)
+	}
+
+	data, cached := execute("1")
+	assert.JSONEq(t, `{"movie": "movie 1"}`, data)
+	assert.False(t, cached)
+
+	data, cached = execute("2")
+	assert.JSONEq(t, `{"movie": "movie 2"}`, data)
+	assert.True(t, cached, "the plan is shared by the operations only differing by their literal arguments")
+}
+
+func TestPlanCacheKeptWhileServiceDown(t *testing.T) {
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL), NewService(shows.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	es.SetPlanCacheSize(10)
+	execute := func() bool {
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movies { title } }`)
+		ctx := testContextWithVariables(nil, query.Operations[0])
+		ctx = context.WithValue(ctx, DebugKey, DebugInfo{Plan: true})
+		require.Empty(t, es.ExecuteQuery(ctx).Errors)
+		return graphql.GetExtension(ctx, "planCached").(bool)
+	}
+
+	shows.Down.Store(true)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+	assert.False(t, execute())
+
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.True(t, execute(), "polling the unreachable service again doesn't purge the cache")
+}
+
+func TestPlanCacheCopiesSteps(t *testing.T) {
+	plan := &QueryPlan{RootSteps: []*QueryPlanStep{{
+		ServiceURL:      "http://service",
+		Then:            []*QueryPlanStep{{ServiceURL: "http://other"}},
+		executionResult: &executionStepResult{executed: true},
+	}}}
+
+	cache := newPlanCache(1)
+	cache.add("key", plan, nil)
+	cached, _, ok := cache.get("key")
+	require.True(t, ok)
+
+	assert.NotSame(t, plan.RootSteps[0], cached.RootSteps[0])
+	assert.NotSame(t, plan.RootSteps[0].Then[0], cached.RootSteps[0].Then[0])
+	assert.Nil(t, cached.RootSteps[0].executionResult)
+	assert.Equal(t, "http://other", cached.RootSteps[0].Then[0].ServiceURL)
+}
+
+func TestCachedPlanErrorsWithoutLocations(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `type Query { movies: [String!] }`})
+	query := gqlparser.MustLoadQuery(schema, "\n  { movies }")
+	step := &QueryPlanStep{ServiceURL: "http://movies", ParentType: "Query", SelectionSet: query.Operations[0].SelectionSet}
+	q := newQueryExecution(context.Background(), "", nil, schema, nil, 50)
+
+	errs := q.createGQLErrors(step, errors.New("failed"))
+	require.Len(t, errs, 1)
+	assert.Equal(t, []gqlerror.Location{{Line: 2, Column: 5}}, errs[0].Locations)
+
+	cache := newPlanCache(1)
+	cache.add("key", &QueryPlan{RootSteps: []*QueryPlanStep{step}}, nil)
+	cached, _, _ := cache.get("key")
+	errs = q.createGQLErrors(cached.RootSteps[0], errors.New("failed"))
+	require.Len(t, errs, 1)
+	assert.Empty(t, errs[0].Locations, "the positions of a cached plan are those of another operation")
+}
@@ -3,6 +3,7 @@ package bramble
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -681,6 +682,234 @@ func TestQueryPlanSupportsMutations(t *testing.T) {
 	`)
 }
 
+func TestQueryPlanMutationsKeepDocumentOrder(t *testing.T) {
+	f := &PlanTestFixture{
+		Schema: `
+		type Query {
+			order(id: ID!): Int
+		}
+
+		type Mutation {
+			createOrder: ID!
+			reserveStock: Boolean
+			chargeCard: Boolean
+			shipOrder: Boolean
+		}
+		`,
+		Locations: map[string]string{
+			"Query.order":           "A",
+			"Mutation.createOrder":  "A",
+			"Mutation.reserveStock": "A",
+			"Mutation.chargeCard":   "B",
+			"Mutation.shipOrder":    "A",
+		},
+	}
+
+	for i := 0; i < 10; i++ {
+		plan, err := f.Plan(t, `mutation { createOrder reserveStock chargeCard shipOrder }`)
+		require.NoError(t, err)
+		assert.JSONEq(t, `
+		{
+			"RootSteps": [
+			  {
+				"ServiceURL": "A",
+				"ParentType": "Mutation",
+				"SelectionSet": "{ createOrder reserveStock }",
+				"InsertionPoint": null,
+				"Then": null
+			  },
+			  {
+				"ServiceURL": "B",
+				"ParentType": "Mutation",
+				"SelectionSet": "{ chargeCard }",
+				"InsertionPoint": null,
+				"Then": null
+			  },
+			  {
+				"ServiceURL": "A",
+				"ParentType": "Mutation",
+				"SelectionSet": "{ shipOrder }",
+				"InsertionPoint": null,
+				"Then": null
+			  }
+			]
+		}`, jsonMustMarshal(plan))
+	}
+}
+
+func TestQueryPlanSupportsSubscriptions(t *testing.T) {
+	f := &PlanTestFixture{
+		Schema: `
+		directive @boundary on OBJECT
+
+		interface Node {
+			id: ID!
+		}
+
+		type Movie implements Node @boundary {
+			id: ID!
+			title: String
+			release: Int
+		}
+
+		type Query {
+			movie(id: ID!): Movie
+		}
+
+		type Subscription {
+			movieUpdated(id: ID!): Movie
+		}
+		`,
+		Locations: map[string]string{
+			"Movie.title":               "A",
+			"Movie.release":             "B",
+			"Query.movie":               "A",
+			"Subscription.movieUpdated": "A",
+		},
+		IsBoundary: map[string]bool{
+			"Movie": true,
+		},
+	}
+
+	f.Check(t, `subscription { movieUpdated(id: "2") { title release }}`, `
+	{
+		"RootSteps": [
+		  {
+			"ServiceURL": "A",
+			"ParentType": "Subscription",
+			"SelectionSet": "{ movieUpdated(id: \"2\") { title _bramble_id: id _bramble__typename: __typename } }",
+			"InsertionPoint": null,
+			"Then": [
+			  {
+				"ServiceURL": "B",
+				"ParentType": "Movie",
+				"SelectionSet": "{ release _bramble_id: id _bramble__typename: __typename }",
+				"InsertionPoint": [
+				  "movieUpdated"
+				],
+				"Then": null
+			  }
+			]
+		  }
+		]
+	  }
+	`)
+}
+
+func TestQueryPlanDeferredFragment(t *testing.T) {
+	PlanTestFixture1.Check(t, `{ movies { title ... @defer(label: "comps") { compTitles(limit: 2) { id } } } }`, `
+	{
+		"RootSteps": [
+		  {
+			"ServiceURL": "A",
+			"ParentType": "Query",
+			"SelectionSet": "{ movies { title _bramble_id: id _bramble__typename: __typename } }",
+			"InsertionPoint": null,
+			"Then": null
+		  }
+		],
+		"Deferred": [
+		  {
+			"Label": "comps",
+			"Path": ["movies"],
+			"ParentType": "Movie",
+			"SelectionSet": "{ compTitles(limit: 2) { id } }",
+			"Steps": [
+			  {
+				"ServiceURL": "B",
+				"ParentType": "Movie",
+				"SelectionSet": "{ compTitles(limit: 2) { id _bramble_id: id _bramble__typename: __typename } _bramble_id: id _bramble__typename: __typename }",
+				"InsertionPoint": ["movies"],
+				"Then": null
+			  }
+			]
+		  }
+		]
+	}
+	`)
+}
+
+func TestQueryPlanDeferredFragmentSameService(t *testing.T) {
+	PlanTestFixture1.Check(t, `{ movies { id ... @defer { title } } }`, `
+	{
+		"RootSteps": [
+		  {
+			"ServiceURL": "A",
+			"ParentType": "Query",
+			"SelectionSet": "{ movies { id _bramble_id: id _bramble__typename: __typename } }",
+			"InsertionPoint": null,
+			"Then": null
+		  }
+		],
+		"Deferred": [
+		  {
+			"Path": ["movies"],
+			"ParentType": "Movie",
+			"SelectionSet": "{ title }",
+			"Steps": [
+			  {
+				"ServiceURL": "A",
+				"ParentType": "Movie",
+				"SelectionSet": "{ title _bramble_id: id _bramble__typename: __typename }",
+				"InsertionPoint": ["movies"],
+				"Then": null
+			  }
+			]
+		  }
+		]
+	}
+	`)
+}
+
+func TestQueryPlanDeferredRootFragment(t *testing.T) {
+	PlanTestFixture1.Check(t, `{ movies { title } ... @defer(label: "transactions") { transactions { gross } } }`, `
+	{
+		"RootSteps": [
+		  {
+			"ServiceURL": "A",
+			"ParentType": "Query",
+			"SelectionSet": "{ movies { title _bramble_id: id _bramble__typename: __typename } }",
+			"InsertionPoint": null,
+			"Then": null
+		  }
+		],
+		"Deferred": [
+		  {
+			"Label": "transactions",
+			"Path": null,
+			"ParentType": "Query",
+			"SelectionSet": "{ transactions { gross } }",
+			"Steps": [
+			  {
+				"ServiceURL": "C",
+				"ParentType": "Query",
+				"SelectionSet": "{ transactions { gross } }",
+				"InsertionPoint": null,
+				"Then": null
+			  }
+			]
+		  }
+		]
+	}
+	`)
+}
+
+func TestQueryPlanIgnoresDeferOnNonBoundaryType(t *testing.T) {
+	PlanTestFixture1.Check(t, `{ transactions { ... @defer { gross } } }`, `
+	{
+		"RootSteps": [
+		  {
+			"ServiceURL": "C",
+			"ParentType": "Query",
+			"SelectionSet": "{ transactions { ... on Transaction { gross } } }",
+			"InsertionPoint": null,
+			"Then": null
+		  }
+		]
+	}
+	`)
+}
+
 func TestQueryPlanWithPaginatedBoundaryType(t *testing.T) {
 	PlanTestFixture5.Check(t, "{ foo { foos { cursor page { id name size } } } }", `
     {
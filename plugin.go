@@ -33,6 +33,9 @@ type Plugin interface {
 
 	InterceptRequest(ctx context.Context, operationName, rawQuery string, variables map[string]interface{})
 	InterceptResponse(ctx context.Context, operationName, rawQuery string, variables map[string]interface{}, response *graphql.Response) *graphql.Response
+	// Shutdown is called once when the gateway is drained, after the
+	// operations completed and before the telemetry is shut down
+	Shutdown(ctx context.Context) error
 }
 
 // BasePlugin is an empty plugin. It can be embedded by any plugin as a way to avoid
@@ -86,6 +89,12 @@ func (p *BasePlugin) WrapGraphQLClientTransport(transport http.RoundTripper) htt
 	return transport
 }
 
+// Shutdown is called when the gateway is drained. It can be used to flush
+// the state of the plugin.
+func (p *BasePlugin) Shutdown(ctx context.Context) error {
+	return nil
+}
+
 var registeredPlugins = map[string]Plugin{}
 
 // RegisterPlugin register a plugin so that it can be enabled via the configuration.
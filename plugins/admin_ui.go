@@ -63,11 +63,14 @@ func (s services) Swap(i, j int) {
 }
 
 type service struct {
-	Name       string
-	Version    string
-	ServiceURL string
-	Schema     string
-	Status     string
+	Name            string
+	Version         string
+	ServiceURL      string
+	Source          string
+	Schema          string
+	Status          string
+	CircuitBreaker  bramble.CircuitBreakerState
+	QuarantineError string
 }
 
 type templateVariables struct {
@@ -89,13 +92,16 @@ func (p *AdminUIPlugin) handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	for _, s := range p.executableSchema.Services {
+	for _, s := range p.executableSchema.State().Services {
 		vars.Services = append(vars.Services, service{
-			Name:       s.Name,
-			Version:    s.Version,
-			ServiceURL: s.ServiceURL,
-			Schema:     s.SchemaSource,
-			Status:     s.Status,
+			Name:            s.Name,
+			Version:         s.Version,
+			ServiceURL:      s.ServiceURL,
+			Source:          s.Config.Source,
+			Schema:          s.SchemaSource,
+			Status:          s.Status,
+			CircuitBreaker:  p.executableSchema.GraphqlClient.CircuitBreakerState(s.ServiceURL),
+			QuarantineError: s.QuarantineError,
 		})
 	}
 
@@ -115,7 +121,7 @@ func (p *AdminUIPlugin) testSchema(schemaStr string) (string, error) {
 	}
 
 	schemas := []*ast.Schema{schema}
-	for _, service := range p.executableSchema.Services {
+	for _, service := range p.executableSchema.State().Services {
 		schemas = append(schemas, service.Schema)
 	}
 
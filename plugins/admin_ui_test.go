@@ -1,13 +1,18 @@
 package plugins
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/movio/bramble"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
 )
@@ -17,10 +22,13 @@ func TestAdminUI(t *testing.T) {
 	es := &bramble.ExecutableSchema{
 		Services: map[string]*bramble.Service{
 			"svc-a": {
-				Schema: gqlparser.MustLoadSchema(&ast.Source{Input: ``}),
+				Schema:          gqlparser.MustLoadSchema(&ast.Source{Input: ``}),
+				Quarantined:     true,
+				QuarantineError: "schema can't be merged",
 			},
 			"svc-b": {
 				Schema: gqlparser.MustLoadSchema(&ast.Source{Input: ``}),
+				Config: bramble.ServiceConfig{Source: "dns-srv:_graphql._tcp.services.test"},
 			},
 		},
 	}
@@ -55,4 +63,74 @@ func TestAdminUI(t *testing.T) {
 
 		assert.NotContains(t, rr.Body.String(), "Schema merged successfully")
 	})
+
+	t.Run("circuit breaker state", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		assert.NotContains(t, rr.Body.String(), "Circuit breaker")
+
+		es.GraphqlClient = bramble.NewClient(bramble.WithCircuitBreakers(bramble.CircuitBreakerConfig{}))
+		rr = httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		assert.Contains(t, rr.Body.String(), "Circuit breaker: closed")
+	})
+
+	t.Run("quarantined service", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		assert.Contains(t, rr.Body.String(), "Using the previous schema: schema can&#39;t be merged")
+	})
+
+	t.Run("discovered service", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		assert.Contains(t, rr.Body.String(), "Discovered by dns-srv:_graphql._tcp.services.test")
+	})
+}
+
+func TestPluginsDuringSchemaUpdates(t *testing.T) {
+	schema := `type Service { name: String! version: String! schema: String! }
+	type Query { service: Service! movies: [String!] }`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"service": {"name": "movies", "version": "1.0", "schema": %q}}}`, schema)
+	}))
+	defer srv.Close()
+
+	es := bramble.NewExecutableSchema(nil, 50, nil, bramble.NewService(srv.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	m := http.NewServeMux()
+	adminUI, meta := &AdminUIPlugin{}, NewMetaPlugin()
+	for _, plugin := range []bramble.Plugin{adminUI, meta} {
+		plugin.Init(es)
+		plugin.SetupPrivateMux(m)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			rr := httptest.NewRecorder()
+			m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			rr = httptest.NewRecorder()
+			query := `{"query": "{ meta { services { name status fields { name service } } } }"}`
+			m.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/bramble-meta-plugin-query", strings.NewReader(query)))
+			assert.Contains(t, rr.Body.String(), `"services":[{`)
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			require.NoError(t, es.UpdateSchema(context.Background(), true))
+			continue
+		}
+		require.NoError(t, es.UpdateServices(context.Background(), []bramble.ServiceConfig{
+			{URL: srv.URL, Name: fmt.Sprintf("movies-%d", i)},
+		}))
+	}
+	cancel()
+	wg.Wait()
 }
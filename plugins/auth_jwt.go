@@ -120,7 +120,8 @@ func (p *JWTPlugin) ApplyMiddlewarePublicMux(h http.Handler) http.Handler {
 		if err != nil {
 			// unauthenticated request, must use "public_role"
 			log.Info("unauthenticated request")
-			r = r.WithContext(bramble.AddPermissionsToContext(r.Context(), p.config.Roles["public_role"]))
+			ctx := bramble.AddPermissionsToContext(r.Context(), p.config.Roles["public_role"])
+			r = r.WithContext(bramble.AddRoleToContext(ctx, "public_role"))
 			h.ServeHTTP(rw, r)
 			return
 		}
@@ -160,6 +161,10 @@ func (p *JWTPlugin) ApplyMiddlewarePublicMux(h http.Handler) http.Handler {
 
 		ctx := r.Context()
 		ctx = bramble.AddPermissionsToContext(ctx, role)
+		ctx = bramble.AddRoleToContext(ctx, claims.Role)
+		if claims.Subject != "" {
+			ctx = bramble.AddSubjectToContext(ctx, claims.Subject)
+		}
 		ctx = addStandardJWTClaimsToOutgoingRequest(ctx, claims.RegisteredClaims)
 		ctx = bramble.AddOutgoingRequestsHeaderToContext(ctx, "JWT-Claim-Role", claims.Role)
 		h.ServeHTTP(rw, r.WithContext(ctx))
@@ -82,6 +82,12 @@ func TestJWTPlugin(t *testing.T) {
 			role, ok := bramble.GetPermissionsFromContext(r.Context())
 			assert.True(t, ok)
 			assert.Equal(t, basicRole, role)
+			roleName, ok := bramble.GetRoleFromContext(r.Context())
+			assert.True(t, ok)
+			assert.Equal(t, "basic_role", roleName)
+			subject, ok := bramble.GetSubjectFromContext(r.Context())
+			assert.True(t, ok)
+			assert.Equal(t, "test-subject", subject)
 			w.WriteHeader(http.StatusTeapot)
 		})
 
@@ -31,6 +31,8 @@ type BrambleService @boundary {
 	version: String!
 	schema: String!
 	status: String!
+	quarantined: Boolean!
+	quarantineError: String
 	serviceUrl: String!
 	fields: [BrambleField!]!
 	types: [BrambleType!]!
@@ -176,10 +178,10 @@ type brambleSchema struct {
 }
 
 func (r *metaResolver) Schema() (*brambleSchema, error) {
-	schema := r.executableSchema.MergedSchema
+	state := r.executableSchema.State()
 	var types brambleTypes
-	for name, def := range schema.Types {
-		types = append(types, r.brambleType(name, def))
+	for name, def := range state.MergedSchema.Types {
+		types = append(types, r.brambleType(state, name, def))
 	}
 	sort.Sort(types)
 	return &brambleSchema{
@@ -224,7 +226,8 @@ func (r *metaPluginResolver) GetType(ctx context.Context, args struct{ ID graphq
 
 func (r *metaResolver) GetType(ctx context.Context, args struct{ ID graphql.ID }) (*brambleType, error) {
 	typeName := string(args.ID)
-	for _, t := range r.getTypes(r.executableSchema.MergedSchema) {
+	state := r.executableSchema.State()
+	for _, t := range r.getTypes(state, state.MergedSchema) {
 		if t.Name == typeName {
 			return &t, nil
 		}
@@ -233,29 +236,25 @@ func (r *metaResolver) GetType(ctx context.Context, args struct{ ID graphql.ID }
 	return nil, nil
 }
 
-func (r *metaResolver) getTypes(schema *ast.Schema) []brambleType {
+func (r *metaResolver) getTypes(state bramble.SchemaState, schema *ast.Schema) []brambleType {
 	if schema == nil {
 		return nil
 	}
 	var result []brambleType
 	for _, def := range schema.Types {
-		result = append(result, r.brambleType(def.Name, def))
+		result = append(result, r.brambleType(state, def.Name, def))
 	}
 
 	return result
 }
 
-func (r *metaResolver) brambleType(name string, def *ast.Definition) brambleType {
+func (r *metaResolver) brambleType(state bramble.SchemaState, name string, def *ast.Definition) brambleType {
 	var fields brambleFields
 	for _, f := range def.Fields {
 		if strings.HasPrefix(f.Name, "__") {
 			continue
 		}
-		var svcName string
-		if svcURL, err := r.executableSchema.Locations.URLFor(def.Name, "", f.Name); err == nil {
-			svc := r.executableSchema.Services[svcURL]
-			svcName = svc.Name
-		}
+		svcName := serviceName(state, def.Name, f.Name)
 		var args []brambleArg
 		for _, a := range f.Arguments {
 			args = append(args, brambleArg{
@@ -315,7 +314,8 @@ func (r *metaPluginResolver) GetField(ctx context.Context, args struct{ ID graph
 }
 
 func (r *metaResolver) GetField(ctx context.Context, args struct{ ID graphql.ID }) (*brambleField, error) {
-	for _, f := range r.getFields(r.executableSchema.MergedSchema) {
+	state := r.executableSchema.State()
+	for _, f := range r.getFields(state, state.MergedSchema) {
 		if f.ID == args.ID {
 			return &f, nil
 		}
@@ -324,19 +324,14 @@ func (r *metaResolver) GetField(ctx context.Context, args struct{ ID graphql.ID
 	return nil, nil
 }
 
-func (r *metaResolver) getFields(schema *ast.Schema) []brambleField {
+func (r *metaResolver) getFields(state bramble.SchemaState, schema *ast.Schema) []brambleField {
 	if schema == nil {
 		return nil
 	}
 	var result []brambleField
 	for _, def := range schema.Types {
 		for _, f := range def.Fields {
-			var svcName string
-			if svcURL, err := r.executableSchema.Locations.URLFor(def.Name, "", f.Name); err == nil {
-				if svc := r.executableSchema.Services[svcURL]; svc != nil {
-					svcName = svc.Name
-				}
-			}
+			svcName := serviceName(state, def.Name, f.Name)
 			var args []brambleArg
 			for _, a := range f.Arguments {
 				args = append(args, brambleArg{
@@ -359,14 +354,28 @@ func (r *metaResolver) getFields(schema *ast.Schema) []brambleField {
 	return result
 }
 
+// serviceName returns the name of the service resolving the field
+func serviceName(state bramble.SchemaState, typeName, fieldName string) string {
+	svcURL, err := state.Locations.URLFor(typeName, "", fieldName)
+	if err != nil {
+		return ""
+	}
+	if svc := state.Services[svcURL]; svc != nil {
+		return svc.Name
+	}
+	return ""
+}
+
 type brambleService struct {
-	Name       string
-	Version    string
-	Schema     string
-	Status     string
-	ServiceURL string
-	Fields     []brambleField
-	Types      []brambleType
+	Name            string
+	Version         string
+	Schema          string
+	Status          string
+	Quarantined     bool
+	QuarantineError *string
+	ServiceURL      string
+	Fields          []brambleField
+	Types           []brambleType
 }
 
 func (s brambleService) Id() graphql.ID {
@@ -392,16 +401,23 @@ func (s externalBrambleServices) Swap(i, j int) {
 }
 
 func (r *metaResolver) Services() []brambleService {
+	state := r.executableSchema.State()
 	var services externalBrambleServices
-	for _, element := range r.executableSchema.Services {
+	for _, element := range state.Services {
+		var quarantineError *string
+		if element.Quarantined {
+			quarantineError = &element.QuarantineError
+		}
 		services = append(services, brambleService{
-			Name:       element.Name,
-			Version:    element.Version,
-			Schema:     element.SchemaSource,
-			Status:     element.Status,
-			ServiceURL: element.ServiceURL,
-			Fields:     r.getFields(element.Schema),
-			Types:      r.getTypes(element.Schema),
+			Name:            element.Name,
+			Version:         element.Version,
+			Schema:          element.SchemaSource,
+			Status:          element.Status,
+			Quarantined:     element.Quarantined,
+			QuarantineError: quarantineError,
+			ServiceURL:      element.ServiceURL,
+			Fields:          r.getFields(state, element.Schema),
+			Types:           r.getTypes(state, element.Schema),
 		})
 	}
 	sort.Sort(services)
@@ -0,0 +1,74 @@
+package bramble
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// polledServiceType is the Service type of the polled services
+const polledServiceType = `type Service {
+	name: String!
+	version: String!
+	schema: String!
+}
+`
+
+// polledService is a federated service whose schema and version can be
+// changed and that can be taken down. It counts the schema polls and the
+// version checks.
+type polledService struct {
+	*httptest.Server
+	Down          atomic.Bool
+	Polls         atomic.Int32
+	VersionChecks atomic.Int32
+
+	schema  atomic.Value
+	version atomic.Value
+}
+
+// newPolledService starts a service with the given root query fields, the
+// requests other than the schema polls are sent to the query handler
+func newPolledService(t *testing.T, name, queryFields string, query http.HandlerFunc) *polledService {
+	s := &polledService{}
+	s.SetQueryFields(queryFields)
+	s.SetVersion("1.0")
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "brambleServiceVersion"):
+			s.VersionChecks.Add(1)
+			fmt.Fprintf(w, `{"data": {"service": {"version": %q}}}`, s.version.Load())
+		case query != nil && !strings.Contains(string(body), "brambleServicePoll"):
+			query(w, r)
+		default:
+			s.Polls.Add(1)
+			schema, _ := json.Marshal(s.Schema())
+			fmt.Fprintf(w, `{"data": {"service": {"name": %q, "version": %q, "schema": %s}}}`, name, s.version.Load(), schema)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Schema returns the schema the service publishes
+func (s *polledService) Schema() string {
+	return s.schema.Load().(string)
+}
+
+func (s *polledService) SetQueryFields(fields string) {
+	s.schema.Store(polledServiceType + `type Query { service: Service! ` + fields + ` }`)
+}
+
+func (s *polledService) SetVersion(version string) {
+	s.version.Store(version)
+}
@@ -0,0 +1,59 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var errServiceNotFound = errors.New("service not found")
+
+// RefreshServices polls the given services immediately, without waiting for
+// their next poll, and updates the schema. Services are identified by name or
+// URL, all the services are polled when none is given.
+func (s *ExecutableSchema) RefreshServices(ctx context.Context, services ...string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var refresh []*Service
+	for _, id := range services {
+		found := false
+		for _, svc := range s.Services {
+			if svc.Name == id || svc.ServiceURL == id {
+				refresh = append(refresh, svc)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", errServiceNotFound, id)
+		}
+	}
+	if len(services) == 0 {
+		for _, svc := range s.Services {
+			refresh = append(refresh, svc)
+		}
+	}
+
+	for _, svc := range refresh {
+		svc.pollDelay = 0
+	}
+	return s.updateSchema(ctx, false)
+}
+
+// refreshHandler polls the services given by the service query parameters,
+// or all the services, and returns the health report
+func (g *Gateway) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	err := g.ExecutableSchema.RefreshServices(r.Context(), r.URL.Query()["service"]...)
+	switch {
+	case errors.Is(err, errServiceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(g.health())
+}
@@ -0,0 +1,105 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshServices(t *testing.T) {
+	srv := newPolledService(t, "movies", "movies: [String!]", nil)
+
+	es := NewExecutableSchema(nil, 50, nil, NewServiceFromConfig(ServiceConfig{URL: srv.URL, PollIntervalDuration: time.Hour}))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	router := NewGateway(es, nil).PrivateRouter()
+	refresh := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/services/refresh"+query, nil))
+		return rec
+	}
+
+	srv.SetQueryFields("movies: [String!] shows: [String!]")
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.Equal(t, int32(1), srv.Polls.Load(), "the service is not polled before its interval elapsed")
+
+	rec := refresh("?service=movies")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(2), srv.Polls.Load())
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	assert.Equal(t, http.StatusOK, refresh("").Code)
+	assert.Equal(t, int32(3), srv.Polls.Load())
+
+	rec = refresh("?service=books")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, int32(3), srv.Polls.Load())
+	assert.True(t, errors.Is(es.RefreshServices(context.Background(), "books"), errServiceNotFound))
+}
+
+func TestServiceVersionCheck(t *testing.T) {
+	srv := newPolledService(t, "movies", "movies: [String!]", nil)
+
+	versionCheck := true
+	es := NewExecutableSchema(nil, 50, nil, NewServiceFromConfig(ServiceConfig{URL: srv.URL, VersionCheck: &versionCheck}))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, int32(1), srv.Polls.Load())
+	assert.Equal(t, int32(0), srv.VersionChecks.Load(), "the first poll fetches the schema")
+
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, int32(1), srv.Polls.Load(), "the schema is not fetched when the version didn't change")
+	assert.Equal(t, int32(1), srv.VersionChecks.Load())
+
+	srv.SetQueryFields("movies: [String!] shows: [String!]")
+	srv.SetVersion("1.1")
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, int32(2), srv.Polls.Load())
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+}
+
+func TestServiceVersionCheckAfterOutage(t *testing.T) {
+	srv := newPolledService(t, "movies", "movies: [String!]", nil)
+	other := newPolledService(t, "shows", "shows: [String!]", nil)
+
+	versionCheck := true
+	es := NewExecutableSchema(nil, 50, nil,
+		NewServiceFromConfig(ServiceConfig{URL: srv.URL, VersionCheck: &versionCheck}),
+		NewService(other.URL),
+	)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	require.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+
+	srv.Down.Store(true)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+
+	srv.Down.Store(false)
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.Equal(t, int32(2), srv.Polls.Load(), "the schema is fetched when the service is back at the same version")
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+	assert.Equal(t, srv.Schema(), es.Services[srv.URL].SchemaSource)
+}
+
+func TestServicePollBackoff(t *testing.T) {
+	svc := NewServiceFromConfig(ServiceConfig{
+		URL:                    "http://movies",
+		PollIntervalDuration:   time.Second,
+		MaxPollBackoffDuration: 8 * time.Second,
+	})
+
+	for _, maxDelay := range []time.Duration{1, 2, 4, 8, 8} {
+		svc.schedulePoll(errors.New("unreachable"))
+		maxDelay *= time.Second
+		assert.GreaterOrEqual(t, svc.pollDelay, maxDelay/2)
+		assert.LessOrEqual(t, svc.pollDelay, maxDelay)
+	}
+
+	svc.schedulePoll(nil)
+	assert.Equal(t, time.Second, svc.pollDelay, "reachable services are polled at their interval")
+	assert.Equal(t, 0, svc.pollFailures)
+}
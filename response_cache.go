@@ -0,0 +1,254 @@
+package bramble
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const (
+	cacheControlDirectiveName = "cacheControl"
+	cacheControlScopeTypeName = "CacheControlScope"
+
+	cacheControlScopePrivate = "PRIVATE"
+
+	defaultResponseCacheSize = 1000
+)
+
+// ResponseCacheConfig configures the response cache.
+type ResponseCacheConfig struct {
+	// Enabled enables the response cache and the Cache-Control header
+	Enabled bool `json:"enabled"`
+	// Size is the number of responses kept by the default in memory store,
+	// defaults to 1000
+	Size int `json:"size"`
+}
+
+// ResponseCacheStore stores the cached responses. The stored values are
+// opaque, they contain the response and the time it was cached at.
+type ResponseCacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, response []byte, ttl time.Duration)
+}
+
+// NewMemoryResponseCacheStore returns an in memory store keeping the given
+// number of most recently used responses.
+func NewMemoryResponseCacheStore(size int) ResponseCacheStore {
+	cache, err := lru.New[string, memoryResponseCacheEntry](size)
+	if err != nil {
+		// only returned for a non positive size
+		panic(err)
+	}
+	return &memoryResponseCacheStore{cache: cache}
+}
+
+type memoryResponseCacheStore struct {
+	cache *lru.Cache[string, memoryResponseCacheEntry]
+}
+
+type memoryResponseCacheEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+func (m *memoryResponseCacheStore) Get(ctx context.Context, key string) ([]byte, bool) {
+	entry, ok := m.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		m.cache.Remove(key)
+		return nil, false
+	}
+	return entry.response, true
+}
+
+func (m *memoryResponseCacheStore) Set(ctx context.Context, key string, response []byte, ttl time.Duration) {
+	m.cache.Add(key, memoryResponseCacheEntry{response: response, expires: time.Now().Add(ttl)})
+}
+
+// encodeCachedResponse returns the value stored for the response, the
+// response is prefixed with the time it was stored at so the cache hits can
+// report their remaining max age
+func encodeCachedResponse(response []byte, storedAt time.Time) []byte {
+	value := strconv.AppendInt(make([]byte, 0, len(response)+21), storedAt.Unix(), 10)
+	value = append(value, '\n')
+	return append(value, response...)
+}
+
+// decodeCachedResponse returns the response and the time it was stored at
+func decodeCachedResponse(value []byte) ([]byte, time.Time, bool) {
+	i := bytes.IndexByte(value, '\n')
+	if i < 0 {
+		return nil, time.Time{}, false
+	}
+	storedAt, err := strconv.ParseInt(string(value[:i]), 10, 64)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	return value[i+1:], time.Unix(storedAt, 0), true
+}
+
+// cachePolicy is the cache policy of an operation, computed from the
+// @cacheControl hints of the fields
+type cachePolicy struct {
+	maxAge  int
+	private bool
+}
+
+func (p cachePolicy) cacheable() bool {
+	return p.maxAge > 0
+}
+
+// header returns the value of the Cache-Control header
+func (p cachePolicy) header() string {
+	if !p.cacheable() {
+		return "no-store"
+	}
+	if p.private {
+		return fmt.Sprintf("max-age=%d, private", p.maxAge)
+	}
+	return fmt.Sprintf("max-age=%d, public", p.maxAge)
+}
+
+// operationCachePolicy returns the minimum max age and the most restrictive
+// scope of the fields of the operation. Root fields and fields returning a
+// composite type default to a max age of 0, leaf fields default to the max
+// age of their parent.
+func operationCachePolicy(schema *ast.Schema, operation *ast.OperationDefinition) cachePolicy {
+	policy := cachePolicy{maxAge: -1}
+	selectionSetCachePolicy(schema, operation.SelectionSet, true, &policy)
+	if policy.maxAge < 0 {
+		policy.maxAge = 0
+	}
+	return policy
+}
+
+func selectionSetCachePolicy(schema *ast.Schema, selectionSet ast.SelectionSet, root bool, policy *cachePolicy) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name == "__typename" || selection.Definition == nil {
+				continue
+			}
+			maxAge, private, ok := fieldCacheHint(schema, selection.Definition)
+			if !ok && (root || len(selection.SelectionSet) > 0) {
+				maxAge, ok = 0, true
+			}
+			if ok && (policy.maxAge < 0 || maxAge < policy.maxAge) {
+				policy.maxAge = maxAge
+			}
+			policy.private = policy.private || private
+			selectionSetCachePolicy(schema, selection.SelectionSet, false, policy)
+		case *ast.InlineFragment:
+			selectionSetCachePolicy(schema, selection.SelectionSet, root, policy)
+		case *ast.FragmentSpread:
+			selectionSetCachePolicy(schema, selection.Definition.SelectionSet, root, policy)
+		}
+	}
+}
+
+// fieldCacheHint returns the @cacheControl hint of the field, or of its type
+// when the field has none
+func fieldCacheHint(schema *ast.Schema, field *ast.FieldDefinition) (int, bool, bool) {
+	d := field.Directives.ForName(cacheControlDirectiveName)
+	if d == nil {
+		if t := schema.Types[field.Type.Name()]; t != nil {
+			d = t.Directives.ForName(cacheControlDirectiveName)
+		}
+	}
+	if d == nil {
+		return 0, false, false
+	}
+
+	scope, _ := directiveArgument(d, "scope").(string)
+	private := scope == cacheControlScopePrivate
+	maxAge, ok := intArgument(directiveArgument(d, "maxAge"))
+	if !ok {
+		// a hint without max age only sets the scope
+		return 0, private, false
+	}
+	return maxAge, private, true
+}
+
+// responseCacheKey returns the cache key of the operation response. The
+// operation must have its @skip and @include directives evaluated. Private
+// responses are cached per subject.
+func responseCacheKey(operation *ast.OperationDefinition, variables map[string]interface{}, perms *OperationPermissions, subject string) string {
+	h := sha256.New()
+	io.WriteString(h, operationSignature(operation))
+	io.WriteString(h, "\nvariables:")
+	json.NewEncoder(h).Encode(variables)
+	if perms != nil {
+		io.WriteString(h, "\npermissions:")
+		json.NewEncoder(h).Encode(perms)
+	}
+	if subject != "" {
+		io.WriteString(h, "\nsubject:")
+		io.WriteString(h, subject)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachePolicyHolder holds the cache policy of the operation executed for a
+// request
+type cachePolicyHolder struct {
+	mutex  sync.Mutex
+	policy *cachePolicy
+}
+
+// setCachePolicy sets the cache policy used for the Cache-Control header of
+// the response
+func setCachePolicy(ctx context.Context, policy cachePolicy) {
+	holder, ok := ctx.Value(cachePolicyContextKey).(*cachePolicyHolder)
+	if !ok {
+		return
+	}
+	holder.mutex.Lock()
+	holder.policy = &policy
+	holder.mutex.Unlock()
+}
+
+// cacheControlMiddleware sets the Cache-Control header of the response from
+// the cache policy of the executed operation. The header is not set if no
+// policy was computed, e.g. for mutations.
+func cacheControlMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		holder := &cachePolicyHolder{}
+		setHeader := func() {
+			holder.mutex.Lock()
+			defer holder.mutex.Unlock()
+			if holder.policy != nil && w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", holder.policy.header())
+			}
+		}
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					setHeader()
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					setHeader()
+					return next(b)
+				}
+			},
+		})
+		ctx := context.WithValue(r.Context(), cachePolicyContextKey, holder)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,231 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const cacheControlSchema = `
+directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT | INTERFACE | UNION
+
+enum CacheControlScope {
+	PUBLIC
+	PRIVATE
+}
+
+type Cinema @cacheControl(maxAge: 30) {
+	id: ID!
+	name: String!
+}
+
+type Movie @cacheControl(maxAge: 60) {
+	id: ID!
+	title: String!
+	cinemas: [Cinema!]!
+	rating: Float! @cacheControl(maxAge: 10)
+	watched: Boolean! @cacheControl(scope: PRIVATE)
+	comments: [String!]! @cacheControl(maxAge: 0)
+}
+
+type User {
+	name: String!
+}
+
+type Query {
+	movie(id: ID!): Movie
+	me: User @cacheControl(maxAge: 20, scope: PRIVATE)
+	user: User
+	genres: [String!]! @cacheControl(maxAge: 300)
+	version: String!
+}`
+
+func TestOperationCachePolicy(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Name: "fixture", Input: cacheControlSchema})
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		policy cachePolicy
+	}{
+		{
+			name:   "type hint",
+			query:  `{ movie(id: "1") { id title } }`,
+			policy: cachePolicy{maxAge: 60},
+		},
+		{
+			name:   "field hint overrides the type hint",
+			query:  `{ movie(id: "1") { title rating } }`,
+			policy: cachePolicy{maxAge: 10},
+		},
+		{
+			name:   "minimum across nested types",
+			query:  `{ movie(id: "1") { title cinemas { name } } genres }`,
+			policy: cachePolicy{maxAge: 30},
+		},
+		{
+			name:   "fragments",
+			query:  `{ movie(id: "1") { ...F } } fragment F on Movie { cinemas { name } }`,
+			policy: cachePolicy{maxAge: 30},
+		},
+		{
+			name:   "scope without max age",
+			query:  `{ movie(id: "1") { title watched } }`,
+			policy: cachePolicy{maxAge: 60, private: true},
+		},
+		{
+			name:   "private root field",
+			query:  `{ me { name } }`,
+			policy: cachePolicy{maxAge: 20, private: true},
+		},
+		{
+			name:   "root field without hint",
+			query:  `{ version genres }`,
+			policy: cachePolicy{maxAge: 0},
+		},
+		{
+			name:   "composite field without hint",
+			query:  `{ user { name } }`,
+			policy: cachePolicy{maxAge: 0},
+		},
+		{
+			name:   "explicit zero max age",
+			query:  `{ movie(id: "1") { comments } }`,
+			policy: cachePolicy{maxAge: 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			query := gqlparser.MustLoadQuery(schema, tc.query)
+			assert.Equal(t, tc.policy, operationCachePolicy(schema, query.Operations[0]))
+		})
+	}
+}
+
+func TestCachePolicyHeader(t *testing.T) {
+	assert.Equal(t, "no-store", cachePolicy{}.header())
+	assert.Equal(t, "max-age=60, public", cachePolicy{maxAge: 60}.header())
+	assert.Equal(t, "max-age=20, private", cachePolicy{maxAge: 20, private: true}.header())
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls int32
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: cacheControlSchema,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&calls, 1)
+					w.Write([]byte(`{"data": {"genres": ["action", "drama"], "me": {"name": "Jane"}, "version": "1.0"}}`))
+				}),
+			},
+		},
+	}
+	es := f.setup(t)
+	es.ResponseCache = NewMemoryResponseCacheStore(10)
+
+	execute := func(t *testing.T, query, subject string) (string, string) {
+		operation := gqlparser.MustLoadQuery(f.mergedSchema, query).Operations[0]
+		holder := &cachePolicyHolder{}
+		ctx := testContextWithVariables(map[string]interface{}{}, operation)
+		ctx = context.WithValue(ctx, cachePolicyContextKey, holder)
+		if subject != "" {
+			ctx = AddSubjectToContext(ctx, subject)
+		}
+		resp := es.ExecuteQuery(ctx)
+		require.Empty(t, resp.Errors)
+		require.NotNil(t, holder.policy)
+		return string(resp.Data), holder.policy.header()
+	}
+
+	hits := testutil.ToFloat64(promResponseCacheCounter.WithLabelValues("hit"))
+
+	t.Run("public response", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 3; i++ {
+			data, header := execute(t, `{ genres }`, "")
+			assert.JSONEq(t, `{"genres": ["action", "drama"]}`, data)
+			assert.Equal(t, "max-age=300, public", header)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		assert.Equal(t, hits+2, testutil.ToFloat64(promResponseCacheCounter.WithLabelValues("hit")))
+	})
+
+	t.Run("remaining max age", func(t *testing.T) {
+		store := NewMemoryResponseCacheStore(10)
+		es.ResponseCache = store
+		execute(t, `{ genres }`, "")
+		atomic.StoreInt32(&calls, 0)
+		key := store.(*memoryResponseCacheStore).cache.Keys()[0]
+		es.ResponseCache.Set(context.Background(), key, encodeCachedResponse([]byte(`{"genres": ["comedy"]}`), time.Now().Add(-100*time.Second)), time.Minute)
+
+		data, header := execute(t, `{ genres }`, "")
+		assert.JSONEq(t, `{"genres": ["comedy"]}`, data)
+		assert.Equal(t, "max-age=200, public", header)
+		assert.Zero(t, atomic.LoadInt32(&calls))
+
+		es.ResponseCache.Set(context.Background(), key, encodeCachedResponse([]byte(`{"genres": ["comedy"]}`), time.Now().Add(-300*time.Second)), time.Minute)
+		data, header = execute(t, `{ genres }`, "")
+		assert.JSONEq(t, `{"genres": ["action", "drama"]}`, data, "responses older than their max age are not used")
+		assert.Equal(t, "max-age=300, public", header)
+	})
+
+	t.Run("private response", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		execute(t, `{ me { name } }`, "")
+		_, header := execute(t, `{ me { name } }`, "")
+		assert.Equal(t, "max-age=20, private", header)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "private responses are not cached without subject")
+
+		execute(t, `{ me { name } }`, "user-1")
+		execute(t, `{ me { name } }`, "user-1")
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+		execute(t, `{ me { name } }`, "user-2")
+		assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "private responses are cached per subject")
+	})
+
+	t.Run("uncacheable response", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		execute(t, `{ version }`, "")
+		_, header := execute(t, `{ version }`, "")
+		assert.Equal(t, "no-store", header)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestMemoryResponseCacheStoreExpiry(t *testing.T) {
+	store := NewMemoryResponseCacheStore(10)
+	store.Set(context.Background(), "expired", []byte("a"), -time.Second)
+	store.Set(context.Background(), "valid", []byte("b"), time.Minute)
+
+	_, ok := store.Get(context.Background(), "expired")
+	assert.False(t, ok)
+	response, ok := store.Get(context.Background(), "valid")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("b"), response)
+}
+
+func TestCacheControlMiddleware(t *testing.T) {
+	handler := cacheControlMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setCachePolicy(r.Context(), cachePolicy{maxAge: 60})
+		w.Write([]byte(`{}`))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", nil))
+	assert.Equal(t, "max-age=60, public", rec.Header().Get("Cache-Control"))
+
+	handler = cacheControlMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/query", nil))
+	assert.Empty(t, rec.Header().Get("Cache-Control"), "no header without policy")
+}
@@ -0,0 +1,173 @@
+package bramble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	defaultRetryInitialBackoff = 50 * time.Millisecond
+	defaultRetryMaxBackoff     = time.Second
+
+	retryAttemptAttribute = "graphql.request.attempt"
+)
+
+var defaultRetryableStatusCodes = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures how failed downstream requests are retried.
+// Requests are retried on network errors and on the retryable status codes,
+// GraphQL errors are never retried. Mutations are only retried if
+// RetryMutations is set.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per request, 0 or 1
+	// disables retries
+	MaxAttempts int `json:"max-attempts"`
+	// InitialBackoff is the delay before the first retry, defaults to 50ms.
+	// The delay doubles for every retry, with jitter.
+	InitialBackoff         string        `json:"initial-backoff"`
+	InitialBackoffDuration time.Duration `json:"-"`
+	// MaxBackoff is the maximum delay between two attempts, defaults to 1s
+	MaxBackoff         string        `json:"max-backoff"`
+	MaxBackoffDuration time.Duration `json:"-"`
+	// RetryableStatusCodes are the HTTP status codes that are retried,
+	// defaults to 502, 503 and 504
+	RetryableStatusCodes []int `json:"retryable-status-codes"`
+	// RetryMutations enables retries for mutation requests
+	RetryMutations bool `json:"retry-mutations"`
+}
+
+func (p *RetryPolicy) load() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max attempts %d", p.MaxAttempts)
+	}
+	var err error
+	if p.InitialBackoff != "" {
+		p.InitialBackoffDuration, err = time.ParseDuration(p.InitialBackoff)
+		if err != nil {
+			return fmt.Errorf("invalid initial backoff: %w", err)
+		}
+	}
+	if p.MaxBackoff != "" {
+		p.MaxBackoffDuration, err = time.ParseDuration(p.MaxBackoff)
+		if err != nil {
+			return fmt.Errorf("invalid max backoff: %w", err)
+		}
+	}
+	if p.maxBackoff() < p.initialBackoff() {
+		return fmt.Errorf("max backoff %s is shorter than initial backoff %s", p.maxBackoff(), p.initialBackoff())
+	}
+	return nil
+}
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p.InitialBackoffDuration <= 0 {
+		return defaultRetryInitialBackoff
+	}
+	return p.InitialBackoffDuration
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoffDuration <= 0 {
+		return defaultRetryMaxBackoff
+	}
+	return p.MaxBackoffDuration
+}
+
+func (p *RetryPolicy) retryableStatusCodes() []int {
+	if p.RetryableStatusCodes == nil {
+		return defaultRetryableStatusCodes
+	}
+	return p.RetryableStatusCodes
+}
+
+// enabled returns whether the request can be retried. Multipart requests
+// are not retried as their files can only be read once.
+func (p *RetryPolicy) enabled(request *Request) bool {
+	if p == nil || p.MaxAttempts <= 1 || request.isMultipart() {
+		return false
+	}
+	return request.OperationType != "mutation" || p.RetryMutations
+}
+
+// retryable returns whether the error of an attempt can be retried
+func (p *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *unexpectedStatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(p.retryableStatusCodes(), statusErr.code)
+	}
+	// errors returned by the HTTP client are network errors
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff returns the delay before the given retry, starting at 1. The delay
+// is between half and the full exponential backoff.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.maxBackoff()
+	if shift := retry - 1; shift < 32 {
+		d = min(p.initialBackoff()<<shift, d)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// unexpectedStatusError is returned for responses with a status other than
+// 200 OK
+type unexpectedStatusError struct {
+	code   int
+	status string
+}
+
+func (e *unexpectedStatusError) Error() string {
+	return fmt.Sprintf("unexpected response code: %s", e.status)
+}
+
+// requestWithRetries sends the request until it succeeds, fails with an
+// error that cannot be retried or the attempts are exhausted. No retry is
+// attempted if the backoff would exceed the deadline of the context.
+func (c *GraphQLClient) requestWithRetries(ctx context.Context, url string, request *Request, out interface{}) (int, error) {
+	policy := c.RetryPolicy
+	for attempt := 1; ; attempt++ {
+		attemptCtx, span := c.tracer.Start(ctx, "GraphQL Request Attempt")
+		span.SetAttributes(attribute.Int(retryAttemptAttribute, attempt))
+		err := c.send(attemptCtx, url, request, out)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(ctx, err) {
+			return attempt, err
+		}
+
+		backoff := policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return attempt, err
+		}
+
+		promServiceRetryCounter.WithLabelValues(url).Inc()
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlakyService returns a service failing with the given status code until
+// it received the given number of requests
+func newFlakyService(t *testing.T, failures int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"data": {"test": "value"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestClientRetries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoffDuration: time.Millisecond, MaxBackoffDuration: time.Millisecond}
+
+	t.Run("retryable status code", func(t *testing.T) {
+		srv, calls := newFlakyService(t, 2, http.StatusServiceUnavailable)
+		retries := testutil.ToFloat64(promServiceRetryCounter.WithLabelValues(srv.URL))
+
+		c := NewClient(WithRetryPolicy(policy))
+		var res map[string]interface{}
+		err := c.Request(context.Background(), srv.URL, NewRequest("{ test }").WithOperationType("query"), &res)
+		require.NoError(t, err)
+		assert.Equal(t, "value", res["test"])
+		assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+		assert.Equal(t, retries+2, testutil.ToFloat64(promServiceRetryCounter.WithLabelValues(srv.URL)))
+	})
+
+	t.Run("attempts are exhausted", func(t *testing.T) {
+		srv, calls := newFlakyService(t, 5, http.StatusBadGateway)
+		c := NewClient(WithRetryPolicy(policy))
+		err := c.Request(context.Background(), srv.URL, NewRequest("{ test }"), nil)
+		assert.EqualError(t, err, "unexpected response code: 502 Bad Gateway")
+		assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+	})
+
+	t.Run("status code not retryable", func(t *testing.T) {
+		srv, calls := newFlakyService(t, 1, http.StatusInternalServerError)
+		c := NewClient(WithRetryPolicy(policy))
+		err := c.Request(context.Background(), srv.URL, NewRequest("{ test }"), nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		srv, _ := newFlakyService(t, 0, http.StatusOK)
+		url := srv.URL
+		srv.Close()
+		retries := testutil.ToFloat64(promServiceRetryCounter.WithLabelValues(url))
+
+		c := NewClient(WithRetryPolicy(policy))
+		err := c.Request(context.Background(), url, NewRequest("{ test }"), nil)
+		assert.Error(t, err)
+		assert.Equal(t, retries+2, testutil.ToFloat64(promServiceRetryCounter.WithLabelValues(url)))
+	})
+
+	t.Run("mutations are not retried by default", func(t *testing.T) {
+		srv, calls := newFlakyService(t, 1, http.StatusServiceUnavailable)
+		c := NewClient(WithRetryPolicy(policy))
+		err := c.Request(context.Background(), srv.URL, NewRequest("mutation { test }").WithOperationType("mutation"), nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+		mutationPolicy := policy
+		mutationPolicy.RetryMutations = true
+		c = NewClient(WithRetryPolicy(mutationPolicy))
+		err = c.Request(context.Background(), srv.URL, NewRequest("mutation { test }").WithOperationType("mutation"), nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("backoff exceeding the deadline", func(t *testing.T) {
+		srv, calls := newFlakyService(t, 1, http.StatusServiceUnavailable)
+		c := NewClient(WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoffDuration: time.Minute, MaxBackoffDuration: time.Minute}))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := c.Request(ctx, srv.URL, NewRequest("{ test }"), nil)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+	})
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoffDuration: 100 * time.Millisecond, MaxBackoffDuration: time.Second}
+	for retry, upper := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 100: time.Second} {
+		backoff := policy.backoff(retry)
+		assert.GreaterOrEqual(t, backoff, upper/2)
+		assert.LessOrEqual(t, backoff, upper)
+	}
+}
+
+func TestRetryPolicyLoad(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: "10ms"}
+	require.NoError(t, policy.load())
+	assert.Equal(t, 10*time.Millisecond, policy.initialBackoff())
+	assert.Equal(t, time.Second, policy.maxBackoff())
+
+	assert.Error(t, (&RetryPolicy{InitialBackoff: "soon"}).load())
+	assert.Error(t, (&RetryPolicy{InitialBackoff: "2s", MaxBackoff: "1s"}).load())
+	assert.Error(t, (&RetryPolicy{MaxAttempts: -1}).load())
+}
@@ -15,6 +15,8 @@ const (
 	serviceRootFieldName   = "service"
 	boundaryDirectiveName  = "boundary"
 	namespaceDirectiveName = "namespace"
+	deferDirectiveName     = "defer"
+	streamDirectiveName    = "stream"
 
 	queryObjectName        = "Query"
 	mutationObjectName     = "Mutation"
@@ -27,6 +29,10 @@ func isGraphQLBuiltinName(s string) bool {
 	return strings.HasPrefix(s, "__")
 }
 
+func isRootObjectName(s string) bool {
+	return s == queryObjectName || s == mutationObjectName || s == subscriptionObjectName
+}
+
 func isIDType(t *ast.Type) bool {
 	return isNonNullableTypeNamed(t, "ID")
 }
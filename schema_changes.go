@@ -0,0 +1,358 @@
+package bramble
+
+import (
+	"fmt"
+	log "log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// SchemaChangePolicy defines how the breaking changes introduced by a service
+// schema update are handled
+type SchemaChangePolicy string
+
+const (
+	// SchemaChangesLog applies all the changes, breaking changes are logged
+	SchemaChangesLog SchemaChangePolicy = "log"
+	// SchemaChangesReject quarantines the services whose update introduces
+	// breaking changes
+	SchemaChangesReject SchemaChangePolicy = "reject"
+	// SchemaChangesAllowUnused only applies breaking changes to types and
+	// fields that were not used during the usage window
+	SchemaChangesAllowUnused SchemaChangePolicy = "allow-unused"
+)
+
+// SchemaChangesConfig configures the checks of the merged schema changes
+type SchemaChangesConfig struct {
+	// Policy defines how breaking changes are handled, defaults to
+	// SchemaChangesLog
+	Policy SchemaChangePolicy `json:"policy"`
+	// UsageWindow is how long a type or field must have been unused for a
+	// breaking change to be applied with the allow-unused policy
+	UsageWindow         string        `json:"usage-window"`
+	UsageWindowDuration time.Duration `json:"-"`
+}
+
+func (c *SchemaChangesConfig) load() error {
+	switch c.Policy {
+	case "", SchemaChangesLog, SchemaChangesReject, SchemaChangesAllowUnused:
+	default:
+		return fmt.Errorf("invalid policy %q", c.Policy)
+	}
+	if c.UsageWindow != "" {
+		var err error
+		c.UsageWindowDuration, err = time.ParseDuration(c.UsageWindow)
+		if err != nil {
+			return fmt.Errorf("invalid usage window: %w", err)
+		}
+	}
+	if c.Policy == SchemaChangesAllowUnused && c.UsageWindowDuration <= 0 {
+		return fmt.Errorf("the allow-unused policy requires a positive usage window")
+	}
+	return nil
+}
+
+// SchemaChangeSeverity classifies the changes to the merged schema
+type SchemaChangeSeverity string
+
+const (
+	// SchemaChangeBreaking changes break existing operations, e.g. removed
+	// fields
+	SchemaChangeBreaking SchemaChangeSeverity = "breaking"
+	// SchemaChangeDangerous changes can break clients not expecting them,
+	// e.g. new enum values
+	SchemaChangeDangerous SchemaChangeSeverity = "dangerous"
+	// SchemaChangeSafe changes don't affect existing operations, e.g. new
+	// fields
+	SchemaChangeSafe SchemaChangeSeverity = "safe"
+)
+
+// SchemaChange is a difference between two merged schemas
+type SchemaChange struct {
+	Severity SchemaChangeSeverity `json:"severity"`
+	// Coordinate is the schema coordinate of the changed element, e.g.
+	// "Movie.title" or "Query.movie(id:)"
+	Coordinate string `json:"coordinate"`
+	Message    string `json:"message"`
+
+	// usage is the coordinate whose usage is checked by the allow-unused
+	// policy
+	usage string
+}
+
+// diffSchemas returns the changes between the old and new merged schemas,
+// sorted by coordinate
+func diffSchemas(oldSchema, newSchema *ast.Schema) []SchemaChange {
+	var d schemaDiff
+	for name, oldType := range oldSchema.Types {
+		if oldType.BuiltIn || isGraphQLBuiltinName(name) {
+			continue
+		}
+		newType := newSchema.Types[name]
+		switch {
+		case newType == nil:
+			d.add(SchemaChangeBreaking, name, name, "type %s removed", name)
+		case newType.Kind != oldType.Kind:
+			d.add(SchemaChangeBreaking, name, name, "type %s changed from %s to %s", name, oldType.Kind, newType.Kind)
+		default:
+			d.diffType(oldType, newType)
+		}
+	}
+	for name, newType := range newSchema.Types {
+		if newType.BuiltIn || isGraphQLBuiltinName(name) {
+			continue
+		}
+		if oldSchema.Types[name] == nil {
+			d.add(SchemaChangeSafe, name, name, "type %s added", name)
+		}
+	}
+
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		if d.changes[i].Coordinate != d.changes[j].Coordinate {
+			return d.changes[i].Coordinate < d.changes[j].Coordinate
+		}
+		return d.changes[i].Message < d.changes[j].Message
+	})
+	return d.changes
+}
+
+type schemaDiff struct {
+	changes []SchemaChange
+}
+
+func (d *schemaDiff) add(severity SchemaChangeSeverity, coordinate, usage, format string, args ...interface{}) {
+	d.changes = append(d.changes, SchemaChange{
+		Severity:   severity,
+		Coordinate: coordinate,
+		Message:    fmt.Sprintf(format, args...),
+		usage:      usage,
+	})
+}
+
+func (d *schemaDiff) diffType(oldType, newType *ast.Definition) {
+	switch oldType.Kind {
+	case ast.Object, ast.Interface:
+		d.diffOutputFields(oldType, newType)
+		d.diffMembers(oldType.Name, "interface", oldType.Interfaces, newType.Interfaces)
+	case ast.Union:
+		d.diffMembers(oldType.Name, "member", oldType.Types, newType.Types)
+	case ast.Enum:
+		d.diffEnumValues(oldType, newType)
+	case ast.InputObject:
+		d.diffInputFields(oldType, newType)
+	}
+}
+
+func (d *schemaDiff) diffOutputFields(oldType, newType *ast.Definition) {
+	for _, oldField := range oldType.Fields {
+		if isGraphQLBuiltinName(oldField.Name) {
+			continue
+		}
+		coordinate := oldType.Name + "." + oldField.Name
+		newField := newType.Fields.ForName(oldField.Name)
+		if newField == nil {
+			d.add(SchemaChangeBreaking, coordinate, coordinate, "field %s removed", coordinate)
+			continue
+		}
+		if oldField.Type.String() != newField.Type.String() {
+			severity := SchemaChangeBreaking
+			if isSafeOutputTypeChange(oldField.Type, newField.Type) {
+				severity = SchemaChangeSafe
+			}
+			d.add(severity, coordinate, coordinate, "field %s changed type from %s to %s", coordinate, oldField.Type, newField.Type)
+		}
+		d.diffArguments(coordinate, oldField.Arguments, newField.Arguments)
+	}
+	for _, newField := range newType.Fields {
+		if isGraphQLBuiltinName(newField.Name) {
+			continue
+		}
+		if oldType.Fields.ForName(newField.Name) == nil {
+			coordinate := newType.Name + "." + newField.Name
+			d.add(SchemaChangeSafe, coordinate, coordinate, "field %s added", coordinate)
+		}
+	}
+}
+
+func (d *schemaDiff) diffArguments(field string, oldArgs, newArgs ast.ArgumentDefinitionList) {
+	for _, oldArg := range oldArgs {
+		coordinate := fmt.Sprintf("%s(%s:)", field, oldArg.Name)
+		newArg := newArgs.ForName(oldArg.Name)
+		if newArg == nil {
+			d.add(SchemaChangeBreaking, coordinate, coordinate, "argument %s removed", coordinate)
+			continue
+		}
+		if oldArg.Type.String() != newArg.Type.String() {
+			severity := SchemaChangeBreaking
+			if isSafeInputTypeChange(oldArg.Type, newArg.Type) {
+				severity = SchemaChangeSafe
+			}
+			d.add(severity, coordinate, coordinate, "argument %s changed type from %s to %s", coordinate, oldArg.Type, newArg.Type)
+		}
+		if valueString(oldArg.DefaultValue) != valueString(newArg.DefaultValue) {
+			d.add(SchemaChangeDangerous, coordinate, coordinate, "argument %s changed default value from %s to %s", coordinate, valueString(oldArg.DefaultValue), valueString(newArg.DefaultValue))
+		}
+	}
+	for _, newArg := range newArgs {
+		if oldArgs.ForName(newArg.Name) != nil {
+			continue
+		}
+		coordinate := fmt.Sprintf("%s(%s:)", field, newArg.Name)
+		if newArg.Type.NonNull && newArg.DefaultValue == nil {
+			// operations selecting the field don't provide the argument
+			d.add(SchemaChangeBreaking, coordinate, field, "required argument %s added", coordinate)
+		} else {
+			d.add(SchemaChangeDangerous, coordinate, field, "optional argument %s added", coordinate)
+		}
+	}
+}
+
+func (d *schemaDiff) diffInputFields(oldType, newType *ast.Definition) {
+	// input fields are not tracked individually, the usage of the input type
+	// is checked
+	for _, oldField := range oldType.Fields {
+		coordinate := oldType.Name + "." + oldField.Name
+		newField := newType.Fields.ForName(oldField.Name)
+		if newField == nil {
+			d.add(SchemaChangeBreaking, coordinate, oldType.Name, "input field %s removed", coordinate)
+			continue
+		}
+		if oldField.Type.String() != newField.Type.String() {
+			severity := SchemaChangeBreaking
+			if isSafeInputTypeChange(oldField.Type, newField.Type) {
+				severity = SchemaChangeSafe
+			}
+			d.add(severity, coordinate, oldType.Name, "input field %s changed type from %s to %s", coordinate, oldField.Type, newField.Type)
+		}
+	}
+	for _, newField := range newType.Fields {
+		if oldType.Fields.ForName(newField.Name) != nil {
+			continue
+		}
+		coordinate := newType.Name + "." + newField.Name
+		if newField.Type.NonNull && newField.DefaultValue == nil {
+			d.add(SchemaChangeBreaking, coordinate, newType.Name, "required input field %s added", coordinate)
+		} else {
+			d.add(SchemaChangeDangerous, coordinate, newType.Name, "optional input field %s added", coordinate)
+		}
+	}
+}
+
+func (d *schemaDiff) diffEnumValues(oldType, newType *ast.Definition) {
+	// enum values are not tracked individually, the usage of the enum is
+	// checked
+	for _, oldValue := range oldType.EnumValues {
+		if newType.EnumValues.ForName(oldValue.Name) == nil {
+			coordinate := oldType.Name + "." + oldValue.Name
+			d.add(SchemaChangeBreaking, coordinate, oldType.Name, "enum value %s removed", coordinate)
+		}
+	}
+	for _, newValue := range newType.EnumValues {
+		if oldType.EnumValues.ForName(newValue.Name) == nil {
+			coordinate := newType.Name + "." + newValue.Name
+			d.add(SchemaChangeDangerous, coordinate, newType.Name, "enum value %s added", coordinate)
+		}
+	}
+}
+
+// diffMembers compares the interfaces of an object or the members of a union
+func (d *schemaDiff) diffMembers(typeName, kind string, oldMembers, newMembers []string) {
+	for _, member := range oldMembers {
+		if !slices.Contains(newMembers, member) {
+			d.add(SchemaChangeBreaking, typeName, typeName, "%s %s removed from %s", kind, member, typeName)
+		}
+	}
+	for _, member := range newMembers {
+		if !slices.Contains(oldMembers, member) {
+			d.add(SchemaChangeDangerous, typeName, typeName, "%s %s added to %s", kind, member, typeName)
+		}
+	}
+}
+
+// isSafeOutputTypeChange returns whether the type of an output field can be
+// changed without breaking operations: fields can become non-null
+func isSafeOutputTypeChange(oldType, newType *ast.Type) bool {
+	if oldType.NonNull && !newType.NonNull {
+		return false
+	}
+	if oldType.Elem != nil {
+		return newType.Elem != nil && isSafeOutputTypeChange(oldType.Elem, newType.Elem)
+	}
+	return newType.Elem == nil && oldType.NamedType == newType.NamedType
+}
+
+// isSafeInputTypeChange returns whether the type of an argument or input
+// field can be changed without breaking operations: inputs can become
+// nullable
+func isSafeInputTypeChange(oldType, newType *ast.Type) bool {
+	if !oldType.NonNull && newType.NonNull {
+		return false
+	}
+	if oldType.Elem != nil {
+		return newType.Elem != nil && isSafeInputTypeChange(oldType.Elem, newType.Elem)
+	}
+	return newType.Elem == nil && oldType.NamedType == newType.NamedType
+}
+
+func valueString(value *ast.Value) string {
+	if value == nil {
+		return "none"
+	}
+	return value.String()
+}
+
+// checkSchemaChanges returns an error if the changes between the current and
+// candidate merged schemas are not allowed by the policy
+func (s *ExecutableSchema) checkSchemaChanges(current, candidate *ast.Schema) error {
+	if current == nil {
+		return nil
+	}
+	var rejected []string
+	switch s.schemaChanges.Policy {
+	case SchemaChangesReject:
+		for _, change := range diffSchemas(current, candidate) {
+			if change.Severity == SchemaChangeBreaking {
+				rejected = append(rejected, change.Message)
+			}
+		}
+	case SchemaChangesAllowUnused:
+		now := time.Now()
+		for _, change := range diffSchemas(current, candidate) {
+			if change.Severity == SchemaChangeBreaking && !s.usage.unused(change.usage, s.schemaChanges.UsageWindowDuration, now) {
+				rejected = append(rejected, change.Message)
+			}
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("breaking changes rejected: %s", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// logSchemaChanges records the changes applied to the merged schema
+func logSchemaChanges(services []string, changes []SchemaChange) {
+	if len(changes) == 0 {
+		return
+	}
+	counts := make(map[SchemaChangeSeverity]int)
+	for _, change := range changes {
+		counts[change.Severity]++
+		promSchemaChangesCounter.WithLabelValues(string(change.Severity)).Inc()
+	}
+	logger := log.With(
+		"services", services,
+		"breaking", counts[SchemaChangeBreaking],
+		"dangerous", counts[SchemaChangeDangerous],
+		"safe", counts[SchemaChangeSafe],
+		"changes", changes,
+	)
+	if counts[SchemaChangeBreaking] > 0 {
+		logger.Warn("schema changes applied")
+		return
+	}
+	logger.Info("schema changes applied")
+}
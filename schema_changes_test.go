@@ -0,0 +1,173 @@
+package bramble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	oldSchema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+	enum Genre { ACTION DRAMA }
+	input MovieFilter { genre: Genre title: String }
+	interface Node { id: ID! }
+	type Movie implements Node {
+		id: ID!
+		title: String!
+		rating: Float
+		genre: Genre
+		poster(size: Int = 100): String
+	}
+	type Show { id: ID! }
+	union Media = Movie | Show
+	type Query {
+		movie(id: ID!): Movie
+		movies(filter: MovieFilter): [Movie!]!
+		media: [Media!]
+	}`})
+	newSchema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+	enum Genre { ACTION COMEDY }
+	input MovieFilter { genre: Genre year: Int! }
+	interface Node { id: ID! }
+	type Movie {
+		id: ID!
+		title: String
+		rating: Float!
+		genre: Genre
+		poster(size: Int = 200, format: String): String
+		year: Int
+	}
+	type Show { id: ID! }
+	union Media = Show
+	type Query {
+		movie(id: ID, locale: String!): Movie
+		movies(filter: MovieFilter): [Movie!]!
+		media: [Media!]
+		shows: [Show!]
+	}`})
+
+	var changes []string
+	for _, change := range diffSchemas(oldSchema, newSchema) {
+		changes = append(changes, string(change.Severity)+": "+change.Message)
+	}
+	assert.Equal(t, []string{
+		"dangerous: enum value Genre.COMEDY added",
+		"breaking: enum value Genre.DRAMA removed",
+		"breaking: member Movie removed from Media",
+		"breaking: interface Node removed from Movie",
+		"dangerous: optional argument Movie.poster(format:) added",
+		"dangerous: argument Movie.poster(size:) changed default value from 100 to 200",
+		"safe: field Movie.rating changed type from Float to Float!",
+		"breaking: field Movie.title changed type from String! to String",
+		"safe: field Movie.year added",
+		"breaking: input field MovieFilter.title removed",
+		"breaking: required input field MovieFilter.year added",
+		"safe: argument Query.movie(id:) changed type from ID! to ID",
+		"breaking: required argument Query.movie(locale:) added",
+		"safe: field Query.shows added",
+	}, changes)
+}
+
+func TestSchemaChangesConfigLoad(t *testing.T) {
+	config := SchemaChangesConfig{Policy: SchemaChangesAllowUnused, UsageWindow: "24h"}
+	require.NoError(t, config.load())
+	assert.Equal(t, 24*time.Hour, config.UsageWindowDuration)
+
+	assert.Error(t, (&SchemaChangesConfig{Policy: "ignore"}).load())
+	assert.Error(t, (&SchemaChangesConfig{Policy: SchemaChangesAllowUnused}).load())
+	assert.Error(t, (&SchemaChangesConfig{UsageWindow: "a week"}).load())
+}
+
+func TestSchemaUsage(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+	enum Genre { ACTION DRAMA }
+	input MovieFilter { genre: Genre }
+	type Movie { id: ID! title: String! }
+	type Query { movies(filter: MovieFilter, limit: Int): [Movie!]! }`})
+	query := gqlparser.MustLoadQuery(schema, `query($filter: MovieFilter) { movies(filter: $filter) { ... on Movie { title } } }`)
+
+	usage := newSchemaUsage()
+	usage.record(schema, query.Operations[0])
+
+	now := time.Now()
+	assert.False(t, usage.unused("Query.movies(limit:)", time.Hour, now), "the window is not covered yet")
+
+	usage.since = now.Add(-2 * time.Hour)
+	for _, coordinate := range []string{"Query.movies", "Query.movies(filter:)", "MovieFilter", "Genre", "Movie", "Movie.title"} {
+		assert.False(t, usage.unused(coordinate, time.Hour, now), coordinate)
+	}
+	assert.True(t, usage.unused("Query.movies(limit:)", time.Hour, now))
+	assert.True(t, usage.unused("Movie.id", time.Hour, now))
+	assert.True(t, usage.unused("Movie.title", time.Hour, now.Add(2*time.Hour)), "the field was used before the window")
+}
+
+func TestSchemaUpdateWithSchemaChangePolicy(t *testing.T) {
+	const serviceType = `type Service {
+		name: String!
+		version: String!
+		schema: String!
+	}
+	`
+	movies := serviceType + `type Movie { id: ID! title: String! rating: Float } type Query { service: Service! movies: [Movie!]! }`
+
+	var schema atomic.Value
+	schema.Store(movies)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeServiceSchema(w, "movies", schema.Load().(string))
+	}))
+	defer srv.Close()
+
+	t.Run("reject", func(t *testing.T) {
+		schema.Store(movies)
+		service := NewService(srv.URL)
+		es := NewExecutableSchema(nil, 50, nil, service)
+		es.SetSchemaChanges(SchemaChangesConfig{Policy: SchemaChangesReject})
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+
+		schema.Store(serviceType + `type Movie { id: ID! title: String! } type Query { service: Service! movies: [Movie!]! }`)
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.True(t, service.Quarantined)
+		assert.Equal(t, "breaking changes rejected: field Movie.rating removed", service.QuarantineError)
+		assert.NotNil(t, es.MergedSchema.Types["Movie"].Fields.ForName("rating"))
+
+		safe := testutil.ToFloat64(promSchemaChangesCounter.WithLabelValues(string(SchemaChangeSafe)))
+		schema.Store(serviceType + `type Movie { id: ID! title: String! rating: Float year: Int } type Query { service: Service! movies: [Movie!]! }`)
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.False(t, service.Quarantined, "safe changes are applied")
+		assert.NotNil(t, es.MergedSchema.Types["Movie"].Fields.ForName("year"))
+		assert.Equal(t, safe+1, testutil.ToFloat64(promSchemaChangesCounter.WithLabelValues(string(SchemaChangeSafe))))
+	})
+
+	t.Run("allow unused", func(t *testing.T) {
+		schema.Store(movies)
+		service := NewService(srv.URL)
+		es := NewExecutableSchema(nil, 50, nil, service)
+		es.SetSchemaChanges(SchemaChangesConfig{Policy: SchemaChangesAllowUnused, UsageWindowDuration: time.Hour})
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+		es.usage.since = time.Now().Add(-2 * time.Hour)
+
+		query := gqlparser.MustLoadQuery(es.MergedSchema, `{ movies { title } }`)
+		es.usage.record(es.MergedSchema, query.Operations[0])
+
+		schema.Store(serviceType + `type Movie { id: ID! title: Int } type Query { service: Service! movies: [Movie!]! }`)
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.True(t, service.Quarantined)
+		assert.Equal(t, "breaking changes rejected: field Movie.title changed type from String! to Int", service.QuarantineError)
+
+		breaking := testutil.ToFloat64(promSchemaChangesCounter.WithLabelValues(string(SchemaChangeBreaking)))
+		schema.Store(serviceType + `type Movie { id: ID! title: String! } type Query { service: Service! movies: [Movie!]! }`)
+		require.NoError(t, es.UpdateSchema(context.Background(), false))
+		assert.False(t, service.Quarantined, "the unused field is removed")
+		assert.Nil(t, es.MergedSchema.Types["Movie"].Fields.ForName("rating"))
+		assert.Equal(t, breaking+1, testutil.ToFloat64(promSchemaChangesCounter.WithLabelValues(string(SchemaChangeBreaking))))
+	})
+}
@@ -0,0 +1,100 @@
+package bramble
+
+import (
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// schemaSnapshot is the state used to plan and execute an operation. A
+// snapshot is never modified once published: schema updates publish a new
+// snapshot and every operation keeps the snapshot it started with until it
+// completes.
+type schemaSnapshot struct {
+	schema          *ast.Schema
+	locations       FieldURLMap
+	isBoundary      map[string]bool
+	boundaryQueries BoundaryFieldsMap
+	// services are copies of the services at the time of the snapshot, the
+	// services are updated in place when polled
+	services    map[string]*Service
+	planCache   *planCache
+	entityCache *entityCache
+	usage       *schemaUsage
+}
+
+// SchemaState is the merged schema and the services at the last schema
+// update. It is shared and must not be modified.
+type SchemaState struct {
+	MergedSchema *ast.Schema
+	Locations    FieldURLMap
+	Services     map[string]*Service
+}
+
+// State returns the merged schema and the services at the last schema update.
+// Plugins must use it rather than the fields of the ExecutableSchema, which
+// are replaced and updated in place by the schema updates.
+func (s *ExecutableSchema) State() SchemaState {
+	snapshot := s.currentSnapshot()
+	return SchemaState{
+		MergedSchema: snapshot.schema,
+		Locations:    snapshot.locations,
+		Services:     snapshot.services,
+	}
+}
+
+// currentSnapshot returns the snapshot operations must be executed with
+func (s *ExecutableSchema) currentSnapshot() *schemaSnapshot {
+	if snapshot := s.snapshot.Load(); snapshot != nil {
+		return snapshot
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if snapshot := s.snapshot.Load(); snapshot != nil {
+		return snapshot
+	}
+	return s.publishSnapshot()
+}
+
+// publishSnapshot publishes a snapshot of the current state, it must be
+// called with the mutex held. The plan cache is not carried over: plans are
+// only valid for the snapshot they were built with.
+func (s *ExecutableSchema) publishSnapshot() *schemaSnapshot {
+	snapshot := s.newSnapshot()
+	if s.planCacheSize > 0 {
+		snapshot.planCache = newPlanCache(s.planCacheSize)
+	}
+	s.snapshot.Store(snapshot)
+	return snapshot
+}
+
+// refreshSnapshot publishes a snapshot with the current services when the
+// merged schema, locations and boundary maps didn't change, the cached plans
+// are still valid and are kept. It must be called with the mutex held.
+func (s *ExecutableSchema) refreshSnapshot() {
+	previous := s.snapshot.Load()
+	if previous == nil || previous.planCache == nil {
+		s.publishSnapshot()
+		return
+	}
+	snapshot := s.newSnapshot()
+	snapshot.planCache = previous.planCache
+	s.snapshot.Store(snapshot)
+}
+
+// newSnapshot returns a snapshot of the current state without plan cache
+func (s *ExecutableSchema) newSnapshot() *schemaSnapshot {
+	services := make(map[string]*Service, len(s.Services))
+	for url, svc := range s.Services {
+		service := *svc
+		services[url] = &service
+	}
+
+	return &schemaSnapshot{
+		schema:          s.MergedSchema,
+		locations:       s.Locations,
+		isBoundary:      s.IsBoundary,
+		boundaryQueries: s.BoundaryQueries,
+		services:        services,
+		entityCache:     s.entityCache,
+		usage:           s.usage,
+	}
+}
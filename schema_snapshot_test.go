@@ -0,0 +1,121 @@
+package bramble
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+)
+
+const snapshotQuery = `{ movies { title release } }`
+
+const snapshotResult = `{"movies": [
+	{"title": "Jurassic Park", "release": 1993},
+	{"title": "Alien", "release": 1979}
+]}`
+
+func TestSchemaUpdateWithQueryInFlight(t *testing.T) {
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	unblock := make(chan struct{})
+	releaseService := newIncrementalReleaseService(t, unblock)
+	defer releaseService.Close()
+
+	// signals the requests received by the release service
+	requests := make(chan struct{}, 10)
+	release := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		releaseService.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer release.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL), NewService(release.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	<-requests
+
+	query := gqlparser.MustLoadQuery(es.MergedSchema, snapshotQuery)
+	responses := make(chan string)
+	go func() {
+		resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+		assert.Empty(t, resp.Errors)
+		responses <- string(resp.Data)
+	}()
+	<-requests
+
+	updated := make(chan error)
+	go func() {
+		updated <- es.UpdateServices(context.Background(), []ServiceConfig{{URL: movieService.URL}})
+	}()
+	select {
+	case err := <-updated:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the schema update waited for the query in flight")
+	}
+	assert.Nil(t, es.Schema().Types["Movie"].Fields.ForName("release"))
+
+	close(unblock)
+	assert.JSONEq(t, snapshotResult, <-responses, "the query completes with the schema it started with")
+}
+
+func TestSchemaUpdateUnderConcurrentLoad(t *testing.T) {
+	movieService := newIncrementalMovieService(t)
+	defer movieService.Close()
+	unblock := make(chan struct{})
+	close(unblock)
+	releaseService := newIncrementalReleaseService(t, unblock)
+	defer releaseService.Close()
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL), NewService(releaseService.URL))
+	es.SetPlanCacheSize(10)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	query := gqlparser.MustLoadQuery(es.MergedSchema, snapshotQuery)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				resp := es.ExecuteQuery(testContextWithVariables(nil, query.Operations[0]))
+				assert.Empty(t, resp.Errors)
+				assert.JSONEq(t, snapshotResult, string(resp.Data))
+			}
+		}()
+	}
+	// the plugins read the state while it is updated
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			state := es.State()
+			for _, svc := range state.Services {
+				_ = svc.Name + svc.Status + svc.SchemaSource + svc.QuarantineError + svc.Config.Source
+			}
+			_, err := state.Locations.URLFor("Query", "", "movies")
+			assert.NoError(t, err)
+			assert.NotNil(t, state.MergedSchema.Query.Fields.ForName("movies"))
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			require.NoError(t, es.UpdateSchema(context.Background(), true))
+			continue
+		}
+		require.NoError(t, es.UpdateServices(context.Background(), []ServiceConfig{
+			{URL: movieService.URL, Name: fmt.Sprintf("movies-%d", i)},
+			{URL: releaseService.URL},
+		}))
+	}
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+package bramble
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// schemaUsage records when the types, fields and arguments of the merged
+// schema were last used by an operation. Usage is only known since the
+// tracking started.
+type schemaUsage struct {
+	since    time.Time
+	mutex    sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+func newSchemaUsage() *schemaUsage {
+	return &schemaUsage{
+		since:    time.Now(),
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// record marks the schema elements used by the operation
+func (u *schemaUsage) record(schema *ast.Schema, operation *ast.OperationDefinition) {
+	coordinates := make(map[string]struct{})
+	for _, variable := range operation.VariableDefinitions {
+		collectInputTypes(schema, variable.Type.Name(), coordinates)
+	}
+	collectSelectionSetUsage(schema, operation.SelectionSet, coordinates)
+
+	now := time.Now()
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for coordinate := range coordinates {
+		u.lastUsed[coordinate] = now
+	}
+}
+
+// unused returns whether the coordinate was not used during the window. Until
+// the tracking covers the whole window nothing is considered unused.
+func (u *schemaUsage) unused(coordinate string, window time.Duration, now time.Time) bool {
+	if u == nil || now.Sub(u.since) < window {
+		return false
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	lastUsed, ok := u.lastUsed[coordinate]
+	return !ok || now.Sub(lastUsed) >= window
+}
+
+func collectSelectionSetUsage(schema *ast.Schema, selectionSet ast.SelectionSet, coordinates map[string]struct{}) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if isGraphQLBuiltinName(selection.Name) || selection.Definition == nil || selection.ObjectDefinition == nil {
+				continue
+			}
+			field := selection.ObjectDefinition.Name + "." + selection.Name
+			coordinates[selection.ObjectDefinition.Name] = struct{}{}
+			coordinates[field] = struct{}{}
+			coordinates[selection.Definition.Type.Name()] = struct{}{}
+			for _, argument := range selection.Arguments {
+				coordinates[field+"("+argument.Name+":)"] = struct{}{}
+				if definition := selection.Definition.Arguments.ForName(argument.Name); definition != nil {
+					collectInputTypes(schema, definition.Type.Name(), coordinates)
+				}
+			}
+			collectSelectionSetUsage(schema, selection.SelectionSet, coordinates)
+		case *ast.InlineFragment:
+			if selection.TypeCondition != "" {
+				coordinates[selection.TypeCondition] = struct{}{}
+			}
+			collectSelectionSetUsage(schema, selection.SelectionSet, coordinates)
+		case *ast.FragmentSpread:
+			if selection.Definition == nil {
+				continue
+			}
+			coordinates[selection.Definition.TypeCondition] = struct{}{}
+			collectSelectionSetUsage(schema, selection.Definition.SelectionSet, coordinates)
+		}
+	}
+}
+
+// collectInputTypes marks the input type and the input types and enums it
+// references as used
+func collectInputTypes(schema *ast.Schema, typeName string, coordinates map[string]struct{}) {
+	if _, ok := coordinates[typeName]; ok {
+		return
+	}
+	coordinates[typeName] = struct{}{}
+	definition := schema.Types[typeName]
+	if definition == nil || definition.Kind != ast.InputObject {
+		return
+	}
+	for _, field := range definition.Fields {
+		collectInputTypes(schema, field.Type.Name(), coordinates)
+	}
+}
@@ -0,0 +1,148 @@
+package bramble
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultBoundaryBatchSize   = 50
+	defaultBoundaryParallelism = 4
+	defaultMaxPollBackoff      = 5 * time.Minute
+)
+
+// ServiceConfig is the configuration of a federated service. In the config
+// file a service is either its URL or an object, settings left empty use the
+// gateway defaults.
+type ServiceConfig struct {
+	URL string `json:"url"`
+	// Name is displayed instead of the name reported by the service
+	Name string `json:"name,omitempty"`
+	// Timeout of the requests to the service, overrides http-client-timeout
+	Timeout         string        `json:"timeout,omitempty"`
+	TimeoutDuration time.Duration `json:"-"`
+	// MaxResponseSize overrides max-service-response-size
+	MaxResponseSize int64 `json:"max-response-size,omitempty"`
+	// Headers are added to every request sent to the service
+	Headers map[string]string `json:"headers,omitempty"`
+	// BatchSize overrides boundary-batch-size
+	BatchSize int `json:"batch-size,omitempty"`
+	// ArrayBatchSize overrides boundary-array-batch-size
+	ArrayBatchSize int `json:"array-batch-size,omitempty"`
+	// Bulkhead overrides bulkhead for this service
+	Bulkhead *BulkheadConfig `json:"bulkhead,omitempty"`
+	// PollInterval overrides poll-interval for this service
+	PollInterval         string        `json:"poll-interval,omitempty"`
+	PollIntervalDuration time.Duration `json:"-"`
+	// MaxPollBackoff overrides max-poll-backoff for this service
+	MaxPollBackoff         string        `json:"max-poll-backoff,omitempty"`
+	MaxPollBackoffDuration time.Duration `json:"-"`
+	// VersionCheck overrides poll-version-check for this service
+	VersionCheck *bool `json:"version-check,omitempty"`
+	// Enabled can be set to false to remove the service without removing
+	// its configuration
+	Enabled *bool `json:"enabled,omitempty"`
+	// Source is the discovery that found the service, empty for the
+	// configured services
+	Source string `json:"-"`
+}
+
+// UnmarshalJSON accepts either a service URL or a service object. Settings
+// from a previous load are not kept.
+func (c *ServiceConfig) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '"' {
+		*c = ServiceConfig{}
+		return json.Unmarshal(data, &c.URL)
+	}
+	type plain ServiceConfig
+	var config plain
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	*c = ServiceConfig(config)
+	return nil
+}
+
+func (c *ServiceConfig) load() error {
+	if c.URL == "" {
+		return fmt.Errorf("missing service url")
+	}
+	var err error
+	if c.Timeout != "" {
+		c.TimeoutDuration, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for service %s: %w", c.URL, err)
+		}
+	}
+	if c.PollInterval != "" {
+		c.PollIntervalDuration, err = time.ParseDuration(c.PollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid poll interval for service %s: %w", c.URL, err)
+		}
+	}
+	if c.MaxPollBackoff != "" {
+		c.MaxPollBackoffDuration, err = time.ParseDuration(c.MaxPollBackoff)
+		if err != nil {
+			return fmt.Errorf("invalid max poll backoff for service %s: %w", c.URL, err)
+		}
+	}
+	if c.Bulkhead != nil {
+		if err := c.Bulkhead.load(); err != nil {
+			return fmt.Errorf("invalid bulkhead for service %s: %w", c.URL, err)
+		}
+	}
+	if c.MaxResponseSize < 0 || c.BatchSize < 0 || c.ArrayBatchSize < 0 {
+		return fmt.Errorf("invalid settings for service %s: sizes must not be negative", c.URL)
+	}
+	return nil
+}
+
+func (c ServiceConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// versionCheck returns whether the schema is only fetched when the version of
+// the service changed
+func (c ServiceConfig) versionCheck() bool {
+	return c.VersionCheck != nil && *c.VersionCheck
+}
+
+func (c ServiceConfig) maxPollBackoff() time.Duration {
+	if c.MaxPollBackoffDuration > 0 {
+		return c.MaxPollBackoffDuration
+	}
+	return defaultMaxPollBackoff
+}
+
+// apply sets the service settings on a request sent to the service
+func (c ServiceConfig) apply(req *Request) *Request {
+	if len(c.Headers) > 0 {
+		headers := req.Headers.Clone()
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		for name, value := range c.Headers {
+			headers.Set(name, value)
+		}
+		req.Headers = headers
+	}
+	if c.TimeoutDuration > 0 {
+		req.Timeout = c.TimeoutDuration
+	}
+	if c.MaxResponseSize > 0 {
+		req.MaxResponseSize = c.MaxResponseSize
+	}
+	return req
+}
+
+// serviceURLs returns the URLs of the services
+func serviceURLs(services []ServiceConfig) []string {
+	urls := make([]string, 0, len(services))
+	for _, s := range services {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
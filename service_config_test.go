@@ -0,0 +1,195 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestServiceConfigUnmarshal(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+		"services": [
+			"http://movies/query",
+			{
+				"url": "http://cinemas/query",
+				"name": "cinemas",
+				"timeout": "2s",
+				"max-response-size": 1024,
+				"headers": {"X-Api-Key": "secret"},
+				"batch-size": 10,
+				"poll-interval": "1m",
+				"enabled": false
+			}
+		]
+	}`), &cfg)
+	require.NoError(t, err)
+	require.Len(t, cfg.ServiceConfigs, 2)
+	assert.Equal(t, ServiceConfig{URL: "http://movies/query"}, cfg.ServiceConfigs[0])
+
+	cinemas := cfg.ServiceConfigs[1]
+	require.NoError(t, cinemas.load())
+	assert.Equal(t, "cinemas", cinemas.Name)
+	assert.Equal(t, 2*time.Second, cinemas.TimeoutDuration)
+	assert.Equal(t, int64(1024), cinemas.MaxResponseSize)
+	assert.Equal(t, map[string]string{"X-Api-Key": "secret"}, cinemas.Headers)
+	assert.Equal(t, 10, cinemas.BatchSize)
+	assert.Equal(t, time.Minute, cinemas.PollIntervalDuration)
+	assert.False(t, cinemas.enabled())
+
+	assert.Error(t, (&ServiceConfig{URL: "http://movies/query", Timeout: "soon"}).load())
+	assert.Error(t, (&ServiceConfig{}).load())
+}
+
+func TestBuildServiceList(t *testing.T) {
+	t.Setenv("BRAMBLE_SERVICE_LIST", "http://movies/query http://env/query")
+	disabled := false
+	cfg := &Config{
+		PollIntervalDuration: 10 * time.Second,
+		Bulkhead:             BulkheadConfig{MaxInFlight: 5},
+		ServiceConfigs: []ServiceConfig{
+			{URL: "http://movies/query", Timeout: "1s", Bulkhead: &BulkheadConfig{MaxInFlight: 1}},
+			{URL: "http://cinemas/query", PollInterval: "2s"},
+			{URL: "http://env/query", Enabled: &disabled},
+		},
+		Services: []string{"http://movies/query", "http://shows/query"},
+	}
+	services, err := cfg.buildServiceList()
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://movies/query", "http://cinemas/query", "http://shows/query"}, serviceURLs(services))
+	assert.Equal(t, time.Second, services[0].TimeoutDuration, "the service object takes precedence")
+	assert.Equal(t, 10*time.Second, services[0].PollIntervalDuration)
+	assert.Equal(t, 1, services[0].Bulkhead.MaxInFlight)
+	assert.Equal(t, 5, services[1].Bulkhead.MaxInFlight, "the global bulkhead is used by default")
+
+	cfg.ServiceConfigs = services
+	assert.Equal(t, 2*time.Second, cfg.schemaPollInterval())
+}
+
+func TestServiceConfigApply(t *testing.T) {
+	headers := http.Header{"X-Request-Id": []string{"1"}}
+	req := NewRequest("{ test }").WithHeaders(headers)
+	ServiceConfig{
+		Headers:         map[string]string{"X-Api-Key": "secret"},
+		TimeoutDuration: time.Second,
+		MaxResponseSize: 10,
+	}.apply(req)
+
+	assert.Equal(t, "secret", req.Headers.Get("X-Api-Key"))
+	assert.Equal(t, "1", req.Headers.Get("X-Request-Id"))
+	assert.Empty(t, headers.Get("X-Api-Key"), "the outgoing headers are not modified")
+	assert.Equal(t, time.Second, req.Timeout)
+	assert.Equal(t, int64(10), req.MaxResponseSize)
+}
+
+func TestQueryExecutionWithServiceConfig(t *testing.T) {
+	schema := `directive @boundary on OBJECT | FIELD_DEFINITION
+
+	type Movie @boundary {
+		id: ID!
+		title: String!
+	}
+
+	type Query {
+		movie(id: ID!): Movie @boundary
+	}`
+
+	var requests int32
+	f := &queryExecutionFixture{
+		services: []testService{
+			{
+				schema: `directive @boundary on OBJECT | FIELD_DEFINITION
+
+				type Movie @boundary {
+					id: ID!
+				}
+
+				type Query {
+					movies: [Movie!]!
+				}`,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(`{"data": {"movies": [
+						{"_bramble_id": "1", "_bramble__typename": "Movie", "id": "1"},
+						{"_bramble_id": "2", "_bramble__typename": "Movie", "id": "2"},
+						{"_bramble_id": "3", "_bramble__typename": "Movie", "id": "3"}
+					]}}`))
+				}),
+			},
+			{
+				schema: schema,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&requests, 1)
+					if r.Header.Get("X-Api-Key") != "secret" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					var req testRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					query := gqlparser.MustLoadQuery(gqlparser.MustLoadSchema(&ast.Source{Input: schema}), req.Query)
+					var movies []string
+					for _, s := range query.Operations[0].SelectionSet {
+						field := s.(*ast.Field)
+						id := req.argument(field, 0)
+						movies = append(movies, fmt.Sprintf(`"%s": {"_bramble_id": "%s", "_bramble__typename": "Movie", "title": "title %s"}`, field.Alias, id, id))
+					}
+					fmt.Fprintf(w, `{"data": {%s}}`, strings.Join(movies, ","))
+				}),
+			},
+		},
+		query: `{ movies { id title } }`,
+		expected: `{"movies": [
+			{"id": "1", "title": "title 1"},
+			{"id": "2", "title": "title 2"},
+			{"id": "3", "title": "title 3"}
+		]}`,
+	}
+	es := f.setup(t)
+	for _, svc := range es.Services {
+		if strings.Contains(svc.SchemaSource, "title") {
+			svc.Config.Headers = map[string]string{"X-Api-Key": "secret"}
+			svc.Config.BatchSize = 2
+		}
+	}
+
+	f.run(t, es, f.checkSuccess())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "the boundary lookups are sent in batches of 2")
+}
+
+func TestUpdateSchemaPollInterval(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"service": map[string]interface{}{
+					"name":    "reported-name",
+					"version": "1.0",
+					"schema":  `type Service { name: String! version: String! schema: String! } type Query { service: Service! test: String }`,
+				},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	svc := NewServiceFromConfig(ServiceConfig{URL: srv.URL, Name: "display-name", PollIntervalDuration: time.Hour})
+	es := NewExecutableSchema(nil, 50, nil, svc)
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, "display-name", svc.Name)
+	require.NotNil(t, es.MergedSchema.Query.Fields.ForName("test"))
+
+	require.NoError(t, es.UpdateSchema(context.Background(), false))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls), "the service is not polled before its interval elapsed")
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&polls))
+}
@@ -0,0 +1,370 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"golang.org/x/sync/errgroup"
+)
+
+// serviceRegistry records the services registered, deregistered, enabled and
+// disabled at runtime. The changes are applied on top of the configured
+// services, so they are kept when the configuration is reloaded.
+type serviceRegistry struct {
+	registered map[string]ServiceConfig
+	removed    map[string]bool
+	disabled   map[string]bool
+}
+
+func (r serviceRegistry) clone() serviceRegistry {
+	clone := serviceRegistry{
+		registered: maps.Clone(r.registered),
+		removed:    maps.Clone(r.removed),
+		disabled:   maps.Clone(r.disabled),
+	}
+	if clone.registered == nil {
+		clone.registered = make(map[string]ServiceConfig)
+	}
+	if clone.removed == nil {
+		clone.removed = make(map[string]bool)
+	}
+	if clone.disabled == nil {
+		clone.disabled = make(map[string]bool)
+	}
+	return clone
+}
+
+// apply returns the configured services with the runtime changes
+func (r serviceRegistry) apply(configured []ServiceConfig) []ServiceConfig {
+	var services []ServiceConfig
+	seen := make(map[string]bool)
+	for _, config := range configured {
+		seen[config.URL] = true
+		if registered, ok := r.registered[config.URL]; ok {
+			config = registered
+		}
+		if !r.removed[config.URL] && !r.disabled[config.URL] && config.enabled() {
+			services = append(services, config)
+		}
+	}
+	for _, url := range slices.Sorted(maps.Keys(r.registered)) {
+		if !seen[url] && !r.disabled[url] && r.registered[url].enabled() {
+			services = append(services, r.registered[url])
+		}
+	}
+	return services
+}
+
+// CompositionResult is the result of a runtime change of the services
+type CompositionResult struct {
+	// DryRun is set when the change was not applied
+	DryRun bool `json:"dryRun"`
+	// Success is set when the schemas of the services can be merged and, if
+	// the change is applied, the changed services are in the merged schema
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Changes are the changes to the merged schema
+	Changes []SchemaChange `json:"changes"`
+}
+
+// RegisterService adds a service or replaces the settings of a service
+func (s *ExecutableSchema) RegisterService(ctx context.Context, config ServiceConfig, dryRun bool) (CompositionResult, error) {
+	if err := config.load(); err != nil {
+		return CompositionResult{}, err
+	}
+	if s.serviceDefaults != nil {
+		config = s.serviceDefaults(config)
+	}
+	return s.changeServices(ctx, dryRun, func(r serviceRegistry) error {
+		r.registered[config.URL] = config
+		delete(r.removed, config.URL)
+		delete(r.disabled, config.URL)
+		return nil
+	})
+}
+
+// DeregisterService removes a service, identified by name or URL
+func (s *ExecutableSchema) DeregisterService(ctx context.Context, service string, dryRun bool) (CompositionResult, error) {
+	return s.changeServices(ctx, dryRun, func(r serviceRegistry) error {
+		url, err := s.resolveServiceURL(r, service)
+		if err != nil {
+			return err
+		}
+		delete(r.registered, url)
+		delete(r.disabled, url)
+		r.removed[url] = true
+		return nil
+	})
+}
+
+// EnableService enables or disables a service, identified by name or URL.
+// Disabled services are removed until they are enabled again.
+func (s *ExecutableSchema) EnableService(ctx context.Context, service string, enabled, dryRun bool) (CompositionResult, error) {
+	return s.changeServices(ctx, dryRun, func(r serviceRegistry) error {
+		url, err := s.resolveServiceURL(r, service)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			delete(r.disabled, url)
+		} else {
+			r.disabled[url] = true
+		}
+		return nil
+	})
+}
+
+// ReconcileServices drops the runtime changes, only the configured services
+// are used
+func (s *ExecutableSchema) ReconcileServices(ctx context.Context, dryRun bool) (CompositionResult, error) {
+	return s.changeServices(ctx, dryRun, func(r serviceRegistry) error {
+		clear(r.registered)
+		clear(r.removed)
+		clear(r.disabled)
+		return nil
+	})
+}
+
+// resolveServiceURL returns the URL of the service with the given name or URL
+func (s *ExecutableSchema) resolveServiceURL(r serviceRegistry, service string) (string, error) {
+	for url, svc := range s.Services {
+		if url == service || svc.Name == service {
+			return url, nil
+		}
+	}
+	// disabled services are not running, they are found by their settings
+	for _, config := range r.apply(s.configuredServices) {
+		if config.URL == service || config.Name == service {
+			return config.URL, nil
+		}
+	}
+	for url := range r.disabled {
+		if url == service {
+			return url, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errServiceNotFound, service)
+}
+
+// changeServices applies a change to a copy of the registry and merges the
+// schemas of the resulting services. The change is only recorded and applied
+// if the schemas can be merged, the new services are part of the merged
+// schema and it is not a dry run.
+func (s *ExecutableSchema) changeServices(ctx context.Context, dryRun bool, change func(serviceRegistry) error) (CompositionResult, error) {
+	for {
+		s.mutex.Lock()
+		revision := s.servicesRevision
+		registry := s.registry.clone()
+		if err := change(registry); err != nil {
+			s.mutex.Unlock()
+			return CompositionResult{}, err
+		}
+		services := s.buildServices(registry.apply(s.configuredServices))
+		var added []*Service
+		for url, svc := range services {
+			if s.Services[url] != svc {
+				added = append(added, svc)
+			}
+		}
+		s.mutex.Unlock()
+
+		// the new services are not in use yet, they are polled without
+		// holding the mutex so that the schema updates are not blocked
+		var group errgroup.Group
+		now := time.Now()
+		for _, svc := range added {
+			group.Go(func() error {
+				_ = svc.poll(ctx, now)
+				return nil
+			})
+		}
+		group.Wait()
+
+		if result, ok := s.applyServices(ctx, dryRun, revision, registry, services, added); ok {
+			return result, nil
+		}
+		// the services were replaced while the new ones were polled
+	}
+}
+
+// applyServices merges the schemas of the services and uses them if it is not
+// a dry run. It returns false if the services were replaced since the
+// revision.
+func (s *ExecutableSchema) applyServices(ctx context.Context, dryRun bool, revision int, registry serviceRegistry, services map[string]*Service, added []*Service) (CompositionResult, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.servicesRevision != revision {
+		return CompositionResult{}, false
+	}
+
+	result := CompositionResult{DryRun: dryRun, Changes: []SchemaChange{}}
+	schema, err := s.compose(services, added)
+	if err != nil {
+		result.Error = err.Error()
+		return result, true
+	}
+	if s.MergedSchema != nil {
+		result.Changes = append(result.Changes, diffSchemas(s.MergedSchema, schema)...)
+	}
+	if dryRun {
+		result.Success = true
+		return result, true
+	}
+
+	// the new services were just polled, the schema is rebuilt without
+	// polling the services again
+	noPoll := func(*Service, time.Time) bool { return false }
+	previousRegistry, previousServices := s.registry, s.Services
+	s.registry, s.Services = registry, services
+	s.servicesRevision++
+	err = s.pollAndMerge(ctx, true, noPoll)
+	if err == nil {
+		err = s.checkMerged(added)
+	}
+	if err != nil {
+		s.registry, s.Services = previousRegistry, previousServices
+		if err := s.pollAndMerge(ctx, true, noPoll); err != nil {
+			log.With("error", err).Error("failed restoring the services")
+		}
+		result.Error = err.Error()
+		return result, true
+	}
+	result.Success = true
+	return result, true
+}
+
+// checkMerged returns an error if one of the services is not part of the
+// merged schema, e.g. because its schema update failed or was quarantined
+func (s *ExecutableSchema) checkMerged(services []*Service) error {
+	locations := slices.Collect(maps.Values(s.Locations))
+	for _, svc := range services {
+		switch {
+		case svc.pollErr != nil:
+			return fmt.Errorf("failed updating service %s: %w", svc.ServiceURL, svc.pollErr)
+		case svc.Quarantined:
+			return fmt.Errorf("service %s quarantined: %s", svc.ServiceURL, svc.QuarantineError)
+		case !slices.Contains(locations, svc.ServiceURL):
+			return fmt.Errorf("service %s is not part of the merged schema", svc.ServiceURL)
+		}
+	}
+	return nil
+}
+
+// buildServices returns the services for the settings, services whose
+// settings didn't change are kept
+func (s *ExecutableSchema) buildServices(configs []ServiceConfig) map[string]*Service {
+	services := make(map[string]*Service, len(configs))
+	for _, config := range configs {
+		if svc, ok := s.Services[config.URL]; ok && reflect.DeepEqual(svc.Config, config) {
+			services[config.URL] = svc
+		} else {
+			services[config.URL] = NewServiceFromConfig(config, WithHTTPClient(s.GraphqlClient.HTTPClient))
+		}
+	}
+	return services
+}
+
+// compose merges the schemas of the services without applying it. The added
+// services use the schema they were just polled with, the others their
+// current schema. The new schemas are checked against the breaking changes
+// policy, as they are when polled.
+func (s *ExecutableSchema) compose(services map[string]*Service, added []*Service) (*ast.Schema, error) {
+	var schemas []*ast.Schema
+	for _, url := range slices.Sorted(maps.Keys(services)) {
+		svc := services[url]
+		if !slices.Contains(added, svc) {
+			if svc.Schema != nil {
+				schemas = append(schemas, svc.Schema)
+			}
+			continue
+		}
+		if svc.pollErr != nil {
+			return nil, fmt.Errorf("failed updating service %s: %w", url, svc.pollErr)
+		}
+		if svc.pending == nil {
+			return nil, fmt.Errorf("failed updating service %s: %s", url, svc.QuarantineError)
+		}
+		schemas = append(schemas, svc.pending.schema)
+	}
+	schema, err := MergeSchemas(schemas...)
+	if err != nil {
+		return nil, err
+	}
+	if len(added) > 0 {
+		if err := s.checkSchemaChanges(s.MergedSchema, schema); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// registryHandler returns the handler of a service registry change
+func (g *Gateway) registryHandler(change func(r *http.Request, dryRun bool) (CompositionResult, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry-run"))
+		result, err := change(r, dryRun)
+		switch {
+		case errors.Is(err, errServiceNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Success {
+			w.WriteHeader(http.StatusConflict)
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}
+}
+
+// setupServiceRegistry registers the service registry endpoints
+func (g *Gateway) setupServiceRegistry(mux *http.ServeMux) {
+	es := g.ExecutableSchema
+	mux.HandleFunc("GET /services", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(es.ServiceConfigs())
+	})
+	mux.HandleFunc("POST /services/register", g.registryHandler(func(r *http.Request, dryRun bool) (CompositionResult, error) {
+		var config ServiceConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			return CompositionResult{}, fmt.Errorf("invalid service: %w", err)
+		}
+		return es.RegisterService(r.Context(), config, dryRun)
+	}))
+	mux.HandleFunc("POST /services/deregister", g.registryHandler(func(r *http.Request, dryRun bool) (CompositionResult, error) {
+		return es.DeregisterService(r.Context(), r.URL.Query().Get("service"), dryRun)
+	}))
+	mux.HandleFunc("POST /services/enable", g.registryHandler(func(r *http.Request, dryRun bool) (CompositionResult, error) {
+		return es.EnableService(r.Context(), r.URL.Query().Get("service"), true, dryRun)
+	}))
+	mux.HandleFunc("POST /services/disable", g.registryHandler(func(r *http.Request, dryRun bool) (CompositionResult, error) {
+		return es.EnableService(r.Context(), r.URL.Query().Get("service"), false, dryRun)
+	}))
+	mux.HandleFunc("POST /services/reconcile", g.registryHandler(func(r *http.Request, dryRun bool) (CompositionResult, error) {
+		return es.ReconcileServices(r.Context(), dryRun)
+	}))
+}
+
+// ServiceConfigs returns the settings of the services in use, including the
+// runtime changes
+func (s *ExecutableSchema) ServiceConfigs() []ServiceConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	configs := make([]ServiceConfig, 0, len(s.Services))
+	for _, url := range slices.Sorted(maps.Keys(s.Services)) {
+		configs = append(configs, s.Services[url].Config)
+	}
+	return configs
+}
@@ -0,0 +1,153 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceRegistry(t *testing.T) {
+	movies := newPolledService(t, "movies", "movies: [String!]", nil)
+	shows := newPolledService(t, "shows", "shows: [String!]", nil)
+	conflicting := newPolledService(t, "films", "movies: [Int!]", nil)
+
+	es := NewExecutableSchema(nil, 50, nil, NewService(movies.URL))
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	router := NewGateway(es, nil).PrivateRouter()
+	send := func(path, body string) (*httptest.ResponseRecorder, CompositionResult) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var result CompositionResult
+		if rec.Header().Get("Content-Type") == "application/json" {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		}
+		return rec, result
+	}
+
+	rec, result := send("/services/register?dry-run=true", `{"url": "`+shows.URL+`"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, result.Success)
+	assert.True(t, result.DryRun)
+	require.Len(t, result.Changes, 1)
+	assert.Equal(t, "Query.shows", result.Changes[0].Coordinate)
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("shows"), "a dry run is not applied")
+	assert.Len(t, es.Services, 1)
+
+	rec, result = send("/services/register", `"`+shows.URL+`"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, result.Success)
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	rec, result = send("/services/register", `{"url": "`+conflicting.URL+`"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error, "movies")
+	assert.Len(t, es.Services, 2, "a change that can't be merged is not applied")
+
+	rec, _ = send("/services/register", `{"name": "books"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = send("/services/disable?service=shows", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	rec, _ = send("/services/enable?service="+shows.URL, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	rec, _ = send("/services/deregister?service=books", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = send("/services/deregister?service=movies", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+
+	require.NoError(t, es.UpdateServiceList(context.Background(), []string{movies.URL}))
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("movies"), "runtime changes are kept when the configuration is reloaded")
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	rec, _ = send("/services/reconcile", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+	assert.Nil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/services", nil))
+	assert.JSONEq(t, `[{"url": "`+movies.URL+`"}]`, rec.Body.String())
+}
+
+func TestServiceRegistryFailures(t *testing.T) {
+	movies := newPolledService(t, "movies", "movies: [String!]", nil)
+	es := NewExecutableSchema(nil, 50, nil, NewService(movies.URL))
+	es.SetSchemaChanges(SchemaChangesConfig{Policy: SchemaChangesReject})
+	require.NoError(t, es.UpdateSchema(context.Background(), true))
+	router := NewGateway(es, nil).PrivateRouter()
+	listed := func(url string) bool {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/services", nil))
+		return strings.Contains(rec.Body.String(), url)
+	}
+
+	t.Run("breaking change", func(t *testing.T) {
+		movies.SetQueryFields("films: [String!]")
+		defer movies.SetQueryFields("movies: [String!]")
+		result, err := es.RegisterService(context.Background(), ServiceConfig{URL: movies.URL, Name: "films"}, false)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error, "breaking changes rejected")
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+		assert.Equal(t, "", es.Services[movies.URL].Config.Name, "the previous settings are kept")
+		assert.False(t, listed(`"films"`))
+	})
+
+	t.Run("service not merged", func(t *testing.T) {
+		empty := newPolledService(t, "empty", "", nil)
+		result, err := es.RegisterService(context.Background(), ServiceConfig{URL: empty.URL}, false)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Error, "is not part of the merged schema")
+		assert.False(t, listed(empty.URL), "the change is not recorded")
+		assert.NotContains(t, es.Services, empty.URL)
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("movies"))
+	})
+
+	t.Run("new services are polled once", func(t *testing.T) {
+		shows := newPolledService(t, "shows", "shows: [String!]", nil)
+		moviesPolls := movies.Polls.Load()
+		result, err := es.RegisterService(context.Background(), ServiceConfig{URL: shows.URL}, false)
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Equal(t, int32(1), shows.Polls.Load())
+		assert.Equal(t, moviesPolls, movies.Polls.Load(), "the other services are not polled")
+		assert.True(t, listed(shows.URL))
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("shows"))
+	})
+
+	t.Run("schema updates are not blocked by a registration", func(t *testing.T) {
+		polled, release := make(chan struct{}), make(chan struct{})
+		slow := newPolledService(t, "slow", "slow: [String!]", nil)
+		blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(polled)
+			<-release
+			slow.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer blocking.Close()
+
+		results := make(chan CompositionResult)
+		go func() {
+			result, _ := es.RegisterService(context.Background(), ServiceConfig{URL: blocking.URL}, false)
+			results <- result
+		}()
+		<-polled
+		require.NoError(t, es.UpdateSchema(context.Background(), true))
+		close(release)
+		assert.True(t, (<-results).Success)
+		assert.NotNil(t, es.MergedSchema.Query.Fields.ForName("slow"))
+	})
+}
@@ -0,0 +1,469 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/gorilla/websocket"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const graphqlTransportWSProtocol = "graphql-transport-ws"
+
+// graphql-transport-ws message types
+const (
+	wsConnectionInitMsg = "connection_init"
+	wsConnectionAckMsg  = "connection_ack"
+	wsPingMsg           = "ping"
+	wsPongMsg           = "pong"
+	wsSubscribeMsg      = "subscribe"
+	wsNextMsg           = "next"
+	wsErrorMsg          = "error"
+	wsCompleteMsg       = "complete"
+)
+
+// subscriptionID is the id used for the single operation multiplexed on each
+// downstream connection.
+const subscriptionID = "1"
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SubscriptionEvent is a single event received from a downstream
+// subscription. Err is set if the service returned errors for this event or
+// if the subscription failed.
+type SubscriptionEvent struct {
+	Data map[string]interface{}
+	Err  error
+}
+
+// Subscribe starts a subscription on the downstream service using the
+// graphql-transport-ws protocol. Events are sent on the returned channel until
+// the service completes the subscription, the connection fails or the context
+// is cancelled, the channel is then closed.
+func (c *GraphQLClient) Subscribe(ctx context.Context, url string, request *Request) (<-chan SubscriptionEvent, error) {
+	ctx, span := c.tracer.Start(ctx, "GraphQL Subscription",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.GraphqlOperationTypeKey.String(string(request.OperationType)),
+			semconv.GraphqlOperationName(request.OperationName),
+			semconv.GraphqlDocument(request.Query),
+		),
+	)
+
+	// once started, the span ends with the subscription
+	streaming := false
+	defer func() {
+		if !streaming {
+			span.End()
+		}
+	}()
+
+	traceErr := func(err error) error {
+		if err == nil {
+			return err
+		}
+
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	wsURL, err := websocketURL(url)
+	if err != nil {
+		return nil, traceErr(err)
+	}
+
+	header := http.Header{}
+	if request.Headers != nil {
+		header = request.Headers.Clone()
+	}
+	if c.UserAgent != "" {
+		header.Set("User-Agent", c.UserAgent)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.HTTPClient.Timeout,
+		Subprotocols:     []string{graphqlTransportWSProtocol},
+	}
+	conn, res, err := dialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		if res != nil {
+			return nil, traceErr(fmt.Errorf("unexpected response code: %s", res.Status))
+		}
+		return nil, traceErr(err)
+	}
+	if c.MaxResponseSize > 0 {
+		conn.SetReadLimit(c.MaxResponseSize)
+	}
+
+	sub := &subscriptionConn{conn: conn}
+	if err := sub.init(request, c.HTTPClient.Timeout); err != nil {
+		conn.Close()
+		return nil, traceErr(err)
+	}
+
+	events := make(chan SubscriptionEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = sub.write(wsMessage{ID: subscriptionID, Type: wsCompleteMsg})
+		case <-done:
+		}
+		conn.Close()
+	}()
+	streaming = true
+	go func() {
+		defer span.End()
+		defer close(events)
+		defer close(done)
+		sub.read(ctx, events)
+	}()
+
+	return events, nil
+}
+
+// subscriptionConn is a downstream graphql-transport-ws connection carrying a
+// single subscription.
+type subscriptionConn struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+}
+
+func (s *subscriptionConn) write(msg wsMessage) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+// init performs the connection handshake and starts the subscription. The
+// service has to acknowledge the connection within the given timeout.
+func (s *subscriptionConn) init(request *Request, timeout time.Duration) error {
+	if timeout > 0 {
+		_ = s.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer func() { _ = s.conn.SetReadDeadline(time.Time{}) }()
+	}
+
+	if err := s.write(wsMessage{Type: wsConnectionInitMsg}); err != nil {
+		return fmt.Errorf("unable to initialize connection: %w", err)
+	}
+
+	for {
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return fmt.Errorf("unable to initialize connection: %w", err)
+		}
+		switch msg.Type {
+		case wsConnectionAckMsg:
+		case wsPingMsg:
+			if err := s.write(wsMessage{Type: wsPongMsg}); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("unexpected message type %q during connection initialization", msg.Type)
+		}
+		break
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("unable to encode request body: %w", err)
+	}
+
+	return s.write(wsMessage{ID: subscriptionID, Type: wsSubscribeMsg, Payload: payload})
+}
+
+// read forwards the subscription events to the channel until the
+// subscription ends.
+func (s *subscriptionConn) read(ctx context.Context, events chan<- SubscriptionEvent) {
+	send := func(event SubscriptionEvent) bool {
+		if event.Err != nil {
+			span := trace.SpanFromContext(ctx)
+			span.RecordError(event.Err)
+			span.SetStatus(codes.Error, event.Err.Error())
+		}
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				send(SubscriptionEvent{Err: fmt.Errorf("subscription connection failed: %w", err)})
+			}
+			return
+		}
+
+		switch msg.Type {
+		case wsPingMsg:
+			if err := s.write(wsMessage{Type: wsPongMsg}); err != nil {
+				send(SubscriptionEvent{Err: err})
+				return
+			}
+		case wsNextMsg:
+			var data map[string]interface{}
+			response := Response{Data: &data}
+			if err := json.Unmarshal(msg.Payload, &response); err != nil {
+				if !send(SubscriptionEvent{Err: fmt.Errorf("error decoding response: %w", err)}) {
+					return
+				}
+				continue
+			}
+			event := SubscriptionEvent{Data: data}
+			if len(response.Errors) > 0 {
+				event.Err = response.Errors
+			}
+			if !send(event) {
+				return
+			}
+		case wsErrorMsg:
+			var errs GraphqlErrors
+			if err := json.Unmarshal(msg.Payload, &errs); err != nil || len(errs) == 0 {
+				errs = GraphqlErrors{{Message: "subscription failed"}}
+			}
+			send(SubscriptionEvent{Err: errs})
+			return
+		case wsCompleteMsg:
+			return
+		}
+	}
+}
+
+func websocketURL(url string) (string, error) {
+	switch {
+	case strings.HasPrefix(url, "http://"):
+		return "ws://" + strings.TrimPrefix(url, "http://"), nil
+	case strings.HasPrefix(url, "https://"):
+		return "wss://" + strings.TrimPrefix(url, "https://"), nil
+	case strings.HasPrefix(url, "ws://"), strings.HasPrefix(url, "wss://"):
+		return url, nil
+	default:
+		return "", fmt.Errorf("unsupported subscription URL %q", url)
+	}
+}
+
+// ExecuteSubscription plans the subscription operation and opens a
+// subscription on the service owning the root field. Every event received is
+// enriched by running the remaining boundary steps of the plan before being
+// returned by the response handler.
+func (s *ExecutableSchema) ExecuteSubscription(ctx context.Context) graphql.ResponseHandler {
+	operationCtx := graphql.GetOperationContext(ctx)
+	operation := operationCtx.Operation
+	variables := operationCtx.Variables
+
+	ctx, span := s.tracer.Start(ctx, "Federated GraphQL Subscription",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			semconv.GraphqlOperationTypeKey.String(string(operation.Operation)),
+			semconv.GraphqlOperationName(operationCtx.OperationName),
+			semconv.GraphqlDocument(operationCtx.RawQuery),
+		),
+	)
+
+	// once started, the span ends with the subscription
+	streaming := false
+	defer func() {
+		if !streaming {
+			span.End()
+		}
+	}()
+
+	traceErr := func(err error) {
+		if err == nil {
+			return
+		}
+
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	for _, plugin := range s.plugins {
+		plugin.InterceptRequest(ctx, operation.Name, operationCtx.RawQuery, variables)
+	}
+
+	AddField(ctx, "operation.name", operation.Name)
+	AddField(ctx, "operation.type", operation.Operation)
+
+	errorResponse := func(errs gqlerror.List) graphql.ResponseHandler {
+		traceErr(errs)
+		AddField(ctx, "errors", errs)
+		return graphql.OneShot(s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Errors: errs,
+		}))
+	}
+
+	if errs := s.checkQueryLimits(ctx, operation); len(errs) > 0 {
+		return errorResponse(errs)
+	}
+
+	// The subscription can outlive schema updates, it keeps the snapshot it
+	// was planned with
+	snapshot := s.currentSnapshot()
+	if snapshot.usage != nil {
+		snapshot.usage.record(snapshot.schema, operation)
+	}
+	operation = s.evaluateSkipAndInclude(variables, operation)
+	evaluateIncrementalDirectives(variables, operation.SelectionSet, false)
+	filteredSchema := snapshot.schema
+
+	var errs gqlerror.List
+	perms, hasPerms := GetPermissionsFromContext(ctx)
+	if hasPerms {
+		filteredSchema = perms.FilterSchema(snapshot.schema)
+		errs = perms.FilterAuthorizedFields(operation)
+	}
+
+	plan, err := Plan(&PlanningContext{
+		Operation:  operation,
+		Schema:     filteredSchema,
+		Locations:  snapshot.locations,
+		IsBoundary: snapshot.isBoundary,
+		Services:   snapshot.services,
+	})
+	if err != nil {
+		return errorResponse(append(errs, gqlerror.Errorf("%s", err.Error())))
+	}
+
+	rootStep, err := subscriptionRootStep(plan)
+	if err != nil {
+		return errorResponse(append(errs, gqlerror.Errorf("%s", err.Error())))
+	}
+
+	if debugInfo, ok := ctx.Value(DebugKey).(DebugInfo); ok && debugInfo.Plan {
+		graphql.RegisterExtension(ctx, "plan", plan)
+	}
+
+	document, documentVariables := formatDocument(ctx, filteredSchema, rootStep.ParentType, rootStep.SelectionSet)
+	req := NewRequest(document).
+		WithVariables(documentVariables).
+		WithHeaders(GetOutgoingRequestHeadersFromContext(ctx)).
+		WithOperationName(operationCtx.OperationName).
+		WithOperationType(rootStep.ParentType)
+	if svc, ok := snapshot.services[rootStep.ServiceURL]; ok {
+		// the timeout of the service doesn't apply to the event stream
+		req = svc.Config.apply(req).WithTimeout(0)
+	}
+
+	newExecution := func(ctx context.Context) *queryExecution {
+		qe := newQueryExecution(ctx, operationCtx.OperationName, s.GraphqlClient, filteredSchema, snapshot.boundaryQueries, int32(s.MaxRequestsPerQuery))
+		qe.entityCache = snapshot.entityCache
+		qe.services = snapshot.services
+		qe.batching = s.boundaryBatching()
+		return qe
+	}
+
+	// the subscription is in flight until it completes, the downstream
+	// subscription is completed when the gateway drains
+	subscriptionCtx, cancel := context.WithCancel(ctx)
+	done := s.operations.start()
+	closed := s.operations.closed()
+	go func() {
+		select {
+		case <-closed:
+			cancel()
+		case <-subscriptionCtx.Done():
+		}
+	}()
+
+	events, err := s.GraphqlClient.Subscribe(subscriptionCtx, rootStep.ServiceURL, req)
+	if err != nil {
+		cancel()
+		done()
+		return errorResponse(append(errs, newExecution(ctx).createGQLErrors(rootStep, err)...))
+	}
+	streaming = true
+	stop := sync.OnceFunc(func() {
+		cancel()
+		done()
+		span.End()
+	})
+	context.AfterFunc(ctx, stop)
+
+	return func(ctx context.Context) *graphql.Response {
+		var event SubscriptionEvent
+		select {
+		case <-ctx.Done():
+			stop()
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				stop()
+				return nil
+			}
+			event = e
+		}
+
+		results, executeErrs := newExecution(ctx).ExecuteSubscriptionEvent(rootStep, event.Data, event.Err)
+		if len(executeErrs) > 0 {
+			traceErr(executeErrs)
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: executeErrs,
+			})
+		}
+
+		eventErrs := append(gqlerror.List{}, errs...)
+		for _, result := range results {
+			eventErrs = append(eventErrs, result.Errors...)
+		}
+
+		mergedResult, err := mergeExecutionResults(results)
+		if err != nil {
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: append(eventErrs, &gqlerror.Error{Message: err.Error()}),
+			})
+		}
+
+		bubbleErrs, err := bubbleUpNullValuesInPlace(filteredSchema, operation.SelectionSet, mergedResult)
+		if err == errNullBubbledToRoot {
+			mergedResult = nil
+		} else if err != nil {
+			return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+				Errors: append(eventErrs, &gqlerror.Error{Message: err.Error()}),
+			})
+		}
+		eventErrs = append(eventErrs, bubbleErrs...)
+		if len(eventErrs) > 0 {
+			traceErr(eventErrs)
+		}
+
+		return s.interceptResponse(ctx, operation.Name, operationCtx.RawQuery, variables, &graphql.Response{
+			Data:   formatResponseData(filteredSchema, operation.SelectionSet, mergedResult),
+			Errors: eventErrs,
+		})
+	}
+}
+
+// subscriptionRootStep returns the single downstream step of a subscription
+// plan. A subscription can only have a single root field so it is always
+// served by a single service.
+func subscriptionRootStep(plan *QueryPlan) (*QueryPlanStep, error) {
+	if len(plan.RootSteps) != 1 {
+		return nil, errors.New("subscription operations must select a single root field")
+	}
+	step := plan.RootSteps[0]
+	if step.ServiceURL == internalServiceName {
+		return nil, errors.New("subscription operations must select a field from a federated service")
+	}
+	return step, nil
+}
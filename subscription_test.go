@@ -0,0 +1,305 @@
+package bramble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const subscriptionMovieServiceSchema = `
+directive @boundary on OBJECT | FIELD_DEFINITION
+
+type Service {
+	name: String!
+	version: String!
+	schema: String!
+}
+
+type Movie @boundary {
+	id: ID!
+	title: String!
+}
+
+type Query {
+	service: Service!
+	movie(id: ID!): Movie @boundary
+}
+
+type Subscription {
+	movieUpdated: Movie!
+}`
+
+const subscriptionReleaseServiceSchema = `
+directive @boundary on OBJECT | FIELD_DEFINITION
+
+type Service {
+	name: String!
+	version: String!
+	schema: String!
+}
+
+type Movie @boundary {
+	id: ID!
+	release: Int
+}
+
+type Query {
+	service: Service!
+	movie(id: ID!): Movie @boundary
+}`
+
+func writeServiceSchema(w http.ResponseWriter, name, schema string) {
+	encodedSchema, _ := json.Marshal(schema)
+	fmt.Fprintf(w, `{
+		"data": {
+			"service": {
+				"schema": %s,
+				"version": "1.0",
+				"name": %q
+			}
+		}
+	}`, string(encodedSchema), name)
+}
+
+// newSubscriptionMovieService returns a service sending the provided events
+// on every subscription and then completing it. Messages received from the
+// gateway are sent on the received channel.
+func newSubscriptionMovieService(t *testing.T, events []string, complete bool, received chan<- wsMessage) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{graphqlTransportWSProtocol}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			writeServiceSchema(w, "movie", subscriptionMovieServiceSchema)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsConnectionInitMsg, msg.Type)
+		require.NoError(t, conn.WriteJSON(wsMessage{Type: wsConnectionAckMsg}))
+
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, wsSubscribeMsg, msg.Type)
+		received <- msg
+
+		for _, event := range events {
+			require.NoError(t, conn.WriteJSON(wsMessage{ID: msg.ID, Type: wsNextMsg, Payload: json.RawMessage(event)}))
+		}
+		if complete {
+			require.NoError(t, conn.WriteJSON(wsMessage{ID: msg.ID, Type: wsCompleteMsg}))
+		}
+
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+}
+
+func newSubscriptionReleaseService(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if strings.Contains(req.Query, "service") {
+			writeServiceSchema(w, "release", subscriptionReleaseServiceSchema)
+			return
+		}
+		assert.Contains(t, req.Query, `movie(id: $_bramble_id_0)`)
+		assert.Equal(t, "1", req.Variables["_bramble_id_0"])
+		w.Write([]byte(`{"data": {"_0": {"_bramble_id": "1", "_bramble__typename": "Movie", "release": 1993}}}`))
+	}))
+}
+
+func dialGatewaySubscription(t *testing.T, gatewayURL string, query string) *websocket.Conn {
+	dialer := websocket.Dialer{Subprotocols: []string{graphqlTransportWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(gatewayURL, "http")+"/query", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsConnectionInitMsg}))
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, wsConnectionAckMsg, msg.Type)
+
+	payload, _ := json.Marshal(map[string]string{"query": query})
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "sub", Type: wsSubscribeMsg, Payload: payload}))
+	return conn
+}
+
+func readGatewayMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg wsMessage
+	for {
+		require.NoError(t, conn.ReadJSON(&msg))
+		if msg.Type != wsPingMsg {
+			return msg
+		}
+	}
+}
+
+func newTestGateway(t *testing.T, services ...*httptest.Server) *httptest.Server {
+	var svcs []*Service
+	for _, s := range services {
+		svcs = append(svcs, NewService(s.URL))
+	}
+	executableSchema := NewExecutableSchema(nil, 50, nil, svcs...)
+	require.NoError(t, executableSchema.UpdateSchema(context.Background(), true))
+	return httptest.NewServer(NewGateway(executableSchema, nil).Router(&Config{}))
+}
+
+func TestGatewaySubscription(t *testing.T) {
+	received := make(chan wsMessage, 10)
+	movieService := newSubscriptionMovieService(t, []string{
+		`{"data": {"movieUpdated": {"title": "Jurassic Park", "_bramble_id": "1", "_bramble__typename": "Movie"}}}`,
+		`{"data": {"movieUpdated": {"title": "Jurassic Park II", "_bramble_id": "1", "_bramble__typename": "Movie"}}}`,
+	}, true, received)
+	defer movieService.Close()
+	releaseService := newSubscriptionReleaseService(t)
+	defer releaseService.Close()
+
+	gateway := newTestGateway(t, movieService, releaseService)
+	defer gateway.Close()
+
+	conn := dialGatewaySubscription(t, gateway.URL, "subscription { movieUpdated { title release } }")
+	defer conn.Close()
+
+	msg := readGatewayMessage(t, conn)
+	require.Equal(t, wsNextMsg, msg.Type)
+	assert.Equal(t, "sub", msg.ID)
+	assert.JSONEq(t, `{"data": {"movieUpdated": {"title": "Jurassic Park", "release": 1993}}}`, string(msg.Payload))
+
+	msg = readGatewayMessage(t, conn)
+	require.Equal(t, wsNextMsg, msg.Type)
+	assert.JSONEq(t, `{"data": {"movieUpdated": {"title": "Jurassic Park II", "release": 1993}}}`, string(msg.Payload))
+
+	msg = readGatewayMessage(t, conn)
+	assert.Equal(t, wsCompleteMsg, msg.Type)
+	assert.Equal(t, "sub", msg.ID)
+
+	subscribe := <-received
+	var req Request
+	require.NoError(t, json.Unmarshal(subscribe.Payload, &req))
+	assert.Equal(t, "subscription", req.OperationType)
+	assert.Equal(t, "subscription { movieUpdated { title _bramble_id: id _bramble__typename: __typename } }", strings.Join(strings.Fields(req.Query), " "))
+}
+
+func TestGatewaySubscriptionClientComplete(t *testing.T) {
+	received := make(chan wsMessage, 10)
+	movieService := newSubscriptionMovieService(t, []string{
+		`{"data": {"movieUpdated": {"title": "Jurassic Park", "_bramble_id": "1", "_bramble__typename": "Movie"}}}`,
+	}, false, received)
+	defer movieService.Close()
+
+	gateway := newTestGateway(t, movieService)
+	defer gateway.Close()
+
+	conn := dialGatewaySubscription(t, gateway.URL, "subscription { movieUpdated { title } }")
+	defer conn.Close()
+
+	msg := readGatewayMessage(t, conn)
+	require.Equal(t, wsNextMsg, msg.Type)
+	assert.JSONEq(t, `{"data": {"movieUpdated": {"title": "Jurassic Park"}}}`, string(msg.Payload))
+
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "sub", Type: wsCompleteMsg}))
+
+	require.Equal(t, wsSubscribeMsg, (<-received).Type)
+	select {
+	case msg := <-received:
+		assert.Equal(t, wsCompleteMsg, msg.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("downstream subscription was not completed")
+	}
+}
+
+func TestGatewaySubscriptionSpans(t *testing.T) {
+	received := make(chan wsMessage, 10)
+	movieService := newSubscriptionMovieService(t, []string{
+		`{"data": {"movieUpdated": {"title": "Jurassic Park", "_bramble_id": "1", "_bramble__typename": "Movie"}}}`,
+		`{"data": null, "errors": [{"message": "movie not found"}]}`,
+	}, false, received)
+	defer movieService.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	executableSchema := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL))
+	executableSchema.tracer = tracer
+	executableSchema.GraphqlClient.tracer = tracer
+	require.NoError(t, executableSchema.UpdateSchema(context.Background(), true))
+	gateway := httptest.NewServer(NewGateway(executableSchema, nil).Router(&Config{}))
+	defer gateway.Close()
+
+	subscriptionSpans := func() []sdktrace.ReadOnlySpan {
+		var spans []sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if strings.HasSuffix(span.Name(), "GraphQL Subscription") {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+
+	conn := dialGatewaySubscription(t, gateway.URL, "subscription { movieUpdated { title } }")
+	defer conn.Close()
+	require.Equal(t, wsNextMsg, readGatewayMessage(t, conn).Type)
+	require.Equal(t, wsNextMsg, readGatewayMessage(t, conn).Type)
+	assert.Empty(t, subscriptionSpans(), "the spans end with the subscription")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "sub", Type: wsCompleteMsg}))
+	require.Eventually(t, func() bool {
+		return len(subscriptionSpans()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	for _, span := range subscriptionSpans() {
+		assert.Equal(t, codes.Error, span.Status().Code, span.Name())
+		require.NotEmpty(t, span.Events(), span.Name())
+		assert.Equal(t, "exception", span.Events()[0].Name, span.Name())
+	}
+}
+
+func TestGatewaySubscriptionPermissions(t *testing.T) {
+	movieService := newSubscriptionMovieService(t, nil, true, make(chan wsMessage, 10))
+	defer movieService.Close()
+
+	executableSchema := NewExecutableSchema(nil, 50, nil, NewService(movieService.URL))
+	require.NoError(t, executableSchema.UpdateSchema(context.Background(), true))
+
+	query := gqlparser.MustLoadQuery(executableSchema.MergedSchema, "subscription { movieUpdated { title } }")
+	ctx := testContextWithNoPermissions(query.Operations[0])
+
+	response := executableSchema.Exec(ctx)(ctx)
+	require.NotNil(t, response)
+	require.NotEmpty(t, response.Errors)
+	assert.Equal(t, "subscription.movieUpdated access disallowed", response.Errors[0].Message)
+}
+
+func TestWebsocketURL(t *testing.T) {
+	for input, expected := range map[string]string{
+		"http://movies/query":  "ws://movies/query",
+		"https://movies/query": "wss://movies/query",
+		"ws://movies/query":    "ws://movies/query",
+	} {
+		url, err := websocketURL(input)
+		require.NoError(t, err)
+		assert.Equal(t, expected, url)
+	}
+
+	_, err := websocketURL("movies/query")
+	assert.Error(t, err)
+}